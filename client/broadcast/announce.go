@@ -0,0 +1,55 @@
+package broadcast
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/gfleury/solo/client/broadcast/prp"
+	"github.com/ipfs/go-log"
+)
+
+// ANNOUNCE_DELAY is the wait before the first announcement, for the first
+// connections to be established
+const ANNOUNCE_DELAY = 5 * time.Second
+
+// announceJitter returns d randomly spread between half and one and a half
+// of it, so peers started together don't announce at the same time
+func announceJitter(d time.Duration) time.Duration {
+	return d/2 + time.Duration(rand.Int63n(int64(d)))
+}
+
+// announceLoop announces ourselves every PRP_ANNOUNCE_INTERVAL, asks a
+// neighbour for its table while we only know ourselves and reports the peers
+// whose announcements stopped
+func announceLoop(ctx context.Context, logger log.StandardLogger, b Broadcaster, table *prp.PRPTableType) {
+	t := time.NewTimer(announceJitter(ANNOUNCE_DELAY))
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+
+		alone := table.Len() <= 1
+		if alone {
+			if err := b.SyncTable(ctx); err != nil {
+				logger.Debugf("Failed to request the PRP table: %s", err)
+			}
+		}
+		if err := b.AnnounceMyself(ctx); err != nil {
+			logger.Debugf("Failed to announce ourselves: %s", err)
+		}
+		for ip, machine := range table.CheckMissingPeers() {
+			logger.Warnf("Peer %s (%s) did not announce itself for %s", machine.PeerID, ip, prp.PRP_PEER_MISSING_AFTER)
+		}
+
+		// Keep trying soon until we learn about other peers
+		if alone {
+			t.Reset(announceJitter(ANNOUNCE_DELAY))
+		} else {
+			t.Reset(announceJitter(prp.PRP_ANNOUNCE_INTERVAL))
+		}
+	}
+}
@@ -3,7 +3,6 @@ package broadcast
 import (
 	"context"
 	"crypto/sha256"
-	"encoding/json"
 	"fmt"
 	"strings"
 	"sync"
@@ -12,11 +11,13 @@ import (
 	"github.com/gfleury/solo/client/broadcast/metapacket"
 	"github.com/gfleury/solo/client/broadcast/prp"
 	"github.com/gfleury/solo/client/crypto"
+	"github.com/gfleury/solo/client/protocol"
 	"github.com/gfleury/solo/common/models"
 	"github.com/ipfs/go-log"
 
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 )
 
@@ -26,15 +27,24 @@ type Broadcaster interface {
 	SendPacket(ctx context.Context, packet *metapacket.MetaPacket) error
 	AnnounceMyself(ctx context.Context) error
 	PRPRequest(ctx context.Context, unknownDstIP string) error
+	// SyncTable asks a neighbour for its PRP table, for peers that just joined
+	SyncTable(ctx context.Context) error
+	// SyncTableFrom asks peerID for its PRP table, its own signed reply in it
+	// proves it is a member
+	SyncTableFrom(ctx context.Context, peerID peer.ID) error
+	Table() *prp.PRPTableType
 }
 
 type DefaultBroadcaster struct {
 	sync.Mutex
 
 	pubSub *pubsub.PubSub
-	topic  *pubsub.Topic
+	// topics of the current and neighbour OTP windows, with their readLoop cancel
+	topics  map[string]*pubsub.Topic
+	cancels map[string]context.CancelFunc
 
 	selfID peer.ID
+	host   host.Host
 
 	maxsize int
 	otpKey  *crypto.OTPKey
@@ -43,26 +53,41 @@ type DefaultBroadcaster struct {
 
 	logger log.StandardLogger
 
-	PRPTable *prp.PRPTableType
+	PRPTable           *prp.PRPTableType
+	publishLocalRoutes bool
+}
+
+// unsealedMessage is the validated message passed by the topic validator to readLoop
+type unsealedMessage struct {
+	data   []byte
+	window int
 }
 
 func NewBroadcaster(
 	logger log.StandardLogger,
 	otpKey *crypto.OTPKey,
 	maxsize int,
+	publishLocalRoutes bool,
 ) Broadcaster {
 	return &DefaultBroadcaster{
-		otpKey:   otpKey,
-		maxsize:  maxsize,
-		sealer:   &crypto.DefaultSealer{},
-		logger:   logger,
-		PRPTable: prp.NewPRPTable(),
+		otpKey:             otpKey,
+		maxsize:            maxsize,
+		sealer:             &crypto.DefaultSealer{},
+		logger:             logger,
+		PRPTable:           prp.NewPRPTable(),
+		publishLocalRoutes: publishLocalRoutes,
+		topics:             map[string]*pubsub.Topic{},
+		cancels:            map[string]context.CancelFunc{},
 	}
 }
 func (m *DefaultBroadcaster) Lookup(dstIP string) (*models.NetworkNode, bool, bool) {
 	return m.PRPTable.Lookup(dstIP)
 }
 
+func (m *DefaultBroadcaster) Table() *prp.PRPTableType {
+	return m.PRPTable
+}
+
 func (m *DefaultBroadcaster) topicKey(salts ...string) string {
 	totp := m.otpKey.TOTP(sha256.New)
 	if len(salts) > 0 {
@@ -71,174 +96,306 @@ func (m *DefaultBroadcaster) topicKey(salts ...string) string {
 	return crypto.MD5(totp)
 }
 
-func (m *DefaultBroadcaster) joinBroadcast(ctxCancel context.CancelFunc) (context.CancelFunc, error) {
-	var err error
-	var ctx context.Context
+// topicKeys are the topics of the current and neighbour OTP windows, we stay
+// subscribed to all of them so peers with skewed clocks still reach us, and
+// the next topic is joined a whole window before we start publishing on it,
+// so its mesh is already formed when the topics rotate
+func (m *DefaultBroadcaster) topicKeys() []string {
+	keys := []string{}
+	for _, totp := range m.otpKey.TOTPWindows(sha256.New) {
+		keys = append(keys, crypto.MD5(totp))
+	}
+	return keys
+}
+
+// syncTopics joins the topics of the OTP windows around now and leaves the old ones
+func (m *DefaultBroadcaster) syncTopics() error {
 	m.Lock()
 	defer m.Unlock()
 
-	if ctxCancel != nil {
-		ctxCancel()
-	}
+	keys := map[string]bool{}
+	for _, key := range m.topicKeys() {
+		keys[key] = true
+		if _, joined := m.topics[key]; joined {
+			continue
+		}
+
+		m.logger.Debugf("Joining new broadcast room: %s", key)
+		topic, subscription, err := m.joinAndSubscribe(key)
+		if err != nil {
+			return err
+		}
 
-	ctx, ctxCancel = context.WithCancel(context.Background())
+		ctx, ctxCancel := context.WithCancel(context.Background())
+		m.topics[key] = topic
+		m.cancels[key] = ctxCancel
 
-	// join the broadcast room
-	subscription, err := m.joinAndSubscribe()
-	if err != nil {
-		return ctxCancel, err
+		// start reading messages from the subscription in a loop
+		go m.readLoop(ctx, topic, subscription)
 	}
 
-	// start reading messages from the subscription in a loop
-	go m.readLoop(ctx, subscription)
+	for key, ctxCancel := range m.cancels {
+		if keys[key] {
+			continue
+		}
+		m.logger.Debugf("Leaving broadcast room: %s", key)
+		ctxCancel()
+		m.pubSub.UnregisterTopicValidator(key)
+		delete(m.cancels, key)
+		delete(m.topics, key)
+	}
 
-	return ctxCancel, nil
+	return nil
 }
 
 func (m *DefaultBroadcaster) Start(ctx context.Context, host host.Host, myIP string) error {
 	var err error
 
 	// Insert myself on the PRPTable
-	myselfMachine := models.NewLocalNode(host, myIP)
+	myselfMachine := models.NewLocalNodeWithRoutes(host, myIP, m.publishLocalRoutes)
 	m.PRPTable.InsertMyselfEntry(&myselfMachine)
+	m.Lock()
 	m.selfID = host.ID()
+	m.host = host
+	m.Unlock()
+	m.PRPTable.SetSigningKey(host.Peerstore().PrivKey(host.ID()))
 
-	c := make(chan interface{})
-	go func(c context.Context, cc chan interface{}) {
-		k := ""
-		for {
-			select {
-			default:
-				currentKey := m.topicKey()
-				if currentKey != k {
-					k = currentKey
-					cc <- nil
-				}
-				time.Sleep(1 * time.Second)
-			case <-ctx.Done():
-				close(cc)
-				return
-			}
-		}
-	}(ctx, c)
+	// Table syncs are exchanged point to point instead of on the topic
+	host.SetStreamHandler(protocol.BROADCAST_DIRECT.ID(), m.directStreamHandler)
 
 	m.logger.Debug("Creating PubGossipSub")
 	// create a new PubSub service using the GossipSub router
-	m.pubSub, err = pubsub.NewGossipSub(ctx, host, pubsub.WithMaxMessageSize(m.maxsize))
+	m.pubSub, err = pubsub.NewGossipSub(ctx, host,
+		pubsub.WithMaxMessageSize(m.maxsize),
+		pubsub.WithPeerScore(peerScoreParams(), peerScoreThresholds),
+	)
 	if err != nil {
 		return err
 	}
 	m.logger.Debug("Created PubGossipSub")
 
-	var ctxCancel context.CancelFunc
+	go announceLoop(ctx, m.logger, m, m.PRPTable)
 
-	for range c {
-		m.logger.Debugf("Joining new broadcast room: %s", m.topicKey())
-		ctxCancel, err = m.joinBroadcast(ctxCancel)
-		if err != nil {
+	t := time.NewTicker(1 * time.Second)
+	defer t.Stop()
+	for {
+		if err = m.syncTopics(); err != nil {
 			m.logger.Errorf("Broadcast main loop error: %s", err)
 			break
 		}
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
 	}
 
 	// Close eventual open contexts
-	if ctxCancel != nil {
+	m.Lock()
+	for _, ctxCancel := range m.cancels {
 		ctxCancel()
 	}
+	m.Unlock()
 
 	return nil
 }
 
 // Publish a MetaPacket to the PubSub
 func (m *DefaultBroadcaster) SendPacket(ctx context.Context, packet *metapacket.MetaPacket) error {
-	bytesPacket, err := json.Marshal(packet)
+	bytesPacket, err := packet.Marshal()
 	if err != nil {
 		return err
 	}
 	return m.publishMessage(ctx, bytesPacket)
 }
 
-// Publish a raw message to the PubSub
+// Publish a raw message to the PubSub topic of the current OTP window
 func (m *DefaultBroadcaster) publishMessage(ctx context.Context, message []byte) error {
 	m.Lock()
 	defer m.Unlock()
-	if m.topic != nil {
+	if topic, found := m.topics[m.topicKey()]; found {
 		sealedPacket, err := m.sealer.Seal(message, m.sealKey())
 		if err != nil {
 			return err
 		}
 
-		return m.topic.Publish(ctx, sealedPacket)
+		return topic.Publish(ctx, sealedPacket)
 	}
 	return fmt.Errorf("there is no topic ready still")
 }
 
 // readLoop pulls messages from the pubsub topic and pushes them onto the Messages channel.
-func (m *DefaultBroadcaster) readLoop(ctx context.Context, subscription *pubsub.Subscription) {
+func (m *DefaultBroadcaster) readLoop(ctx context.Context, topic *pubsub.Topic, subscription *pubsub.Subscription) {
+	defer func() {
+		m.logger.Debug("Leaving readLoop since context is gone")
+		subscription.Cancel()
+		topic.Close()
+	}()
+
 	for {
-		select {
-		case <-ctx.Done():
-			m.Lock()
-			defer m.Unlock()
-			m.logger.Debug("Leaving readLoop since context is gone")
-			subscription.Cancel()
-			m.topic.Close()
+		msg, err := subscription.Next(ctx)
+		if err != nil {
 			return
-		default:
-			msg, err := subscription.Next(ctx)
+		}
 
-			if err != nil {
-				return
-			}
+		// only forward messages delivered by others
+		if msg.ReceivedFrom == m.selfID {
+			continue
+		}
 
-			// only forward messages delivered by others
-			if msg.ReceivedFrom == m.selfID {
-				continue
-			}
+		// Unsealed by the topic validator
+		unsealed, ok := msg.ValidatorData.(*unsealedMessage)
+		if !ok {
+			continue
+		}
 
-			unsealedPacket, err := m.sealer.Unseal(msg.Data, m.sealKey())
-			if err != nil {
-				m.logger.Warnf("Fail to unseal receiving message %w from", err.Error())
-			}
+		cm, err := metapacket.Unmarshal(unsealed.data)
+		if err != nil {
+			m.logger.Errorf("Unable to unmarshal received MetaPacket: %s", err)
+			continue
+		}
 
-			cm := &metapacket.MetaPacket{}
-			err = json.Unmarshal(unsealedPacket, cm)
-			if err != nil {
-				m.logger.Errorf("Unable to unmarshal received MetaPacket: %s", err)
-				continue
-			}
+		cm.SetSenderID(msg.ReceivedFrom.String())
+		// The origin is authenticated by the pubsub message signature
+		cm.SetOrigin(msg.GetFrom().String())
+		checkClockSkew(m.logger, cm, unsealed.window)
+
+		m.process(ctx, cm, msg.GetFrom())
+	}
+}
+
+// process processes a received packet, the replies concerning only its
+// origin are sent back to it directly, the others are published
+func (m *DefaultBroadcaster) process(ctx context.Context, cm *metapacket.MetaPacket, origin peer.ID) {
+	payload := cm.GetPayload()
+	if payload == nil {
+		return
+	}
+
+	replyPayload, err := payload.Process(m.logger, m.PRPTable)
+	if err != nil {
+		m.logger.Errorf("Unable to process received MetaPacket Payload: %s", err)
+		return
+	}
+	if replyPayload == nil {
+		return
+	}
+
+	if isUnicast(replyPayload) {
+		// Don't hold the topic readLoop while the stream is opened
+		go func() {
+			ctx, cancelFunc := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancelFunc()
 
-			cm.SenderID = msg.ReceivedFrom.String()
-
-			if payload := cm.GetPayload(); payload != nil {
-				replyPayload, err := payload.Process(m.logger, m.PRPTable)
-				if err != nil {
-					m.logger.Errorf("Unable to process received MetaPacket Payload: %s", err)
-					continue
-				}
-				if replyPayload != nil {
-					m.SendPacket(ctx, metapacket.NewFromPayload(replyPayload))
-				}
+			if err := m.sendDirect(ctx, origin, metapacket.NewFromPayload(replyPayload)); err != nil {
+				m.logger.Warnf("Failed to send the reply to %s: %s", origin, err)
 			}
-		}
+		}()
+		return
 	}
+	m.SendPacket(ctx, metapacket.NewFromPayload(replyPayload))
 }
 
-// connect tries to subscribe to the PubSub topic for the room name, returning
-// a Room on success.
-func (m *DefaultBroadcaster) joinAndSubscribe() (*pubsub.Subscription, error) {
-	var err error
+// sendDirect sends the packet only to peerID, on a stream of its own
+func (m *DefaultBroadcaster) sendDirect(ctx context.Context, peerID peer.ID, packet *metapacket.MetaPacket) error {
+	bytesPacket, err := packet.Marshal()
+	if err != nil {
+		return err
+	}
+	sealedPacket, err := m.sealer.Seal(bytesPacket, m.sealKey())
+	if err != nil {
+		return err
+	}
+
+	stream, err := m.host.NewStream(ctx, peerID, protocol.BROADCAST_DIRECT.ID())
+	if err != nil {
+		return err
+	}
+
+	stream.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if err := writeFrame(stream, sealedPacket); err != nil {
+		stream.Reset()
+		return err
+	}
+	return stream.Close()
+}
+
+// directStreamHandler processes the packet sent to us by sendDirect, the
+// stream authenticates its origin
+func (m *DefaultBroadcaster) directStreamHandler(stream network.Stream) {
+	defer stream.Close()
+
+	remotePeer := stream.Conn().RemotePeer()
+	stream.SetReadDeadline(time.Now().Add(5 * time.Second))
+	msg, err := readFrame(stream)
+	if err != nil {
+		m.logger.Warnf("Fail to receive direct message from %s: %s", remotePeer, err)
+		stream.Reset()
+		return
+	}
+
+	unsealedPacket, window, err := unsealWindows(m.sealer, msg, m.otpKey)
+	if err != nil {
+		m.logger.Warnf("Fail to unseal direct message from %s: %s", remotePeer, err)
+		return
+	}
+
+	cm, err := metapacket.Unmarshal(unsealedPacket)
+	if err != nil {
+		m.logger.Errorf("Unable to unmarshal received MetaPacket: %s", err)
+		return
+	}
+
+	cm.SetSenderID(remotePeer.String())
+	cm.SetOrigin(remotePeer.String())
+	checkClockSkew(m.logger, cm, window)
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFunc()
+	m.process(ctx, cm, remotePeer)
+}
+
+// validate rejects the messages we fail to unseal, they are not delivered nor
+// forwarded and their sender is penalized by the peer scoring
+func (m *DefaultBroadcaster) validate(ctx context.Context, from peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+	unsealedPacket, window, err := unsealWindows(m.sealer, msg.Data, m.otpKey)
+	if err != nil {
+		m.logger.Warnf("Fail to unseal receiving message from %s: %s", from, err.Error())
+		return pubsub.ValidationReject
+	}
+	msg.ValidatorData = &unsealedMessage{data: unsealedPacket, window: window}
+	return pubsub.ValidationAccept
+}
+
+// joinAndSubscribe joins the PubSub topic named key and subscribes to it
+func (m *DefaultBroadcaster) joinAndSubscribe(key string) (*pubsub.Topic, *pubsub.Subscription, error) {
+	m.logger.Debugf("Joining Topic: %s", key)
+	if err := m.pubSub.RegisterTopicValidator(key, m.validate); err != nil {
+		return nil, nil, err
+	}
 
-	m.logger.Debugf("Joining Topic: %s", m.topicKey())
 	// join the pubsub topic
-	m.topic, err = m.pubSub.Join(m.topicKey())
+	topic, err := m.pubSub.Join(key)
 	if err != nil {
-		return nil, err
+		m.pubSub.UnregisterTopicValidator(key)
+		return nil, nil, err
 	}
 
-	// and subscribe to it
-	return m.topic.Subscribe()
+	if err := topic.SetScoreParams(topicScoreParams()); err != nil {
+		m.logger.Warnf("Failed to set the score parameters of topic %s: %s", key, err)
+	}
 
+	// and subscribe to it
+	subscription, err := topic.Subscribe()
+	if err != nil {
+		topic.Close()
+		m.pubSub.UnregisterTopicValidator(key)
+		return nil, nil, err
+	}
+	return topic, subscription, nil
 }
 
 func (m *DefaultBroadcaster) sealKey() []byte {
@@ -249,6 +406,44 @@ func (m *DefaultBroadcaster) PRPRequest(ctx context.Context, unknownDstIP string
 	return m.SendPacket(ctx, metapacket.NewFromPayload(prp.NewPRPRequestPacket(unknownDstIP)))
 }
 
+// AnnounceMyself announces our IP, and the MACs behind our TAP interface if any
 func (m *DefaultBroadcaster) AnnounceMyself(ctx context.Context) error {
-	return m.SendPacket(ctx, metapacket.NewFromPayload(m.PRPTable.PRPReplyMyself(true)))
+	payload := m.PRPTable.PRPReplyMyself(true)
+	if payload == nil {
+		return fmt.Errorf("broadcaster still not started")
+	}
+	if macs := m.PRPTable.PRPMACAnnounce(); macs != nil {
+		if err := m.SendPacket(ctx, metapacket.NewFromPayload(macs)); err != nil {
+			return err
+		}
+	}
+	return m.SendPacket(ctx, metapacket.NewFromPayload(payload))
+}
+
+// SyncTable asks a random peer of our topic for its PRP table, the request
+// and its reply are sent point to point, not published to the topic
+func (m *DefaultBroadcaster) SyncTable(ctx context.Context) error {
+	m.Lock()
+	topic, found := m.topics[m.topicKey()]
+	m.Unlock()
+	if !found {
+		return fmt.Errorf("there is no topic ready still")
+	}
+
+	peersIDs := fanoutPeers(topic.ListPeers())
+	if len(peersIDs) == 0 {
+		return fmt.Errorf("no peers to sync the table from")
+	}
+	return m.SyncTableFrom(ctx, peersIDs[0])
+}
+
+// SyncTableFrom asks peerID for its PRP table, point to point
+func (m *DefaultBroadcaster) SyncTableFrom(ctx context.Context, peerID peer.ID) error {
+	m.Lock()
+	started := m.host != nil
+	m.Unlock()
+	if !started {
+		return fmt.Errorf("broadcaster not started")
+	}
+	return m.sendDirect(ctx, peerID, metapacket.NewFromPayload(prp.NewPRPTableSyncRequestPacket(peerID.String())))
 }
@@ -3,9 +3,10 @@ package broadcast
 import (
 	"context"
 	"crypto/sha256"
-	"encoding/json"
 	"fmt"
+	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gfleury/solo/client/broadcast/metapacket"
@@ -13,6 +14,7 @@ import (
 	"github.com/gfleury/solo/client/crypto"
 	"github.com/gfleury/solo/client/discovery"
 	"github.com/gfleury/solo/client/protocol"
+	"github.com/gfleury/solo/client/utils"
 	"github.com/gfleury/solo/common/models"
 	"github.com/ipfs/go-log"
 
@@ -32,6 +34,8 @@ type StreamBroadcaster struct {
 	logger             log.StandardLogger
 	PRPTable           *prp.PRPTableType
 	publishLocalRoutes bool
+	seen               *seenCache
+	streams            *peerStreams
 }
 
 func NewStreamBroadcaster(
@@ -53,56 +57,91 @@ func NewStreamBroadcaster(
 		PRPTable:           prp.NewPRPTable(),
 		discoveryPeersIDs:  discoveryPeersIDs,
 		publishLocalRoutes: publishLocalRoutes,
+		seen:               newSeenCache(),
+		streams:            newPeerStreams(),
 	}
 }
 func (m *StreamBroadcaster) Lookup(dstIP string) (*models.NetworkNode, bool, bool) {
 	return m.PRPTable.Lookup(dstIP)
 }
 
-func (m *StreamBroadcaster) StreamHandler() func(stream network.Stream) {
-	var mutex sync.Mutex
-	msg := make([]byte, 1500)
+func (m *StreamBroadcaster) Table() *prp.PRPTableType {
+	return m.PRPTable
+}
 
+func (m *StreamBroadcaster) StreamHandler() func(stream network.Stream) {
 	return func(stream network.Stream) {
-		mutex.Lock()
-		defer mutex.Unlock()
+		defer stream.Reset()
 
 		m.logger.Debugf("New broadcast stream for %s", stream.Conn().RemotePeer())
-		n, err := stream.Read(msg)
-		if err != nil {
-			m.logger.Warnf("Fail to receive message: %s", err.Error())
-			return
-		}
+		// A stream carries many length prefixed messages, until the sender closes it
+		for {
+			msg, err := readFrame(stream)
+			if err == io.EOF {
+				return
+			} else if err != nil {
+				m.logger.Warnf("Fail to receive message: %s", err.Error())
+				return
+			}
 
-		unsealedPacket, err := m.sealer.Unseal(msg[:n], m.otpKey.TOTPSHA256(sha256.New))
-		if err != nil {
-			m.logger.Warnf("Fail to unseal receiving message: %s", err.Error())
-			return
+			m.handleMessage(msg, stream.Conn().RemotePeer())
 		}
+	}
+}
 
-		cm := &metapacket.MetaPacket{}
-		err = json.Unmarshal(unsealedPacket, cm)
+func (m *StreamBroadcaster) handleMessage(msg []byte, remotePeer peer.ID) {
+	unsealedPacket, window, err := unsealWindows(m.sealer, msg, &m.otpKey)
+	if err != nil {
+		m.logger.Warnf("Fail to unseal receiving message: %s", err.Error())
+		return
+	}
+
+	cm, err := metapacket.Unmarshal(unsealedPacket)
+	if err != nil {
+		m.logger.Errorf("Unable to unmarshal received MetaPacket: %s", err)
+		return
+	}
+
+	cm.SetSenderID(remotePeer.String())
+	// The connection only authenticates remotePeer, the packets it relays
+	// are trusted for their own signatures
+	if cm.OriginID == "" || cm.OriginID == cm.SenderID {
+		cm.SetOrigin(cm.SenderID)
+	}
+	checkClockSkew(m.logger, cm, window)
+
+	// Drop packets already received through another peer
+	if cm.ID != "" && !m.seen.Add(cm.ID) {
+		return
+	}
+
+	if hops := hopsLeft(cm.TTL); hops > 0 {
+		forward := cm.Copy()
+		forward.TTL = hops
+		go func() {
+			ctx, cancelFunc := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancelFunc()
+
+			m.gossip(ctx, forward, cm.SenderID)
+		}()
+	}
+
+	if payload := cm.GetPayload(); payload != nil {
+		replyPayload, err := payload.Process(m.logger, m.PRPTable)
 		if err != nil {
-			m.logger.Errorf("Unable to unmarshal received MetaPacket: %s", err)
+			m.logger.Errorf("Unable to process received MetaPacket Payload: %s", err)
 			return
 		}
+		if replyPayload != nil {
+			ctx, cancelFunc := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancelFunc()
 
-		cm.SenderID = stream.Conn().RemotePeer().String()
-
-		if payload := cm.GetPayload(); payload != nil {
-			replyPayload, err := payload.Process(m.logger, m.PRPTable)
-			if err != nil {
-				m.logger.Errorf("Unable to process received MetaPacket Payload: %s", err)
+			if isUnicast(replyPayload) {
+				m.sendDirect(ctx, remotePeer, metapacket.NewFromPayload(replyPayload))
 				return
 			}
-			if replyPayload != nil {
-				ctx, cancelFunc := context.WithTimeout(context.Background(), 5*time.Second)
-				defer cancelFunc()
-
-				m.SendPacket(ctx, metapacket.NewFromPayload(replyPayload))
-			}
+			m.SendPacket(ctx, metapacket.NewFromPayload(replyPayload))
 		}
-		stream.Reset()
 	}
 }
 
@@ -113,63 +152,143 @@ func (m *StreamBroadcaster) Ready() bool {
 }
 
 func (m *StreamBroadcaster) SendPacket(ctx context.Context, packet *metapacket.MetaPacket) error {
+	if !m.Ready() {
+		err := fmt.Errorf("Broadcaster still not ready")
+		m.logger.Error(err)
+		return err
+	}
 
-	ctxTimeout, cancelFunc := context.WithTimeout(ctx, 2*time.Second)
-	defer cancelFunc()
+	if packet.ID == "" {
+		packet = packet.Copy()
+		packet.ID = utils.RandStringRunes(16)
+		packet.TTL = GOSSIP_MAX_HOPS
+		packet.OriginID = m.selfHost.ID().String()
+		m.seen.Add(packet.ID)
+	}
+
+	return m.gossip(ctx, packet)
+}
 
+// gossip sends the packet to GOSSIP_FANOUT random peers, which forward it
+// further until its TTL is over, a failing peer doesn't stop the others
+func (m *StreamBroadcaster) gossip(ctx context.Context, packet *metapacket.MetaPacket, exclude ...string) error {
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
 
-	if !m.Ready() {
-		err := fmt.Errorf("Broadcaster still not ready")
-		m.logger.Error(err)
+	targets := fanoutPeers(m.discoveredPeers(), exclude...)
+	if len(targets) == 0 {
+		return nil
+	}
+
+	m.logger.Debugf("Broadcasting %s to peers: %s", packet.ID, targets)
+
+	sealedPacket, err := m.seal(packet)
+	if err != nil {
+		m.logger.Errorf("Broadcast failed with: %s", err)
 		return err
 	}
 
-	peersIDs := m.selfHost.Network().Peers()
-	regularPeersIDs := []peer.ID{}
+	var wg sync.WaitGroup
+	var failures atomic.Int32
+	for _, peerID := range targets {
+		wg.Add(1)
+		go func(peerID peer.ID) {
+			defer wg.Done()
+			if err := m.sendToPeer(ctx, peerID, sealedPacket); err != nil {
+				m.logger.Errorf("Broadcast to peer %s failed with: %s", peerID, err)
+				failures.Add(1)
+			}
+		}(peerID)
+	}
+	wg.Wait()
+
+	if int(failures.Load()) == len(targets) {
+		return fmt.Errorf("broadcast failed to all %d peers", len(targets))
+	}
+	return nil
+}
 
-	for _, peerID := range peersIDs {
+// discoveredPeers are the connected peers found by discovery, members of our network
+func (m *StreamBroadcaster) discoveredPeers() []peer.ID {
+	peersIDs := []peer.ID{}
+	for _, peerID := range m.selfHost.Network().Peers() {
 		// Filter peers that were not found by DHT
 		if !IsPeerFoundByDiscovery(m.selfHost, peerID) {
 			continue
 		}
-		regularPeersIDs = append(regularPeersIDs, peerID)
+		peersIDs = append(peersIDs, peerID)
 	}
+	return peersIDs
+}
 
-	m.logger.Debugf("Broadcasting to peers: %s", regularPeersIDs)
+func (m *StreamBroadcaster) seal(packet *metapacket.MetaPacket) ([]byte, error) {
+	bytesPacket, err := packet.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return m.sealer.Seal(bytesPacket, m.otpKey.TOTPSHA256(sha256.New))
+}
 
-	for _, peerID := range regularPeersIDs {
+// sendDirect sends the packet only to peerID, it isn't forwarded any further
+func (m *StreamBroadcaster) sendDirect(ctx context.Context, peerID peer.ID, packet *metapacket.MetaPacket) error {
+	packet = packet.Copy()
+	packet.ID = utils.RandStringRunes(16)
+	packet.TTL = 1
+	packet.OriginID = m.selfHost.ID().String()
 
-		bytesPacket, err := json.Marshal(packet)
-		if err != nil {
-			m.logger.Errorf("Broadcast to peer %s failed with: %s", peerID, err)
-			return err
-		}
-		sealedPacket, err := m.sealer.Seal(bytesPacket, m.otpKey.TOTPSHA256(sha256.New))
-		if err != nil {
-			m.logger.Errorf("Broadcast to peer %s failed with: %s", peerID, err)
-			return err
-		}
+	sealedPacket, err := m.seal(packet)
+	if err != nil {
+		return err
+	}
+	return m.sendToPeer(ctx, peerID, sealedPacket)
+}
 
-		stream, err := m.selfHost.NewStream(ctxTimeout, peerID, protocol.BROADCAST.ID())
-		if err != nil {
-			m.logger.Errorf("Broadcast to peer %s failed with: %s", peerID, err)
+// sendToPeer writes the sealed packet into a new broadcast stream to the peer, with retries
+func (m *StreamBroadcaster) sendToPeer(ctx context.Context, peerID peer.ID, sealedPacket []byte) error {
+	var err error
+	for attempt := 0; attempt < GOSSIP_RETRIES; attempt++ {
+		if err = m.writeToPeer(ctx, peerID, sealedPacket); err == nil || ctx.Err() != nil {
 			return err
 		}
+	}
+	return err
+}
 
-		n, err := stream.Write(sealedPacket)
-		if err != nil {
-			m.logger.Errorf("Broadcast to peer %s failed with: %s", peerID, err)
-			return err
-		} else if n != len(sealedPacket) {
-			m.logger.Errorf("Wrote wrong amount of bytes into broadcast stream, expected %s wrote %d", len(sealedPacket), n)
-			return err
-		}
+// writeToPeer writes the sealed packet into the broadcast stream to the peer,
+// the stream is kept open for the next packets and reopened if it fails
+func (m *StreamBroadcaster) writeToPeer(ctx context.Context, peerID peer.ID, sealedPacket []byte) error {
+	ps, err := m.peerStream(ctx, peerID)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	ps.Lock()
+	defer ps.Unlock()
+
+	ps.stream.SetWriteDeadline(time.Now().Add(2 * time.Second))
+	err = writeFrame(ps.stream, sealedPacket)
+	if err != nil {
+		ps.stream.Reset()
+		m.streams.Delete(peerID, ps)
+	}
+	return err
+}
+
+func (m *StreamBroadcaster) peerStream(ctx context.Context, peerID peer.ID) (*peerStream, error) {
+	if ps, found := m.streams.Get(peerID); found {
+		return ps, nil
+	}
+
+	ctxTimeout, cancelFunc := context.WithTimeout(ctx, 2*time.Second)
+	defer cancelFunc()
+
+	stream, err := m.selfHost.NewStream(ctxTimeout, peerID, protocol.BROADCAST.ID())
+	if err != nil {
+		return nil, err
+	}
+
+	return m.streams.Add(peerID, stream), nil
 }
 
 func (m *StreamBroadcaster) Start(ctx context.Context, host host.Host, myIP string) error {
@@ -180,11 +299,23 @@ func (m *StreamBroadcaster) Start(ctx context.Context, host host.Host, myIP stri
 	myselfMachine := models.NewLocalNodeWithRoutes(host, myIP, m.publishLocalRoutes)
 	m.PRPTable.InsertMyselfEntry(&myselfMachine)
 	m.selfHost = host
+	m.PRPTable.SetSigningKey(host.Peerstore().PrivKey(host.ID()))
 
 	// Set the VPN P2P stream handler (for incoming VPNPacket streams)
 	host.SetStreamHandler(protocol.BROADCAST.ID(), m.StreamHandler())
 
+	// Forget the outbound streams of peers gone away
+	host.Network().Notify(&network.NotifyBundle{
+		DisconnectedF: func(n network.Network, c network.Conn) {
+			if n.Connectedness(c.RemotePeer()) != network.Connected {
+				m.streams.Close(c.RemotePeer())
+			}
+		},
+	})
+
 	m.ready = true
+
+	go announceLoop(ctx, m.logger, m, m.PRPTable)
 	return nil
 }
 
@@ -192,8 +323,40 @@ func (m *StreamBroadcaster) PRPRequest(ctx context.Context, unknownDstIP string)
 	return m.SendPacket(ctx, metapacket.NewFromPayload(prp.NewPRPRequestPacket(unknownDstIP)))
 }
 
+// SyncTable asks a random neighbour for its PRP table
+func (m *StreamBroadcaster) SyncTable(ctx context.Context) error {
+	if !m.Ready() {
+		return fmt.Errorf("Broadcaster still not ready")
+	}
+
+	peersIDs := fanoutPeers(m.discoveredPeers())
+	if len(peersIDs) == 0 {
+		return fmt.Errorf("no peers to sync the table from")
+	}
+	return m.SyncTableFrom(ctx, peersIDs[0])
+}
+
+// SyncTableFrom asks peerID for its PRP table, even if it is not a known
+// member yet, the request and the reply are sealed with the network key
+func (m *StreamBroadcaster) SyncTableFrom(ctx context.Context, peerID peer.ID) error {
+	if !m.Ready() {
+		return fmt.Errorf("Broadcaster still not ready")
+	}
+	return m.sendDirect(ctx, peerID, metapacket.NewFromPayload(prp.NewPRPTableSyncRequestPacket(peerID.String())))
+}
+
+// AnnounceMyself announces our IP, and the MACs behind our TAP interface if any
 func (m *StreamBroadcaster) AnnounceMyself(ctx context.Context) error {
-	return m.SendPacket(ctx, metapacket.NewFromPayload(m.PRPTable.PRPReplyMyself(true)))
+	payload := m.PRPTable.PRPReplyMyself(true)
+	if payload == nil {
+		return fmt.Errorf("broadcaster still not started")
+	}
+	if macs := m.PRPTable.PRPMACAnnounce(); macs != nil {
+		if err := m.SendPacket(ctx, metapacket.NewFromPayload(macs)); err != nil {
+			return err
+		}
+	}
+	return m.SendPacket(ctx, metapacket.NewFromPayload(payload))
 }
 
 func IsPeerFoundByDiscovery(host host.Host, peerID peer.ID) bool {
@@ -2,6 +2,7 @@ package broadcast_test
 
 import (
 	"context"
+	"fmt"
 	"testing"
 	"time"
 
@@ -15,29 +16,54 @@ import (
 	"github.com/gfleury/solo/client/vpn"
 	"github.com/gfleury/solo/common/models"
 	"github.com/ipfs/go-log"
+	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/stretchr/testify/suite"
 )
 
+func signedReply(h host.Host, ip string, machine models.NetworkNode) *prp.PRPacket {
+	p := &prp.PRPacket{PRPType: prp.PRPReply, IP: ip, Machine: machine}
+	p.Sign(h.Peerstore().PrivKey(h.ID()))
+	return p
+}
+
+// BroadcastTestSuite runs against every Broadcaster implementation
 type BroadcastTestSuite struct {
 	suite.Suite
+	kind        string
 	otpInterval int
 }
 
 func TestBroadcastTestSuite(t *testing.T) {
-	suite.Run(t, new(BroadcastTestSuite))
+	for _, kind := range []string{broadcast.BROADCASTER_STREAM, broadcast.BROADCASTER_GOSSIPSUB} {
+		t.Run(kind, func(t *testing.T) {
+			suite.Run(t, &BroadcastTestSuite{kind: kind})
+		})
+	}
 }
 
 func (s *BroadcastTestSuite) SetupTest() {
 	s.otpInterval = 120
 }
 
-func (s *BroadcastTestSuite) TestBroadcastPubSubShortIntervalOTP() {
+func (s *BroadcastTestSuite) newBroadcaster(publishLocalRoutes bool) broadcast.Broadcaster {
+	otpKey := crypto.OTPKey{
+		Key:       "supersecret",
+		KeyLength: 32,
+		Interval:  s.otpInterval,
+	}
+
+	b, err := broadcast.New(s.kind, logger.New(log.LevelDebug), discovery.AddrList{}, otpKey, publishLocalRoutes)
+	s.Require().NoError(err)
+	return b
+}
+
+func (s *BroadcastTestSuite) TestBroadcastShortIntervalOTP() {
 	s.otpInterval = 10
-	s.TestBroadcastPubSubOTP()
+	s.TestBroadcastOTP()
 }
 
-func (s *BroadcastTestSuite) TestBroadcastPubSubOTP() {
-	ctx, cancelFunc := context.WithCancel(context.Background())
+func (s *BroadcastTestSuite) TestBroadcastOTP() {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancelFunc()
 
 	h1, _ := vpn.NewTestHost("0")
@@ -46,60 +72,30 @@ func (s *BroadcastTestSuite) TestBroadcastPubSubOTP() {
 	err := vpn.TestConnectHosts(ctx, h1, h2)
 	s.NoError(err)
 
-	logger := logger.New(log.LevelDebug)
-	otpKey := crypto.OTPKey{
-		Key:       "otpKey",
-		KeyLength: 16,
-		Interval:  s.otpInterval,
-	}
+	b1 := s.newBroadcaster(false)
+	b2 := s.newBroadcaster(false)
 
-	b1 := broadcast.NewBroadcaster(logger, &otpKey, 1024)
-	b2 := broadcast.NewBroadcaster(logger, &otpKey, 1024)
-
-	go func() {
-		b1.Start(ctx, h1, "10.2.3.1")
-	}()
-
-	go func() {
-		b2.Start(ctx, h2, "10.2.3.2")
-	}()
-
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				b1.SendPacket(ctx, metapacket.NewMetaPacket(protocol.Type_PRP, &prp.PRPacket{PRPType: prp.PRPReply, IP: "10.2.3.4", Machine: models.NetworkNode{PeerID: h1.ID().String()}}))
-				b2.SendPacket(ctx, metapacket.NewFromPayload(prp.NewPRPRequestPacket("10.2.3.1")))
-				b1.SendPacket(ctx, metapacket.NewFromPayload(prp.NewPRPRequestPacket("10.2.3.2")))
-				time.Sleep(2 * time.Second)
-			}
-		}
-	}()
+	go b1.Start(ctx, h1, "10.2.3.1")
+	go b2.Start(ctx, h2, "10.2.3.2")
+
+	for ctx.Err() == nil {
+		b1.SendPacket(ctx, metapacket.NewMetaPacket(protocol.Type_PRP, signedReply(h1, "10.2.3.4", models.NetworkNode{PeerID: h1.ID().String(), IP: "10.2.3.4"})))
+		b2.SendPacket(ctx, metapacket.NewFromPayload(prp.NewPRPRequestPacket("10.2.3.1")))
+		b1.SendPacket(ctx, metapacket.NewFromPayload(prp.NewPRPRequestPacket("10.2.3.2")))
 
-	for {
-		select {
-		case <-ctx.Done():
+		m4, _, _ := b2.Lookup("10.2.3.4")
+		m1, _, _ := b2.Lookup("10.2.3.1")
+		m2, _, _ := b1.Lookup("10.2.3.2")
+		if m4 != nil && m1 != nil && m2 != nil {
 			return
-		default:
-			m, _, _ := b2.Lookup("10.2.3.4")
-			if m != nil {
-				m, _, _ = b2.Lookup("10.2.3.1")
-				if m != nil {
-					m, _, _ = b1.Lookup("10.2.3.2")
-					if m != nil {
-						return
-					}
-				}
-			}
-			time.Sleep(2 * time.Second)
 		}
+		time.Sleep(2 * time.Second)
 	}
+	s.Fail("the PRP replies were not received")
 }
 
-func (s *BroadcastTestSuite) TestBroadcastStreamSeal() {
-	ctx, cancelFunc := context.WithCancel(context.Background())
+func (s *BroadcastTestSuite) TestBroadcastLargePacket() {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancelFunc()
 
 	h1, _ := vpn.NewTestHost("0")
@@ -108,55 +104,72 @@ func (s *BroadcastTestSuite) TestBroadcastStreamSeal() {
 	err := vpn.TestConnectHosts(ctx, h1, h2)
 	s.NoError(err)
 
-	logger := logger.New(log.LevelDebug)
+	b1 := s.newBroadcaster(false)
+	b2 := s.newBroadcaster(false)
 
-	otpKey := crypto.OTPKey{
-		Key:       "supersecret",
-		KeyLength: 32,
-		Interval:  120,
+	go b1.Start(ctx, h1, "10.2.3.1")
+	go b2.Start(ctx, h2, "10.2.3.2")
+
+	// Many local routes make the packet much bigger than a MTU
+	machine := models.NetworkNode{PeerID: h1.ID().String(), IP: "10.2.3.5"}
+	for i := 0; i < 500; i++ {
+		machine.LocalRoutes = append(machine.LocalRoutes, fmt.Sprintf("10.%d.%d.0/24", i/256, i%256))
 	}
 
-	b1 := broadcast.NewStreamBroadcaster(logger, discovery.AddrList{}, otpKey, false)
-	b2 := broadcast.NewStreamBroadcaster(logger, discovery.AddrList{}, otpKey, false)
-
-	go func() {
-		b1.Start(ctx, h1, "10.2.3.1")
-	}()
-
-	go func() {
-		b2.Start(ctx, h2, "10.2.3.2")
-	}()
-
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				b1.SendPacket(ctx, metapacket.NewMetaPacket(protocol.Type_PRP, &prp.PRPacket{PRPType: prp.PRPReply, IP: "10.2.3.4", Machine: models.NetworkNode{PeerID: h1.ID().String()}}))
-				b2.SendPacket(ctx, metapacket.NewFromPayload(prp.NewPRPRequestPacket("10.2.3.1")))
-				b1.SendPacket(ctx, metapacket.NewFromPayload(prp.NewPRPRequestPacket("10.2.3.2")))
-				time.Sleep(2 * time.Second)
-			}
+	for ctx.Err() == nil {
+		b1.SendPacket(ctx, metapacket.NewMetaPacket(protocol.Type_PRP, signedReply(h1, "10.2.3.5", machine)))
+		// Several packets on the same stream, the second for a local route
+		b1.SendPacket(ctx, metapacket.NewMetaPacket(protocol.Type_PRP, signedReply(h1, "10.0.1.7", machine)))
+
+		m5, _, _ := b2.Lookup("10.2.3.5")
+		m6, _, _ := b2.Lookup("10.0.1.7")
+		if m5 != nil && m6 != nil {
+			s.Len(m5.LocalRoutes, 500)
+			return
+		}
+		time.Sleep(1 * time.Second)
+	}
+	s.Fail("large packets were not received")
+}
+
+func (s *BroadcastTestSuite) TestBroadcastTableSync() {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelFunc()
+
+	h1, _ := vpn.NewTestHost("0")
+	h2, _ := vpn.NewTestHost("0")
+	h3, _ := vpn.NewTestHost("0")
+
+	s.NoError(vpn.TestConnectHosts(ctx, h2, h3))
+
+	b1 := s.newBroadcaster(false)
+	b2 := s.newBroadcaster(false)
+	b3 := s.newBroadcaster(false)
+
+	go b2.Start(ctx, h2, "10.2.3.2")
+	go b3.Start(ctx, h3, "10.2.3.3")
+
+	for ctx.Err() == nil {
+		b3.AnnounceMyself(ctx)
+		if m, _, _ := b2.Lookup("10.2.3.3"); m != nil {
+			break
 		}
-	}()
+		time.Sleep(1 * time.Second)
+	}
+
+	// A late joiner learns the whole table from its neighbour
+	s.NoError(vpn.TestConnectHosts(ctx, h1, h2))
+	go b1.Start(ctx, h1, "10.2.3.1")
 
-	for {
-		select {
-		case <-ctx.Done():
+	for ctx.Err() == nil {
+		b1.SyncTable(ctx)
+		m2, _, _ := b1.Lookup("10.2.3.2")
+		m3, _, _ := b1.Lookup("10.2.3.3")
+		if m2 != nil && m3 != nil {
+			s.Equal(h3.ID().String(), m3.PeerID)
 			return
-		default:
-			m, _, _ := b2.Lookup("10.2.3.4")
-			if m != nil {
-				m, _, _ = b2.Lookup("10.2.3.1")
-				if m != nil {
-					m, _, _ = b1.Lookup("10.2.3.2")
-					if m != nil {
-						return
-					}
-				}
-			}
-			time.Sleep(2 * time.Second)
 		}
+		time.Sleep(1 * time.Second)
 	}
+	s.Fail("the table was not synced")
 }
@@ -0,0 +1,34 @@
+package broadcast
+
+import (
+	"fmt"
+
+	"github.com/gfleury/solo/client/crypto"
+	"github.com/gfleury/solo/client/discovery"
+	"github.com/ipfs/go-log"
+)
+
+// Broadcaster implementations, selected with --broadcaster
+const (
+	// BROADCASTER_STREAM gossips the packets over our own broadcast streams
+	BROADCASTER_STREAM = "stream"
+	// BROADCASTER_GOSSIPSUB publishes the packets on GossipSub topics rotated with the OTP
+	BROADCASTER_GOSSIPSUB = "gossipsub"
+)
+
+// New returns the Broadcaster implementation named kind
+func New(
+	kind string,
+	logger log.StandardLogger,
+	discoveryPeers discovery.AddrList,
+	otpKey crypto.OTPKey,
+	publishLocalRoutes bool,
+) (Broadcaster, error) {
+	switch kind {
+	case BROADCASTER_STREAM, "":
+		return NewStreamBroadcaster(logger, discoveryPeers, otpKey, publishLocalRoutes), nil
+	case BROADCASTER_GOSSIPSUB:
+		return NewBroadcaster(logger, &otpKey, BROADCAST_MAX_MESSAGE_SIZE, publishLocalRoutes), nil
+	}
+	return nil, fmt.Errorf("unknown broadcaster %q, use %s or %s", kind, BROADCASTER_STREAM, BROADCASTER_GOSSIPSUB)
+}
@@ -0,0 +1,34 @@
+package broadcast
+
+import (
+	"crypto/sha256"
+	"time"
+
+	"github.com/gfleury/solo/client/broadcast/metapacket"
+	"github.com/gfleury/solo/client/crypto"
+	"github.com/ipfs/go-log"
+)
+
+// CLOCK_SKEW_WARNING is the clock difference to a peer from which we warn about it
+const CLOCK_SKEW_WARNING = 10 * time.Second
+
+// unsealWindows unseals a message sealed with the key of the current or the
+// neighbour OTP windows, returning the window index used (0 is the current)
+func unsealWindows(sealer crypto.Sealer, message []byte, otpKey *crypto.OTPKey) ([]byte, int, error) {
+	return crypto.UnsealAny(sealer, message, otpKey.TOTPSHA256Windows(sha256.New))
+}
+
+// checkClockSkew warns when the sender clock diverges from ours, before it gets
+// far enough for the OTP windows to stop overlapping
+func checkClockSkew(logger log.StandardLogger, packet *metapacket.MetaPacket, window int) {
+	skew := packet.ClockSkew()
+	if skew < 0 {
+		skew = -skew
+	}
+
+	if skew > CLOCK_SKEW_WARNING {
+		logger.Warnf("Clock of peer %s is %s apart from ours, check the time synchronization of both", packet.SenderID, skew.Round(time.Second))
+	} else if window != 0 {
+		logger.Debugf("Message from peer %s sealed with a neighbour OTP window", packet.SenderID)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/gfleury/solo/client/broadcast/metapacket"
+	"github.com/gfleury/solo/client/broadcast/prp"
 	"github.com/gfleury/solo/common/models"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -45,3 +46,15 @@ func (b DummyBroadcast) AddFakePeer(ip string, peer peer.ID) {
 func (b DummyBroadcast) AnnounceMyself(ctx context.Context) error {
 	return nil
 }
+
+func (b DummyBroadcast) SyncTable(ctx context.Context) error {
+	return nil
+}
+
+func (b DummyBroadcast) SyncTableFrom(ctx context.Context, peerID peer.ID) error {
+	return nil
+}
+
+func (b DummyBroadcast) Table() *prp.PRPTableType {
+	return nil
+}
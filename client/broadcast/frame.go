@@ -0,0 +1,110 @@
+package broadcast
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// BROADCAST_MAX_MESSAGE_SIZE is the maximum size of a sealed broadcast message
+const BROADCAST_MAX_MESSAGE_SIZE = 256 * 1024
+
+// writeFrame writes message prefixed by its big endian uint32 length
+func writeFrame(w io.Writer, message []byte) error {
+	if len(message) > BROADCAST_MAX_MESSAGE_SIZE {
+		return fmt.Errorf("message of %d bytes is bigger than the maximum %d", len(message), BROADCAST_MAX_MESSAGE_SIZE)
+	}
+
+	frame := make([]byte, 4+len(message))
+	binary.BigEndian.PutUint32(frame, uint32(len(message)))
+	copy(frame[4:], message)
+
+	n, err := w.Write(frame)
+	if err != nil {
+		return err
+	} else if n != len(frame) {
+		return fmt.Errorf("wrote wrong amount of bytes, expected %d wrote %d", len(frame), n)
+	}
+	return nil
+}
+
+// readFrame reads a message written by writeFrame, io.EOF means no more messages
+func readFrame(r io.Reader) ([]byte, error) {
+	var size uint32
+	err := binary.Read(r, binary.BigEndian, &size)
+	if err != nil {
+		return nil, err
+	}
+
+	if size > BROADCAST_MAX_MESSAGE_SIZE {
+		return nil, fmt.Errorf("message of %d bytes is bigger than the maximum %d", size, BROADCAST_MAX_MESSAGE_SIZE)
+	}
+
+	message := make([]byte, size)
+	_, err = io.ReadFull(r, message)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return message, err
+}
+
+// peerStream is an outbound broadcast stream, writes are serialized by its lock
+type peerStream struct {
+	sync.Mutex
+
+	stream network.Stream
+}
+
+// peerStreams keeps an outbound broadcast stream per peer
+type peerStreams struct {
+	sync.Mutex
+
+	streams map[peer.ID]*peerStream
+}
+
+func newPeerStreams() *peerStreams {
+	return &peerStreams{streams: map[peer.ID]*peerStream{}}
+}
+
+func (p *peerStreams) Get(peerID peer.ID) (*peerStream, bool) {
+	p.Lock()
+	defer p.Unlock()
+	ps, found := p.streams[peerID]
+	return ps, found
+}
+
+// Add stores the stream, if another one was opened meanwhile that one is kept
+func (p *peerStreams) Add(peerID peer.ID, stream network.Stream) *peerStream {
+	p.Lock()
+	defer p.Unlock()
+	if ps, found := p.streams[peerID]; found {
+		stream.Close()
+		return ps
+	}
+	ps := &peerStream{stream: stream}
+	p.streams[peerID] = ps
+	return ps
+}
+
+// Delete removes the stream of the peer if it is still ps
+func (p *peerStreams) Delete(peerID peer.ID, ps *peerStream) {
+	p.Lock()
+	defer p.Unlock()
+	if p.streams[peerID] == ps {
+		delete(p.streams, peerID)
+	}
+}
+
+// Close resets and removes the stream of the peer
+func (p *peerStreams) Close(peerID peer.ID) {
+	p.Lock()
+	defer p.Unlock()
+	if ps, found := p.streams[peerID]; found {
+		ps.stream.Reset()
+		delete(p.streams, peerID)
+	}
+}
@@ -0,0 +1,41 @@
+package broadcast
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrames(t *testing.T) {
+	buffer := &bytes.Buffer{}
+
+	big := bytes.Repeat([]byte("a"), 10000)
+	require.NoError(t, writeFrame(buffer, []byte("first")))
+	require.NoError(t, writeFrame(buffer, big))
+	require.Error(t, writeFrame(buffer, make([]byte, BROADCAST_MAX_MESSAGE_SIZE+1)))
+
+	msg, err := readFrame(buffer)
+	require.NoError(t, err)
+	require.Equal(t, []byte("first"), msg)
+
+	msg, err = readFrame(buffer)
+	require.NoError(t, err)
+	require.Equal(t, big, msg)
+
+	_, err = readFrame(buffer)
+	require.Equal(t, io.EOF, err)
+
+	// Truncated message
+	require.NoError(t, writeFrame(buffer, []byte("truncated")))
+	buffer.Truncate(buffer.Len() - 1)
+	_, err = readFrame(buffer)
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// Oversized length prefix
+	buffer.Reset()
+	buffer.Write([]byte{0xff, 0xff, 0xff, 0xff})
+	_, err = readFrame(buffer)
+	require.Error(t, err)
+}
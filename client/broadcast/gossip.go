@@ -0,0 +1,102 @@
+package broadcast
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gfleury/solo/client/broadcast/protocol"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// Amount of peers each packet is sent or forwarded to
+	GOSSIP_FANOUT = 6
+	// Maximum hops a packet is forwarded through
+	GOSSIP_MAX_HOPS = 6
+	// Attempts to deliver a packet to a peer
+	GOSSIP_RETRIES = 2
+	// How long packet IDs are remembered to drop duplicates
+	GOSSIP_SEEN_TTL = 2 * time.Minute
+	// Maximum amount of packet IDs remembered
+	GOSSIP_SEEN_MAX = 8192
+)
+
+// hopsLeft returns the TTL a received packet is forwarded with, zero if it
+// isn't, a sender can't make it travel further than GOSSIP_MAX_HOPS
+func hopsLeft(ttl int) int {
+	if ttl > GOSSIP_MAX_HOPS {
+		ttl = GOSSIP_MAX_HOPS
+	}
+	if ttl < 1 {
+		return 0
+	}
+	return ttl - 1
+}
+
+// seenCache remembers the recently received packet IDs
+type seenCache struct {
+	sync.Mutex
+
+	seen map[string]time.Time
+}
+
+func newSeenCache() *seenCache {
+	return &seenCache{seen: map[string]time.Time{}}
+}
+
+// Add records the ID, returning false if it was already seen
+func (c *seenCache) Add(id string) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	if seenAt, found := c.seen[id]; found && time.Since(seenAt) < GOSSIP_SEEN_TTL {
+		return false
+	}
+
+	if len(c.seen) >= GOSSIP_SEEN_MAX {
+		c.prune()
+	}
+	c.seen[id] = time.Now()
+	return true
+}
+
+// prune drops the expired IDs, or all of them if none expired yet
+func (c *seenCache) prune() {
+	for id, seenAt := range c.seen {
+		if time.Since(seenAt) >= GOSSIP_SEEN_TTL {
+			delete(c.seen, id)
+		}
+	}
+	if len(c.seen) >= GOSSIP_SEEN_MAX {
+		c.seen = map[string]time.Time{}
+	}
+}
+
+// fanoutPeers picks at most GOSSIP_FANOUT random peers, skipping the excluded ones
+func fanoutPeers(peersIDs []peer.ID, exclude ...string) []peer.ID {
+	candidates := []peer.ID{}
+OUT:
+	for _, peerID := range peersIDs {
+		for _, excluded := range exclude {
+			if peerID.String() == excluded {
+				continue OUT
+			}
+		}
+		candidates = append(candidates, peerID)
+	}
+
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	if len(candidates) > GOSSIP_FANOUT {
+		candidates = candidates[:GOSSIP_FANOUT]
+	}
+	return candidates
+}
+
+// isUnicast tells if the reply payload goes back only to the peer that sent the request
+func isUnicast(payload protocol.Payload) bool {
+	unicast, ok := payload.(protocol.Unicast)
+	return ok && unicast.Unicast()
+}
@@ -0,0 +1,44 @@
+package broadcast
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeenCache(t *testing.T) {
+	c := newSeenCache()
+
+	require.True(t, c.Add("id1"))
+	require.False(t, c.Add("id1"))
+	require.True(t, c.Add("id2"))
+
+	for i := 0; i < GOSSIP_SEEN_MAX; i++ {
+		c.Add(string(rune(i)) + "filler")
+	}
+	require.LessOrEqual(t, len(c.seen), GOSSIP_SEEN_MAX)
+}
+
+func TestHopsLeft(t *testing.T) {
+	require.Equal(t, GOSSIP_MAX_HOPS-1, hopsLeft(GOSSIP_MAX_HOPS))
+	require.Equal(t, 1, hopsLeft(2))
+	require.Equal(t, 0, hopsLeft(1))
+	require.Equal(t, 0, hopsLeft(0))
+	require.Equal(t, 0, hopsLeft(-3))
+	// Received TTLs are clamped
+	require.Equal(t, GOSSIP_MAX_HOPS-1, hopsLeft(1<<30))
+}
+
+func TestFanoutPeers(t *testing.T) {
+	peersIDs := []peer.ID{}
+	for i := 0; i < 3*GOSSIP_FANOUT; i++ {
+		peersIDs = append(peersIDs, peer.ID(string(rune('a'+i))))
+	}
+
+	targets := fanoutPeers(peersIDs, peersIDs[0].String())
+	require.Len(t, targets, GOSSIP_FANOUT)
+	require.NotContains(t, targets, peersIDs[0])
+
+	require.Len(t, fanoutPeers(peersIDs[:2], peersIDs[0].String()), 1)
+}
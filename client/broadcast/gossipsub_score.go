@@ -0,0 +1,55 @@
+package broadcast
+
+import (
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// peerScoreParams are the GossipSub peer scoring parameters, peers are mostly
+// penalized for the messages we fail to unseal and for misbehaving on the
+// protocol, the rotating topics are scored with topicScoreParams
+func peerScoreParams() *pubsub.PeerScoreParams {
+	return &pubsub.PeerScoreParams{
+		Topics:        map[string]*pubsub.TopicScoreParams{},
+		TopicScoreCap: 100,
+		AppSpecificScore: func(peer.ID) float64 {
+			return 0
+		},
+		AppSpecificWeight: 1,
+		// Many members can share a NAT or a relay
+		IPColocationFactorWeight:    -10,
+		IPColocationFactorThreshold: 16,
+		BehaviourPenaltyWeight:      -10,
+		BehaviourPenaltyThreshold:   6,
+		BehaviourPenaltyDecay:       pubsub.ScoreParameterDecay(10 * time.Minute),
+		DecayInterval:               pubsub.DefaultDecayInterval,
+		DecayToZero:                 pubsub.DefaultDecayToZero,
+		RetainScore:                 10 * time.Minute,
+	}
+}
+
+func topicScoreParams() *pubsub.TopicScoreParams {
+	return &pubsub.TopicScoreParams{
+		TopicWeight:                    1,
+		TimeInMeshWeight:               0.01,
+		TimeInMeshQuantum:              time.Second,
+		TimeInMeshCap:                  3600,
+		FirstMessageDeliveriesWeight:   1,
+		FirstMessageDeliveriesDecay:    pubsub.ScoreParameterDecay(10 * time.Minute),
+		FirstMessageDeliveriesCap:      50,
+		InvalidMessageDeliveriesWeight: -100,
+		InvalidMessageDeliveriesDecay:  pubsub.ScoreParameterDecay(time.Hour),
+	}
+}
+
+// peerScoreThresholds stop gossiping with the peers that sent us an
+// unsealable message, and ignore them after a few more
+var peerScoreThresholds = &pubsub.PeerScoreThresholds{
+	GossipThreshold:             -50,
+	PublishThreshold:            -200,
+	GraylistThreshold:           -1000,
+	AcceptPXThreshold:           10,
+	OpportunisticGraftThreshold: 5,
+}
@@ -2,19 +2,40 @@ package metapacket
 
 import (
 	"encoding/json"
+	"fmt"
+	"time"
 
 	"github.com/gfleury/solo/client/broadcast/protocol"
-	"github.com/gfleury/solo/client/broadcast/prp"
+	_ "github.com/gfleury/solo/client/broadcast/prp"
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
+// METAPACKET_VERSION is the version of the protobuf encoding of Marshal, newer
+// versions are rejected
+const METAPACKET_VERSION = 1
+
 type MetaPacket struct {
 	Type     protocol.Type
 	SenderID string
 	Payload  string
+	// Timestamp is the sender unix time, used to detect clock skew
+	Timestamp int64
+
+	// ID identifies the packet while it is gossiped, TTL is the amount of
+	// hops it can still be forwarded
+	ID  string
+	TTL int
+	// OriginID is the peer that created the packet, SenderID the one it was received from
+	OriginID string
+	// origin is OriginID once authenticated by the transport, see SetOrigin
+	origin string
+
+	// payload is the decoded Payload
+	payload protocol.Payload
 }
 
 func NewMetaPacket(t protocol.Type, payload protocol.Payload) *MetaPacket {
-	return &MetaPacket{t, "", payload.Payload()}
+	return &MetaPacket{Type: t, Payload: payload.Payload(), Timestamp: time.Now().Unix(), payload: payload}
 }
 
 func NewFromPayload(payload protocol.Payload) *MetaPacket {
@@ -26,13 +47,128 @@ func (m *MetaPacket) Copy() *MetaPacket {
 	return &copy
 }
 
+// ClockSkew is how far the sender clock is from ours, zero if the sender didn't send its time
+func (m *MetaPacket) ClockSkew() time.Duration {
+	if m.Timestamp == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(m.Timestamp, 0))
+}
+
+// GetPayload decodes the payload with the type registered in protocol, nil if unknown
 func (m *MetaPacket) GetPayload() protocol.Payload {
-	switch m.Type {
-	case protocol.Type_PRP:
-		p := &prp.PRPacket{}
-		json.Unmarshal([]byte(m.Payload), p)
-		return p
+	if m.payload != nil {
+		return m.payload
+	}
+
+	p, found := protocol.New(m.Type)
+	if !found {
+		return nil
+	}
+	json.Unmarshal([]byte(m.Payload), p)
+	m.payload = p
+	m.setSender()
+	return p
+}
+
+// SetSenderID records the peer the packet was received from
+func (m *MetaPacket) SetSenderID(senderID string) {
+	m.SenderID = senderID
+}
+
+// SetOrigin records the peer that created the packet, it must be
+// authenticated by the transport as the payload trusts it
+func (m *MetaPacket) SetOrigin(originID string) {
+	m.OriginID = originID
+	m.origin = originID
+	m.setSender()
+}
+
+func (m *MetaPacket) setSender() {
+	if p, ok := m.payload.(protocol.SenderAware); ok && m.origin != "" {
+		p.SetSenderID(m.origin)
+	}
+}
+
+// Marshal encodes the MetaPacket with protobuf
+func (m *MetaPacket) Marshal() ([]byte, error) {
+	payload := m.GetPayload()
+	if payload == nil {
+		return nil, fmt.Errorf("unknown MetaPacket payload type %d", m.Type)
+	}
+	bytesPayload, err := payload.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	b := protowire.AppendTag(nil, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, METAPACKET_VERSION)
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(m.Type))
+	b = protowire.AppendTag(b, 3, protowire.BytesType)
+	b = protowire.AppendBytes(b, bytesPayload)
+	b = protowire.AppendTag(b, 4, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(m.Timestamp))
+	if m.ID != "" {
+		b = protowire.AppendTag(b, 5, protowire.BytesType)
+		b = protowire.AppendString(b, m.ID)
+	}
+	if m.TTL != 0 {
+		b = protowire.AppendTag(b, 6, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(m.TTL))
+	}
+	if m.OriginID != "" {
+		b = protowire.AppendTag(b, 7, protowire.BytesType)
+		b = protowire.AppendString(b, m.OriginID)
+	}
+	return b, nil
+}
+
+// Unmarshal decodes a MetaPacket encoded with protobuf, or with JSON by older nodes
+func Unmarshal(b []byte) (*MetaPacket, error) {
+	m := &MetaPacket{}
+	if len(b) > 0 && b[0] == '{' {
+		err := json.Unmarshal(b, m)
+		return m, err
+	}
+
+	var version uint64
+	var bytesPayload []byte
+	err := protocol.ConsumeFields(b, func(f protocol.WireField) error {
+		switch f.Number {
+		case 1:
+			version = f.Varint
+		case 2:
+			m.Type = protocol.Type(f.Varint)
+		case 3:
+			bytesPayload = f.Bytes
+		case 4:
+			m.Timestamp = int64(f.Varint)
+		case 5:
+			m.ID = string(f.Bytes)
+		case 6:
+			m.TTL = int(int32(f.Varint))
+		case 7:
+			m.OriginID = string(f.Bytes)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if version > METAPACKET_VERSION {
+		return nil, fmt.Errorf("unsupported MetaPacket version %d", version)
+	}
+
+	payload, found := protocol.New(m.Type)
+	if !found {
+		return nil, fmt.Errorf("unknown MetaPacket payload type %d", m.Type)
+	}
+	if err := payload.UnmarshalBinary(bytesPayload); err != nil {
+		return nil, err
 	}
+	m.payload = payload
 
-	return nil
+	return m, nil
 }
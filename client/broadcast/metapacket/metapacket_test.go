@@ -2,9 +2,13 @@ package metapacket
 
 import (
 	"encoding/json"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/gfleury/solo/client/broadcast/prp"
+	"github.com/gfleury/solo/common/models"
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
 func TestMetapacket(t *testing.T) {
@@ -30,3 +34,63 @@ func TestMetapacket(t *testing.T) {
 		t.Errorf("ap != ep ( %v != %v )", ap, ep)
 	}
 }
+
+func TestMetapacketClockSkew(t *testing.T) {
+	m := NewFromPayload(prp.NewPRPRequestPacket("10.2.3.1"))
+	if skew := m.ClockSkew(); skew < 0 || skew > 2*time.Second {
+		t.Errorf("unexpected clock skew %s", skew)
+	}
+
+	m.Timestamp = time.Now().Add(-time.Minute).Unix()
+	if skew := m.ClockSkew(); skew < 59*time.Second {
+		t.Errorf("unexpected clock skew %s", skew)
+	}
+
+	// Peers not sending their time are not skewed
+	m.Timestamp = 0
+	if skew := m.ClockSkew(); skew != 0 {
+		t.Errorf("unexpected clock skew %s", skew)
+	}
+}
+
+func TestMetapacketProtobuf(t *testing.T) {
+	machine := models.NetworkNode{PeerID: "peer1", IP: "10.2.3.1", Hostname: "host1", LocalRoutes: []string{"192.168.0.0/24", "192.168.1.0/24"}}
+	actual := NewFromPayload(&prp.PRPacket{PRPType: prp.PRPReply, IP: "10.2.3.1", Machine: machine})
+	actual.ID = "packetID"
+	actual.TTL = 3
+
+	b, err := actual.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := Unmarshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ap := actual.GetPayload().(*prp.PRPacket)
+	ep := expected.GetPayload().(*prp.PRPacket)
+	if !reflect.DeepEqual(ap, ep) || expected.ID != actual.ID || expected.TTL != actual.TTL || expected.Timestamp != actual.Timestamp {
+		t.Errorf("ap != ep ( %v != %v )", ap, ep)
+	}
+
+	j, _ := json.Marshal(actual)
+	if len(b)*4 > len(j) {
+		t.Errorf("protobuf encoding (%d bytes) is not much smaller than JSON (%d bytes)", len(b), len(j))
+	}
+
+	// Older nodes send JSON
+	fromJSON, err := Unmarshal(j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jp := fromJSON.GetPayload().(*prp.PRPacket); jp.IP != ap.IP || jp.Machine.PeerID != ap.Machine.PeerID {
+		t.Errorf("ap != jp ( %v != %v )", ap, jp)
+	}
+
+	// Newer versions are rejected
+	if _, err := Unmarshal(protowire.AppendVarint([]byte{0x08}, METAPACKET_VERSION+1)); err == nil {
+		t.Errorf("newer MetaPacket version was accepted")
+	}
+}
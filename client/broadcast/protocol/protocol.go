@@ -1,6 +1,11 @@
 package protocol
 
-import "github.com/ipfs/go-log"
+import (
+	"fmt"
+
+	"github.com/ipfs/go-log"
+	"google.golang.org/protobuf/encoding/protowire"
+)
 
 type Type int
 
@@ -12,4 +17,83 @@ type Payload interface {
 	Type() Type
 	Payload() string
 	Process(logger log.StandardLogger, table interface{}) (Payload, error)
+
+	// Protobuf encoding of the payload, written with protowire, the field
+	// numbers are the wire format and must never be reused
+	MarshalBinary() ([]byte, error)
+	UnmarshalBinary([]byte) error
+}
+
+// SenderAware payloads are told the peer that originated them, when the
+// transport authenticates it
+type SenderAware interface {
+	SetSenderID(string)
+}
+
+// Unicast payloads are replies sent back only to the peer the request came
+// from, instead of broadcast to the network, when Unicast returns true
+type Unicast interface {
+	Unicast() bool
+}
+
+// PayloadFactory returns an empty payload to be decoded into
+type PayloadFactory func() Payload
+
+var registry = map[Type]PayloadFactory{}
+
+// Register adds a new payload type, usually from the payload package init
+func Register(t Type, factory PayloadFactory) {
+	registry[t] = factory
+}
+
+// New returns an empty payload of type t, false if the type is unknown
+func New(t Type) (Payload, bool) {
+	factory, found := registry[t]
+	if !found {
+		return nil, false
+	}
+	return factory(), true
+}
+
+// WireField is a decoded protobuf field, Bytes is only set for length delimited fields
+type WireField struct {
+	Number protowire.Number
+	Varint uint64
+	Bytes  []byte
+}
+
+// ConsumeFields decodes the protobuf message b calling f for each varint and
+// length delimited field, the fields of other wire types are skipped
+func ConsumeFields(b []byte, f func(WireField) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		field := WireField{Number: num}
+		switch typ {
+		case protowire.VarintType:
+			field.Varint, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			field.Bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if err := f(field); err != nil {
+			return fmt.Errorf("field %d: %w", num, err)
+		}
+	}
+	return nil
 }
@@ -14,12 +14,41 @@ type PRPPacketType int
 const (
 	PRPRequest PRPPacketType = iota
 	PRPReply
+	// PRPTableSyncRequest asks PeerID, or any peer if empty, for its table,
+	// answered with a PRPTableSync carrying the signed replies it knows
+	PRPTableSyncRequest
+	PRPTableSync
+	// PRPMACAnnounce carries the MAC addresses behind the Machine TAP interface
+	PRPMACAnnounce
 )
 
 type PRPacket struct {
 	PRPType PRPPacketType
 	Machine models.NetworkNode
 	IP      string
+
+	// Replies are signed by the Machine key, see Sign
+	SignedAt    int64
+	Signature   []byte
+	Certificate []byte
+
+	// Entries are the signed replies of a PRPTableSync
+	Entries []PRPacket
+	// PeerID is the peer a PRPTableSyncRequest is sent to
+	PeerID string
+	// MACs are the MAC addresses of a PRPMACAnnounce
+	MACs []string
+
+	senderID string
+}
+
+func (p *PRPacket) SetSenderID(senderID string) {
+	p.senderID = senderID
+}
+
+// Unicast tells table syncs are only sent back to the peer that requested them
+func (p *PRPacket) Unicast() bool {
+	return p.PRPType == PRPTableSync
 }
 
 func (p *PRPacket) Type() protocol.Type {
@@ -37,8 +66,18 @@ func (p *PRPacket) Process(logger log.StandardLogger, table interface{}) (protoc
 
 	switch p.PRPType {
 	case PRPReply:
+		certified, err := p.Verify(PRPTable.addressAuthority())
+		if err != nil {
+			logger.Warnf("Dropping PRPReply IP: %s from %s: %s", p.IP, p.senderID, err)
+			return nil, nil
+		}
 		logger.Infof("PRPReply IP: %s Machine: %v", p.IP, p.Machine)
-		PRPTable.insertEntry(p.IP, &p.Machine)
+		switch PRPTable.insertReply(p, certified) {
+		case rejected:
+			logger.Warnf("Dropping PRPReply IP: %s from %s, the IP belongs to another peer", p.IP, p.Machine.PeerID)
+		case conflict:
+			logger.Warnf("IP conflict: %s is now claimed by %s, replacing the previous peer", p.IP, p.Machine.PeerID)
+		}
 	case PRPRequest:
 		logger.Infof("PRPRequest IP: who's %s?  I'm %s", p.IP, PRPTable.localIP)
 		if p.IP == PRPTable.localIP {
@@ -58,6 +97,41 @@ func (p *PRPacket) Process(logger log.StandardLogger, table interface{}) (protoc
 				}
 			}
 		}
+	case PRPTableSyncRequest:
+		_, mySelf := PRPTable.Myself()
+		if p.PeerID != "" && (mySelf == nil || p.PeerID != mySelf.PeerID) {
+			return nil, nil
+		}
+		logger.Infof("PRPTableSyncRequest from %s", p.senderID)
+		return PRPTable.PRPTableSync(), nil
+	case PRPTableSync:
+		logger.Infof("PRPTableSync from %s with %d entries", p.senderID, len(p.Entries))
+		authority := PRPTable.addressAuthority()
+		for i := range p.Entries {
+			entry := &p.Entries[i]
+			// Entries are relayed, they are only trusted for their own signature
+			entry.senderID = ""
+			if entry.PRPType != PRPReply {
+				continue
+			}
+			certified, err := entry.Verify(authority)
+			if err != nil {
+				logger.Debugf("Dropping synced entry IP: %s: %s", entry.IP, err)
+				continue
+			}
+			if PRPTable.insertSynced(entry, certified) == rejected {
+				logger.Debugf("Dropping synced entry IP: %s from %s, the IP belongs to another peer", entry.IP, entry.Machine.PeerID)
+			}
+		}
+	case PRPMACAnnounce:
+		if _, err := p.Verify(PRPTable.addressAuthority()); err != nil {
+			logger.Warnf("Dropping PRPMACAnnounce from %s: %s", p.senderID, err)
+			return nil, nil
+		}
+		logger.Debugf("PRPMACAnnounce from %s with %d MACs", p.Machine.PeerID, len(p.MACs))
+		for _, mac := range p.MACs {
+			PRPTable.LearnMAC(mac, p.Machine.PeerID)
+		}
 	}
 	return nil, nil
 }
@@ -68,3 +142,11 @@ func NewPRPRequestPacket(ip string) *PRPacket {
 		IP:      ip,
 	}
 }
+
+// NewPRPTableSyncRequestPacket asks peerID, or any peer if empty, for its table
+func NewPRPTableSyncRequestPacket(peerID string) *PRPacket {
+	return &PRPacket{
+		PRPType: PRPTableSyncRequest,
+		PeerID:  peerID,
+	}
+}
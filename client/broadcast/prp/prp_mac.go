@@ -0,0 +1,132 @@
+package prp
+
+import (
+	"net"
+	"time"
+
+	"github.com/gfleury/solo/client/broadcast/protocol"
+)
+
+const (
+	// MACs not seen nor announced for longer than this are dropped, like the
+	// ageing time of a bridge
+	PRP_MAC_TTL = 5 * time.Minute
+	// Maximum MACs learned, the least recently seen are evicted
+	PRP_MAC_MAX_ENTRIES = 4096
+)
+
+// macEntry is the peer a MAC address is behind, our own ones are local
+type macEntry struct {
+	peerID   string
+	local    bool
+	lastSeen time.Time
+}
+
+// NormalizeMAC returns the canonical form of mac, empty if invalid
+func NormalizeMAC(mac string) string {
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) != 6 {
+		return ""
+	}
+	return hw.String()
+}
+
+// LearnLocalMAC records a MAC seen behind our TAP interface, it returns true
+// if it is new, and should be announced
+func (t *PRPTableType) LearnLocalMAC(mac string) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	e, found := t.macs[mac]
+	isNew := !found || !e.local || time.Since(e.lastSeen) > PRP_MAC_TTL
+	t.learnMAC(mac, &macEntry{local: true, lastSeen: time.Now()})
+	return isNew
+}
+
+// LearnMAC records mac behind peerID, from its announcements or frames, a
+// MAC moves to the last peer it is seen behind
+func (t *PRPTableType) LearnMAC(mac, peerID string) {
+	mac = NormalizeMAC(mac)
+	if mac == "" {
+		return
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	t.learnMAC(mac, &macEntry{peerID: peerID, lastSeen: time.Now()})
+}
+
+// learnMAC stores e, evicting the old MACs past PRP_MAC_MAX_ENTRIES, t must be locked
+func (t *PRPTableType) learnMAC(mac string, e *macEntry) {
+	t.macs[mac] = e
+	if len(t.macs) <= PRP_MAC_MAX_ENTRIES {
+		return
+	}
+
+	for mac, e := range t.macs {
+		if time.Since(e.lastSeen) > PRP_MAC_TTL {
+			delete(t.macs, mac)
+		}
+	}
+	for len(t.macs) > PRP_MAC_MAX_ENTRIES {
+		oldestMAC := ""
+		var oldest time.Time
+		for mac, e := range t.macs {
+			if oldestMAC == "" || e.lastSeen.Before(oldest) {
+				oldestMAC, oldest = mac, e.lastSeen
+			}
+		}
+		delete(t.macs, oldestMAC)
+	}
+}
+
+// LookupMAC returns the peer mac is behind, ourselves for the local ones
+func (t *PRPTableType) LookupMAC(mac string) (string, bool) {
+	_, myself := t.Myself()
+
+	t.Lock()
+	defer t.Unlock()
+
+	e, found := t.macs[mac]
+	if !found {
+		return "", false
+	}
+	if time.Since(e.lastSeen) > PRP_MAC_TTL {
+		delete(t.macs, mac)
+		return "", false
+	}
+	if e.local {
+		if myself == nil {
+			return "", false
+		}
+		return myself.PeerID, true
+	}
+	return e.peerID, true
+}
+
+// PRPMACAnnounce returns a signed announce of our local MACs, nil if we have none
+func (t *PRPTableType) PRPMACAnnounce() protocol.Payload {
+	ip, myself := t.Myself()
+	if myself == nil {
+		return nil
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	macs := []string{}
+	for mac, e := range t.macs {
+		if e.local && time.Since(e.lastSeen) <= PRP_MAC_TTL {
+			macs = append(macs, mac)
+		}
+	}
+	if len(macs) == 0 {
+		return nil
+	}
+
+	p := &PRPacket{PRPType: PRPMACAnnounce, Machine: *myself, IP: ip, MACs: macs, Certificate: t.certificate}
+	if t.signer != nil {
+		p.Sign(t.signer)
+	}
+	return p
+}
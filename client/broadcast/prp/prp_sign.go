@@ -0,0 +1,101 @@
+package prp
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/gfleury/solo/common"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// PRP_REPLY_MAX_AGE is how far from now a signed reply can be, older ones are replays
+const PRP_REPLY_MAX_AGE = 10 * time.Minute
+
+// signedBytes is the encoding of the fields covered by the signature
+func (p *PRPacket) signedBytes() []byte {
+	c := PRPacket{PRPType: p.PRPType, Machine: p.Machine, IP: p.IP, SignedAt: p.SignedAt, MACs: p.MACs}
+	b, _ := c.MarshalBinary()
+	return b
+}
+
+// Sign signs the packet with the Machine libp2p private key
+func (p *PRPacket) Sign(key crypto.PrivKey) error {
+	p.SignedAt = time.Now().Unix()
+	signature, err := key.Sign(p.signedBytes())
+	if err != nil {
+		return err
+	}
+	p.Signature = signature
+	return nil
+}
+
+// Verify checks the reply was signed by Machine.PeerID, sent by it if the
+// transport authenticated the sender, and that it only claims its own IP or
+// one of its local routes. It returns if the claimed IP is certified by
+// authority, the certificate only binds the Machine IP so claims of local
+// routes never are. Once there is an authority uncertified replies are an
+// error
+func (p *PRPacket) Verify(authority crypto.PubKey) (bool, error) {
+	if len(p.Signature) == 0 {
+		return false, fmt.Errorf("reply is not signed")
+	}
+
+	peerID, err := peer.Decode(p.Machine.PeerID)
+	if err != nil {
+		return false, err
+	}
+	if p.senderID != "" && p.senderID != p.Machine.PeerID {
+		return false, fmt.Errorf("reply for %s sent by %s", p.Machine.PeerID, p.senderID)
+	}
+
+	pubKey, err := peerID.ExtractPublicKey()
+	if err != nil {
+		return false, err
+	}
+	valid, err := pubKey.Verify(p.signedBytes(), p.Signature)
+	if err != nil {
+		return false, err
+	} else if !valid {
+		return false, fmt.Errorf("invalid signature")
+	}
+
+	age := time.Since(time.Unix(p.SignedAt, 0))
+	if age > PRP_REPLY_MAX_AGE || age < -PRP_REPLY_MAX_AGE {
+		return false, fmt.Errorf("reply signed %s ago", age.Round(time.Second))
+	}
+
+	if !p.ownsIP() {
+		return false, fmt.Errorf("%s is not an address of %s", p.IP, p.Machine.PeerID)
+	}
+
+	if authority == nil {
+		return false, nil
+	}
+	if len(p.Certificate) == 0 {
+		return false, fmt.Errorf("reply is not certified")
+	}
+	valid, err = authority.Verify(common.AddressCertificateMessage(p.Machine.PeerID, p.Machine.IP), p.Certificate)
+	if err != nil {
+		return false, err
+	} else if !valid {
+		return false, fmt.Errorf("invalid address certificate")
+	}
+	return p.IP == p.Machine.IP, nil
+}
+
+// ownsIP tells if IP is the Machine IP or belongs to one of its local routes
+func (p *PRPacket) ownsIP() bool {
+	if p.IP == p.Machine.IP {
+		return true
+	}
+	ip := net.ParseIP(p.IP)
+	for _, route := range p.Machine.LocalRoutes {
+		_, ipnet, err := net.ParseCIDR(route)
+		if err == nil && ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
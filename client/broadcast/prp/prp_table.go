@@ -1,34 +1,82 @@
 package prp
 
 import (
+	"net"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/libp2p/go-libp2p/core/crypto"
+
 	"github.com/gfleury/solo/client/broadcast/protocol"
+	"github.com/gfleury/solo/client/events"
 	"github.com/gfleury/solo/common/models"
 )
 
+const (
+	// Entries not re-announced for longer than this are dropped
+	PRP_ENTRY_TTL = 10 * time.Minute
+	// Maximum entries, the least recently used are evicted
+	PRP_TABLE_MAX_ENTRIES = 4096
+	// PRPRequests for an unknown IP are retried with exponential backoff
+	PRP_REQUEST_MIN_BACKOFF = 2 * time.Second
+	PRP_REQUEST_MAX_BACKOFF = 2 * time.Minute
+	// Maximum unknown IPs remembered
+	PRP_NEGATIVE_MAX_ENTRIES = 4096
+	// Peers announce themselves every PRP_ANNOUNCE_INTERVAL, with jitter
+	PRP_ANNOUNCE_INTERVAL = time.Minute
+	// A peer not announced for longer than this is reported missing
+	PRP_PEER_MISSING_AFTER = 3 * PRP_ANNOUNCE_INTERVAL
+)
+
 type PRPEntry struct {
 	sync.Mutex
 
-	Machine  *models.NetworkNode
+	Machine *models.NetworkNode
+	// LastSeen is the last announcement, LastUsed the last lookup
 	LastSeen time.Time
+	LastUsed time.Time
+	// Certified entries were announced with a valid address certificate
+	Certified bool
+	// Reply is the signed announcement, relayed on table syncs
+	Reply *PRPacket
+
+	missing bool
+}
+
+// negativeEntry is an unknown IP, PRPRequests for it are sent again after next
+type negativeEntry struct {
+	next    time.Time
+	backoff time.Duration
 }
 
 type PRPTableType struct {
 	sync.Mutex
 
-	Table           Table
-	LastLookupTable TimeTable
-	localIP         string
-	lastReplySent   time.Time
+	Table         Table
+	negative      map[string]*negativeEntry
+	localIP       string
+	lastReplySent time.Time
+	// subnet is the overlay network, local routes can't overlap it
+	subnet *net.IPNet
+
+	// signer signs our replies, certificate is our address certificate issued by authority
+	signer      crypto.PrivKey
+	certificate []byte
+	authority   crypto.PubKey
+
+	events *events.Bus
+
+	// macs are the peers the MAC addresses are behind, see prp_mac.go
+	macs map[string]*macEntry
 }
 
 func NewPRPTable() *PRPTableType {
 	return &PRPTableType{
-		Table:           make(map[string]*PRPEntry, 256),
-		LastLookupTable: make(map[string]time.Time, 256),
-		lastReplySent:   time.Now().Add(-10 * time.Second),
+		Table:         make(map[string]*PRPEntry, 256),
+		negative:      make(map[string]*negativeEntry, 256),
+		macs:          make(map[string]*macEntry, 256),
+		lastReplySent: time.Now().Add(-10 * time.Second),
 	}
 }
 
@@ -38,25 +86,147 @@ func (e *PRPEntry) UpdateLastSeen() {
 	e.LastSeen = time.Now()
 }
 
-// Returns Machine, isFound and if it was Queried Less Than 2 Seconds Ago
+func (e *PRPEntry) UpdateLastUsed() {
+	e.Lock()
+	defer e.Unlock()
+	e.LastUsed = time.Now()
+}
+
+func (e *PRPEntry) Expired() bool {
+	e.Lock()
+	defer e.Unlock()
+	return time.Since(e.LastSeen) > PRP_ENTRY_TTL
+}
+
+// Returns Machine, isFound and if a PRPRequest for it was sent not long ago,
+// unknown IPs are requested again with exponential backoff
 func (t *PRPTableType) Lookup(ip string) (*models.NetworkNode, bool, bool) {
 	t.Lock()
 	defer t.Unlock()
-	queriedAlmostNow := false
-	if last, ok := t.LastLookupTable.Get(ip); ok {
-		if time.Since(last) < 2*time.Second {
-			queriedAlmostNow = true
-		} else {
-			t.LastLookupTable.Put(ip, time.Now())
+
+	if e, ok := t.Table.Get(ip); ok {
+		if ip == t.localIP || !e.Expired() {
+			e.UpdateLastUsed()
+			return e.Machine, ok, false
 		}
-	} else {
-		t.LastLookupTable.Put(ip, time.Now())
+		// Not re-announced, the peer is gone or changed IP
+		delete(t.Table, ip)
+		t.events.Publish(events.Event{Type: events.ROUTE_REMOVED, PeerID: e.Machine.PeerID, IP: ip, Detail: "expired"})
 	}
-	if e, ok := t.Table.Get(ip); ok {
-		e.UpdateLastSeen()
-		return e.Machine, ok, queriedAlmostNow
+
+	n, ok := t.negative[ip]
+	if !ok {
+		if len(t.negative) >= PRP_NEGATIVE_MAX_ENTRIES {
+			t.pruneNegative()
+		}
+		t.negative[ip] = &negativeEntry{next: time.Now().Add(PRP_REQUEST_MIN_BACKOFF), backoff: PRP_REQUEST_MIN_BACKOFF}
+		return nil, false, false
+	}
+
+	if time.Now().Before(n.next) {
+		return nil, false, true
+	}
+
+	n.backoff *= 2
+	if n.backoff > PRP_REQUEST_MAX_BACKOFF {
+		n.backoff = PRP_REQUEST_MAX_BACKOFF
+	}
+	n.next = time.Now().Add(n.backoff)
+	return nil, false, false
+}
+
+// Unresolved tells if ip was requested and left unanswered for at least
+// PRP_REQUEST_MIN_BACKOFF, it is then likely not on the network
+func (t *PRPTableType) Unresolved(ip string) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	n, ok := t.negative[ip]
+	return ok && n.backoff > PRP_REQUEST_MIN_BACKOFF
+}
+
+// pruneNegative drops the unknown IPs not looked up for a while, or all of
+// them if none is old enough, t must be locked
+func (t *PRPTableType) pruneNegative() {
+	for ip, n := range t.negative {
+		if time.Since(n.next) > PRP_REQUEST_MAX_BACKOFF {
+			delete(t.negative, ip)
+		}
+	}
+	if len(t.negative) >= PRP_NEGATIVE_MAX_ENTRIES {
+		t.negative = make(map[string]*negativeEntry, 256)
 	}
-	return nil, false, queriedAlmostNow
+}
+
+// evict drops the expired entries and the least recently used ones past
+// PRP_TABLE_MAX_ENTRIES, t must be locked
+func (t *PRPTableType) evict() {
+	for ip, e := range t.Table {
+		if ip != t.localIP && e.Expired() {
+			delete(t.Table, ip)
+			t.events.Publish(events.Event{Type: events.ROUTE_REMOVED, PeerID: e.Machine.PeerID, IP: ip, Detail: "expired"})
+		}
+	}
+
+	for len(t.Table) > PRP_TABLE_MAX_ENTRIES {
+		oldestIP := ""
+		var oldest time.Time
+		for ip, e := range t.Table {
+			if ip == t.localIP {
+				continue
+			}
+			e.Lock()
+			used := e.LastUsed
+			if e.LastSeen.After(used) {
+				used = e.LastSeen
+			}
+			e.Unlock()
+			if oldestIP == "" || used.Before(oldest) {
+				oldestIP, oldest = ip, used
+			}
+		}
+		t.events.Publish(events.Event{Type: events.ROUTE_REMOVED, PeerID: t.Table[oldestIP].Machine.PeerID, IP: oldestIP, Detail: "evicted"})
+		delete(t.Table, oldestIP)
+	}
+}
+
+// Len returns the amount of entries, ourselves included
+func (t *PRPTableType) Len() int {
+	t.Lock()
+	defer t.Unlock()
+	return len(t.Table)
+}
+
+// Routes returns a copy of the not expired entries by IP, ourselves included
+func (t *PRPTableType) Routes() map[string]models.NetworkNode {
+	t.Lock()
+	defer t.Unlock()
+
+	routes := make(map[string]models.NetworkNode, len(t.Table))
+	for ip, e := range t.Table {
+		if ip != t.localIP && e.Expired() {
+			continue
+		}
+		routes[ip] = *e.Machine
+	}
+	return routes
+}
+
+// LookupHostname returns the IP announced by the peer named hostname
+func (t *PRPTableType) LookupHostname(hostname string) (string, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	for ip, e := range t.Table {
+		// Only the peers own IPs are announced, not their local routes
+		if ip != e.Machine.IP || !strings.EqualFold(e.Machine.Hostname, hostname) {
+			continue
+		}
+		if ip == t.localIP || !e.Expired() {
+			return ip, true
+		}
+	}
+	return "", false
 }
 
 func (t *PRPTableType) Myself() (string, *models.NetworkNode) {
@@ -69,25 +239,151 @@ func (t *PRPTableType) Myself() (string, *models.NetworkNode) {
 	return t.localIP, nil
 }
 
-func (t *PRPTableType) insertEntry(ip string, m *models.NetworkNode) {
+type insertResult int
+
+const (
+	inserted insertResult = iota
+	// conflict means the IP was claimed by another peer, which was replaced
+	// by a certified claim
+	conflict
+	// rejected means the IP belongs to us or to another live peer
+	rejected
+)
+
+// insertReply stores the machine of a verified reply, kept for table syncs
+func (t *PRPTableType) insertReply(p *PRPacket, certified bool) insertResult {
+	return t.insert(p.IP, &PRPEntry{Machine: &p.Machine, LastSeen: time.Now(), Certified: certified, Reply: p})
+}
+
+// insertSynced stores a reply learned from a table sync, seen when it was
+// signed, it never replaces a more recent announcement of the same peer
+func (t *PRPTableType) insertSynced(p *PRPacket, certified bool) insertResult {
+	signedAt := time.Unix(p.SignedAt, 0)
+	if time.Since(signedAt) > PRP_ENTRY_TTL {
+		return inserted
+	}
+
+	t.Lock()
+	if e, ok := t.Table.Get(p.IP); ok && e.Machine.PeerID == p.Machine.PeerID {
+		e.Lock()
+		newer := e.LastSeen.After(signedAt)
+		e.Unlock()
+		if newer {
+			t.Unlock()
+			return inserted
+		}
+	}
+	t.Unlock()
+
+	return t.insert(p.IP, &PRPEntry{Machine: &p.Machine, LastSeen: signedAt, Certified: certified, Reply: p})
+}
+
+// insert stores or refreshes the entry of ip. The IP is kept by its first
+// live owner until it expires, only a certified claim replaces it, and our
+// own IP is never replaced
+func (t *PRPTableType) insert(ip string, entry *PRPEntry) insertResult {
 	t.Lock()
 	defer t.Unlock()
-	t.Table.Put(ip, &PRPEntry{Machine: m, LastSeen: time.Now()})
+
+	// Local routes are not certified, they never claim an overlay IP
+	route := ip != entry.Machine.IP
+	if route && t.overlapsSubnet(entry.Machine.LocalRoutes) {
+		return rejected
+	}
+
+	result := inserted
+	e, known := t.Table.Get(ip)
+	if known && e.Machine.PeerID != entry.Machine.PeerID && !e.Expired() {
+		if ip == t.localIP || !entry.Certified || (route && ip == e.Machine.IP) {
+			return rejected
+		}
+		result = conflict
+	}
+	if !known || e.Machine.PeerID != entry.Machine.PeerID {
+		t.events.Publish(events.Event{Type: events.ROUTE_LEARNED, PeerID: entry.Machine.PeerID, IP: ip})
+	}
+
+	entry.LastUsed = time.Now()
+	t.Table.Put(ip, entry)
+	delete(t.negative, ip)
+	if len(t.Table) > PRP_TABLE_MAX_ENTRIES {
+		t.evict()
+	}
+	return result
+}
+
+// overlapsSubnet tells if one of routes overlaps the overlay subnet, t must be locked
+func (t *PRPTableType) overlapsSubnet(routes []string) bool {
+	if t.subnet == nil {
+		return false
+	}
+	for _, route := range routes {
+		_, ipnet, err := net.ParseCIDR(route)
+		if err != nil || ipnet.Contains(t.subnet.IP) || t.subnet.Contains(ipnet.IP) {
+			return true
+		}
+	}
+	return false
 }
 
 func (t *PRPTableType) InsertMyselfEntry(m *models.NetworkNode) {
+	t.Lock()
+	defer t.Unlock()
 	t.localIP = m.IP
-	t.insertEntry(t.localIP, m)
+	now := time.Now()
+	t.Table.Put(t.localIP, &PRPEntry{Machine: m, LastSeen: now, LastUsed: now, Certified: true})
+}
+
+// SetSubnet sets the overlay subnet, the local routes announced by the peers
+// must be outside of it
+func (t *PRPTableType) SetSubnet(subnet *net.IPNet) {
+	t.Lock()
+	defer t.Unlock()
+	t.subnet = subnet
+}
+
+// SetSigningKey sets the key our replies are signed with, our libp2p private key
+func (t *PRPTableType) SetSigningKey(key crypto.PrivKey) {
+	t.Lock()
+	defer t.Unlock()
+	t.signer = key
+}
+
+// SetAddressCertificate sets our address certificate and the public key of
+// the authority, core-api, that issues and verifies them
+func (t *PRPTableType) SetAddressCertificate(certificate []byte, authority crypto.PubKey) {
+	t.Lock()
+	defer t.Unlock()
+	t.certificate = certificate
+	t.authority = authority
+}
+
+func (t *PRPTableType) addressAuthority() crypto.PubKey {
+	t.Lock()
+	defer t.Unlock()
+	return t.authority
+}
+
+// reply builds a signed reply for ip, t must be locked
+func (t *PRPTableType) reply(myself *models.NetworkNode, ip string) protocol.Payload {
+	p := &PRPacket{PRPType: PRPReply, Machine: *myself, IP: ip, Certificate: t.certificate}
+	if t.signer != nil {
+		p.Sign(t.signer)
+	}
+	return p
 }
 
 func (t *PRPTableType) PRPReplyMyself(always bool) protocol.Payload {
 	// Return nil if we already replied in the last second
 	if time.Since(t.lastReplySent) > 1*time.Second || always {
 		ip, myself := t.Myself()
+		if myself == nil {
+			return nil
+		}
 		t.Lock()
 		defer t.Unlock()
 		t.lastReplySent = time.Now()
-		return &PRPacket{PRPReply, *myself, ip}
+		return t.reply(myself, ip)
 	}
 	return nil
 }
@@ -99,7 +395,57 @@ func (t *PRPTableType) PRPReplyMyLocalNetwork(always bool, ip string) protocol.P
 		t.Lock()
 		defer t.Unlock()
 		t.lastReplySent = time.Now()
-		return &PRPacket{PRPReply, *myself, ip}
+		return t.reply(myself, ip)
 	}
 	return nil
 }
+
+// PRPTableSync returns our table for a peer that just joined, as the signed
+// replies we received, which it verifies one by one, and a fresh one of ours
+func (t *PRPTableType) PRPTableSync() protocol.Payload {
+	ip, myself := t.Myself()
+	t.Lock()
+	defer t.Unlock()
+
+	tableSync := &PRPacket{PRPType: PRPTableSync}
+	if myself != nil {
+		tableSync.Entries = append(tableSync.Entries, *t.reply(myself, ip).(*PRPacket))
+	}
+	for entryIP, e := range t.Table {
+		if entryIP == t.localIP || e.Reply == nil || e.Expired() {
+			continue
+		}
+		tableSync.Entries = append(tableSync.Entries, *e.Reply)
+	}
+	return tableSync
+}
+
+// SetEventBus sets the bus the learned routes and missing peers are published to
+func (t *PRPTableType) SetEventBus(bus *events.Bus) {
+	t.Lock()
+	defer t.Unlock()
+	t.events = bus
+}
+
+// CheckMissingPeers returns the peers not announced for longer than
+// PRP_PEER_MISSING_AFTER, each one only once until it announces again
+func (t *PRPTableType) CheckMissingPeers() map[string]*models.NetworkNode {
+	t.Lock()
+	defer t.Unlock()
+
+	missing := map[string]*models.NetworkNode{}
+	for ip, e := range t.Table {
+		// Only the peers own IPs are announced, not their local routes
+		if ip == t.localIP || ip != e.Machine.IP {
+			continue
+		}
+		e.Lock()
+		if !e.missing && time.Since(e.LastSeen) > PRP_PEER_MISSING_AFTER {
+			e.missing = true
+			missing[ip] = e.Machine
+			t.events.Publish(events.Event{Type: events.PEER_MISSING, PeerID: e.Machine.PeerID, IP: ip})
+		}
+		e.Unlock()
+	}
+	return missing
+}
@@ -0,0 +1,414 @@
+package prp
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gfleury/solo/client/events"
+	"github.com/gfleury/solo/client/logger"
+	"github.com/gfleury/solo/common"
+	"github.com/gfleury/solo/common/models"
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func newKey(t *testing.T) (crypto.PrivKey, string) {
+	key, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+	return key, id.String()
+}
+
+// signedReply returns the reply of ip by the peer of key, signed
+func signedReply(t *testing.T, key crypto.PrivKey, ip string, machine models.NetworkNode) *PRPacket {
+	id, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+	machine.PeerID = id.String()
+	p := &PRPacket{PRPType: PRPReply, IP: ip, Machine: machine}
+	require.NoError(t, p.Sign(key))
+	return p
+}
+
+func TestPRPReplySignature(t *testing.T) {
+	key, peerID := newKey(t)
+	otherKey, otherPeerID := newKey(t)
+
+	machine := models.NetworkNode{PeerID: peerID, IP: "10.2.3.1", LocalRoutes: []string{"192.168.0.0/24"}}
+
+	p := &PRPacket{PRPType: PRPReply, IP: "10.2.3.1", Machine: machine}
+	_, err := p.Verify(nil)
+	require.Error(t, err, "unsigned reply")
+
+	require.NoError(t, p.Sign(key))
+	certified, err := p.Verify(nil)
+	require.NoError(t, err)
+	require.False(t, certified)
+
+	// Survives the wire encoding
+	b, err := p.MarshalBinary()
+	require.NoError(t, err)
+	decoded := &PRPacket{}
+	require.NoError(t, decoded.UnmarshalBinary(b))
+	_, err = decoded.Verify(nil)
+	require.NoError(t, err)
+
+	// Signed by another peer
+	p.Sign(otherKey)
+	_, err = p.Verify(nil)
+	require.Error(t, err)
+
+	// Tampered IP
+	p.Sign(key)
+	p.IP = "10.2.3.9"
+	_, err = p.Verify(nil)
+	require.Error(t, err)
+
+	// Local routes can be claimed
+	p.IP = "192.168.0.10"
+	p.Sign(key)
+	_, err = p.Verify(nil)
+	require.NoError(t, err)
+
+	// Sent by another peer
+	p.SetSenderID(otherPeerID)
+	_, err = p.Verify(nil)
+	require.Error(t, err)
+	p.SetSenderID(peerID)
+
+	// Replayed
+	p.SignedAt = time.Now().Add(-2 * PRP_REPLY_MAX_AGE).Unix()
+	_, err = p.Verify(nil)
+	require.Error(t, err)
+}
+
+func TestPRPAddressCertificate(t *testing.T) {
+	authorityKey, _ := newKey(t)
+	key, peerID := newKey(t)
+	hijackerKey, hijackerPeerID := newKey(t)
+
+	certificate, err := authorityKey.Sign(common.AddressCertificateMessage(peerID, "10.2.3.1/24"))
+	require.NoError(t, err)
+
+	table := NewPRPTable()
+	table.SetAddressCertificate(nil, authorityKey.GetPublic())
+
+	p := &PRPacket{PRPType: PRPReply, IP: "10.2.3.1", Machine: models.NetworkNode{PeerID: peerID, IP: "10.2.3.1"}, Certificate: certificate}
+	p.Sign(key)
+	certified, err := p.Verify(authorityKey.GetPublic())
+	require.NoError(t, err)
+	require.True(t, certified)
+
+	_, err = p.Process(logger.New(log.LevelDebug), table)
+	require.NoError(t, err)
+
+	// An uncertified reply can't take over a certified IP
+	hijack := &PRPacket{PRPType: PRPReply, IP: "10.2.3.1", Machine: models.NetworkNode{PeerID: hijackerPeerID, IP: "10.2.3.1"}}
+	hijack.Sign(hijackerKey)
+	_, err = hijack.Process(logger.New(log.LevelDebug), table)
+	require.NoError(t, err)
+
+	machine, found, _ := table.Lookup("10.2.3.1")
+	require.True(t, found)
+	require.Equal(t, peerID, machine.PeerID)
+
+	// Nor with the certificate of someone else
+	hijack.Certificate = certificate
+	_, err = hijack.Verify(authorityKey.GetPublic())
+	require.Error(t, err)
+
+	// Nor with its own certificate and a local route covering the IP
+	hijackerCertificate, err := authorityKey.Sign(common.AddressCertificateMessage(hijackerPeerID, "10.2.3.3/24"))
+	require.NoError(t, err)
+	route := &PRPacket{PRPType: PRPReply, IP: "10.2.3.1", Machine: models.NetworkNode{PeerID: hijackerPeerID, IP: "10.2.3.3", LocalRoutes: []string{"10.2.3.0/24"}}, Certificate: hijackerCertificate}
+	route.Sign(hijackerKey)
+	certified, err = route.Verify(authorityKey.GetPublic())
+	require.NoError(t, err)
+	require.False(t, certified, "the certificate doesn't cover local routes")
+	_, err = route.Process(logger.New(log.LevelDebug), table)
+	require.NoError(t, err)
+	machine, _, _ = table.Lookup("10.2.3.1")
+	require.Equal(t, peerID, machine.PeerID)
+
+	// Local routes overlapping the overlay subnet are refused
+	_, subnet, _ := net.ParseCIDR("10.2.3.0/24")
+	table.SetSubnet(subnet)
+	route.IP = "10.2.3.9"
+	route.Sign(hijackerKey)
+	_, err = route.Process(logger.New(log.LevelDebug), table)
+	require.NoError(t, err)
+	_, found, _ = table.Lookup("10.2.3.9")
+	require.False(t, found)
+
+	// With an authority, uncertified replies are refused even for free IPs
+	unknown := &PRPacket{PRPType: PRPReply, IP: "10.2.3.2", Machine: models.NetworkNode{PeerID: hijackerPeerID, IP: "10.2.3.2"}}
+	unknown.Sign(hijackerKey)
+	_, err = unknown.Verify(authorityKey.GetPublic())
+	require.Error(t, err)
+	_, err = unknown.Process(logger.New(log.LevelDebug), table)
+	require.NoError(t, err)
+	_, found, _ = table.Lookup("10.2.3.2")
+	require.False(t, found)
+}
+
+func TestPRPTableExpiry(t *testing.T) {
+	table := NewPRPTable()
+	table.InsertMyselfEntry(&models.NetworkNode{PeerID: "myself", IP: "10.2.3.1"})
+	key2, _ := newKey(t)
+	require.Equal(t, inserted, table.insertReply(signedReply(t, key2, "10.2.3.2", models.NetworkNode{IP: "10.2.3.2"}), false))
+
+	_, found, _ := table.Lookup("10.2.3.2")
+	require.True(t, found)
+
+	// Not re-announced entries expire, ourselves never
+	for _, e := range table.Table {
+		e.LastSeen = time.Now().Add(-PRP_ENTRY_TTL - time.Second)
+	}
+	_, found, _ = table.Lookup("10.2.3.2")
+	require.False(t, found)
+	_, found, _ = table.Lookup("10.2.3.1")
+	require.True(t, found)
+}
+
+func TestPRPTableLookupHostname(t *testing.T) {
+	table := NewPRPTable()
+	table.InsertMyselfEntry(&models.NetworkNode{PeerID: "myself", IP: "10.2.3.1", Hostname: "laptop"})
+	key2, _ := newKey(t)
+	key3, _ := newKey(t)
+	require.Equal(t, inserted, table.insertReply(signedReply(t, key2, "10.2.3.2", models.NetworkNode{IP: "10.2.3.2", Hostname: "staging"}), false))
+	// Local routes are not the peer address
+	router := models.NetworkNode{IP: "10.2.3.3", Hostname: "router", LocalRoutes: []string{"192.168.0.0/24"}}
+	require.Equal(t, inserted, table.insertReply(signedReply(t, key3, "192.168.0.1", router), false))
+
+	ip, found := table.LookupHostname("Staging")
+	require.True(t, found)
+	require.Equal(t, "10.2.3.2", ip)
+	ip, found = table.LookupHostname("laptop")
+	require.True(t, found)
+	require.Equal(t, "10.2.3.1", ip)
+	_, found = table.LookupHostname("router")
+	require.False(t, found)
+
+	require.Len(t, table.Routes(), 3)
+	for _, e := range table.Table {
+		e.LastSeen = time.Now().Add(-PRP_ENTRY_TTL - time.Second)
+	}
+	_, found = table.LookupHostname("staging")
+	require.False(t, found)
+	require.Len(t, table.Routes(), 1)
+}
+
+func TestPRPTableNegativeCache(t *testing.T) {
+	table := NewPRPTable()
+
+	_, found, requestedNotLongAgo := table.Lookup("10.2.3.9")
+	require.False(t, found)
+	require.False(t, requestedNotLongAgo)
+
+	_, _, requestedNotLongAgo = table.Lookup("10.2.3.9")
+	require.True(t, requestedNotLongAgo)
+	require.False(t, table.Unresolved("10.2.3.9"))
+
+	// Backoff doubles after each request
+	table.negative["10.2.3.9"].next = time.Now().Add(-time.Millisecond)
+	_, _, requestedNotLongAgo = table.Lookup("10.2.3.9")
+	require.False(t, requestedNotLongAgo)
+	require.Equal(t, 2*PRP_REQUEST_MIN_BACKOFF, table.negative["10.2.3.9"].backoff)
+	require.True(t, table.Unresolved("10.2.3.9"), "unanswered for a whole backoff")
+
+	// Announcements clear the negative entry
+	key9, _ := newKey(t)
+	table.insertReply(signedReply(t, key9, "10.2.3.9", models.NetworkNode{IP: "10.2.3.9"}), false)
+	require.NotContains(t, table.negative, "10.2.3.9")
+	require.False(t, table.Unresolved("10.2.3.9"))
+}
+
+func TestPRPTableEviction(t *testing.T) {
+	table := NewPRPTable()
+	table.InsertMyselfEntry(&models.NetworkNode{PeerID: "myself", IP: "10.2.3.1"})
+
+	key, _ := newKey(t)
+	for i := 0; i < PRP_TABLE_MAX_ENTRIES+10; i++ {
+		ip := fmt.Sprintf("10.3.%d.%d", i/256, i%256)
+		table.insertReply(signedReply(t, key, ip, models.NetworkNode{IP: ip}), false)
+	}
+	require.Len(t, table.Table, PRP_TABLE_MAX_ENTRIES)
+
+	_, found, _ := table.Lookup("10.2.3.1")
+	require.True(t, found)
+}
+
+func withoutTime(e events.Event) events.Event {
+	e.Time = time.Time{}
+	return e
+}
+
+func TestPRPTableConflict(t *testing.T) {
+	table := NewPRPTable()
+	bus := events.NewBus()
+	table.SetEventBus(bus)
+	s := bus.Subscribe(events.ROUTE_LEARNED)
+	table.InsertMyselfEntry(&models.NetworkNode{PeerID: "myself", IP: "10.2.3.1"})
+	key2, peerID2 := newKey(t)
+	key3, peerID3 := newKey(t)
+	key4, peerID4 := newKey(t)
+
+	require.Equal(t, rejected, table.insertReply(signedReply(t, key2, "10.2.3.1", models.NetworkNode{IP: "10.2.3.1"}), false))
+
+	require.Equal(t, inserted, table.insertReply(signedReply(t, key2, "10.2.3.2", models.NetworkNode{IP: "10.2.3.2"}), false))
+	require.Equal(t, inserted, table.insertReply(signedReply(t, key2, "10.2.3.2", models.NetworkNode{IP: "10.2.3.2"}), false))
+	// The first live owner keeps the IP, unless the newcomer is certified
+	require.Equal(t, rejected, table.insertReply(signedReply(t, key3, "10.2.3.2", models.NetworkNode{IP: "10.2.3.2"}), false))
+	machine, _, _ := table.Lookup("10.2.3.2")
+	require.Equal(t, peerID2, machine.PeerID)
+
+	// Local routes never take the IP of a live peer
+	route := models.NetworkNode{IP: "10.2.3.3", LocalRoutes: []string{"10.2.3.0/24"}}
+	require.Equal(t, rejected, table.insertReply(signedReply(t, key3, "10.2.3.2", route), true))
+	machine, _, _ = table.Lookup("10.2.3.2")
+	require.Equal(t, peerID2, machine.PeerID)
+
+	require.Equal(t, conflict, table.insertReply(signedReply(t, key3, "10.2.3.2", models.NetworkNode{IP: "10.2.3.2"}), true))
+	machine, _, _ = table.Lookup("10.2.3.2")
+	require.Equal(t, peerID3, machine.PeerID)
+
+	// Expired owners are replaced
+	table.Table["10.2.3.2"].LastSeen = time.Now().Add(-PRP_ENTRY_TTL - time.Second)
+	require.Equal(t, inserted, table.insertReply(signedReply(t, key4, "10.2.3.2", models.NetworkNode{IP: "10.2.3.2"}), false))
+
+	// Refreshes of a known route are not published
+	require.Equal(t, events.Event{Type: events.ROUTE_LEARNED, PeerID: peerID2, IP: "10.2.3.2"}, withoutTime(<-s.C))
+	require.Equal(t, events.Event{Type: events.ROUTE_LEARNED, PeerID: peerID3, IP: "10.2.3.2"}, withoutTime(<-s.C))
+	require.Equal(t, events.Event{Type: events.ROUTE_LEARNED, PeerID: peerID4, IP: "10.2.3.2"}, withoutTime(<-s.C))
+	require.Empty(t, s.C)
+}
+
+func TestPRPTableSync(t *testing.T) {
+	logger := logger.New(log.LevelError)
+	key, peerID := newKey(t)
+	key2, peerID2 := newKey(t)
+	newcomerKey, newcomerPeerID := newKey(t)
+
+	neighbour := NewPRPTable()
+	neighbour.InsertMyselfEntry(&models.NetworkNode{PeerID: peerID, IP: "10.2.3.1"})
+	neighbour.SetSigningKey(key)
+
+	reply := &PRPacket{PRPType: PRPReply, IP: "10.2.3.2", Machine: models.NetworkNode{PeerID: peerID2, IP: "10.2.3.2"}}
+	require.NoError(t, reply.Sign(key2))
+	reply.SetSenderID(peerID2)
+	_, err := reply.Process(logger, neighbour)
+	require.NoError(t, err)
+
+	// Requests to other peers are ignored
+	sync, err := NewPRPTableSyncRequestPacket(newcomerPeerID).Process(logger, neighbour)
+	require.NoError(t, err)
+	require.Nil(t, sync)
+
+	sync, err = NewPRPTableSyncRequestPacket(peerID).Process(logger, neighbour)
+	require.NoError(t, err)
+	require.True(t, sync.(*PRPacket).Unicast())
+	require.Len(t, sync.(*PRPacket).Entries, 2)
+
+	b, err := sync.MarshalBinary()
+	require.NoError(t, err)
+	decoded := &PRPacket{}
+	require.NoError(t, decoded.UnmarshalBinary(b))
+	decoded.SetSenderID(peerID)
+
+	newcomer := NewPRPTable()
+	newcomer.InsertMyselfEntry(&models.NetworkNode{PeerID: newcomerPeerID, IP: "10.2.3.3"})
+	newcomer.SetSigningKey(newcomerKey)
+	_, err = decoded.Process(logger, newcomer)
+	require.NoError(t, err)
+
+	machine, found, _ := newcomer.Lookup("10.2.3.1")
+	require.True(t, found)
+	require.Equal(t, peerID, machine.PeerID)
+	machine, found, _ = newcomer.Lookup("10.2.3.2")
+	require.True(t, found)
+	require.Equal(t, peerID2, machine.PeerID)
+
+	// Tampered entries are dropped
+	decoded.Entries[0].IP = "10.2.3.3"
+	newcomer = NewPRPTable()
+	_, err = decoded.Process(logger, newcomer)
+	require.NoError(t, err)
+	_, found, _ = newcomer.Lookup("10.2.3.3")
+	require.False(t, found)
+}
+
+func TestPRPTableMissingPeers(t *testing.T) {
+	table := NewPRPTable()
+	table.InsertMyselfEntry(&models.NetworkNode{PeerID: "myself", IP: "10.2.3.1"})
+	key2, peerID2 := newKey(t)
+	machine := models.NetworkNode{IP: "10.2.3.2", LocalRoutes: []string{"192.168.0.0/24"}}
+	table.insertReply(signedReply(t, key2, "10.2.3.2", machine), false)
+	table.insertReply(signedReply(t, key2, "192.168.0.1", machine), false)
+
+	bus := events.NewBus()
+	table.SetEventBus(bus)
+	s := bus.Subscribe(events.PEER_MISSING)
+	require.Empty(t, table.CheckMissingPeers())
+
+	for _, e := range table.Table {
+		e.LastSeen = time.Now().Add(-PRP_PEER_MISSING_AFTER - time.Second)
+	}
+	// Local routes are not announced, only the peer IP is missing
+	require.Len(t, table.CheckMissingPeers(), 1)
+	require.Equal(t, events.Event{Type: events.PEER_MISSING, PeerID: peerID2, IP: "10.2.3.2"}, withoutTime(<-s.C))
+
+	// Reported only once until announced again
+	require.Empty(t, table.CheckMissingPeers())
+	table.insertReply(signedReply(t, key2, "10.2.3.2", machine), false)
+	table.Table["10.2.3.2"].LastSeen = time.Now().Add(-PRP_PEER_MISSING_AFTER - time.Second)
+	require.Len(t, table.CheckMissingPeers(), 1)
+}
+
+func TestPRPTableMAC(t *testing.T) {
+	key, peerID := newKey(t)
+	table := NewPRPTable()
+	table.InsertMyselfEntry(&models.NetworkNode{PeerID: "myself", IP: "10.2.3.1"})
+	require.Nil(t, table.PRPMACAnnounce(), "no local MACs")
+
+	// Local MACs are only new once
+	require.True(t, table.LearnLocalMAC("02:00:00:00:00:01"))
+	require.False(t, table.LearnLocalMAC("02:00:00:00:00:01"))
+	owner, found := table.LookupMAC("02:00:00:00:00:01")
+	require.True(t, found)
+	require.Equal(t, "myself", owner)
+
+	announce := table.PRPMACAnnounce().(*PRPacket)
+	require.Equal(t, []string{"02:00:00:00:00:01"}, announce.MACs)
+
+	// Announces of other peers are verified, MACs are signed
+	remote := NewPRPTable()
+	p := &PRPacket{PRPType: PRPMACAnnounce, IP: "10.2.3.2", Machine: models.NetworkNode{PeerID: peerID, IP: "10.2.3.2"}, MACs: []string{"02:00:00:00:00:02"}}
+	require.NoError(t, p.Sign(key))
+	b, err := p.MarshalBinary()
+	require.NoError(t, err)
+	decoded := &PRPacket{}
+	require.NoError(t, decoded.UnmarshalBinary(b))
+	decoded.MACs = append(decoded.MACs, "02:00:00:00:00:03")
+	_, err = decoded.Process(logger.New(log.LevelDebug), remote)
+	require.NoError(t, err)
+	_, found = remote.LookupMAC("02:00:00:00:00:03")
+	require.False(t, found, "tampered announce")
+
+	_, err = p.Process(logger.New(log.LevelDebug), remote)
+	require.NoError(t, err)
+	owner, found = remote.LookupMAC("02:00:00:00:00:02")
+	require.True(t, found)
+	require.Equal(t, peerID, owner)
+
+	// MACs move to the last peer they are seen behind, and age out
+	remote.LearnMAC("02:00:00:00:00:02", "peer3")
+	owner, _ = remote.LookupMAC("02:00:00:00:00:02")
+	require.Equal(t, "peer3", owner)
+	remote.macs["02:00:00:00:00:02"].lastSeen = time.Now().Add(-PRP_MAC_TTL - time.Second)
+	_, found = remote.LookupMAC("02:00:00:00:00:02")
+	require.False(t, found)
+}
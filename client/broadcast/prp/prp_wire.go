@@ -0,0 +1,127 @@
+package prp
+
+import (
+	"github.com/gfleury/solo/client/broadcast/protocol"
+	"github.com/gfleury/solo/common/models"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func init() {
+	protocol.Register(protocol.Type_PRP, func() protocol.Payload { return &PRPacket{} })
+}
+
+// MarshalBinary encodes the PRPacket with protobuf, the machine is sent as the
+// compact Node descriptor of marshalNode
+func (p *PRPacket) MarshalBinary() ([]byte, error) {
+	b := protowire.AppendTag(nil, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(p.PRPType))
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendBytes(b, marshalNode(&p.Machine))
+	if p.IP != "" {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendString(b, p.IP)
+	}
+	if p.SignedAt != 0 {
+		b = protowire.AppendTag(b, 4, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(p.SignedAt))
+	}
+	if len(p.Signature) > 0 {
+		b = protowire.AppendTag(b, 5, protowire.BytesType)
+		b = protowire.AppendBytes(b, p.Signature)
+	}
+	if len(p.Certificate) > 0 {
+		b = protowire.AppendTag(b, 6, protowire.BytesType)
+		b = protowire.AppendBytes(b, p.Certificate)
+	}
+	for i := range p.Entries {
+		entry, err := p.Entries[i].MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendTag(b, 7, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	if p.PeerID != "" {
+		b = protowire.AppendTag(b, 8, protowire.BytesType)
+		b = protowire.AppendString(b, p.PeerID)
+	}
+	for _, mac := range p.MACs {
+		b = protowire.AppendTag(b, 9, protowire.BytesType)
+		b = protowire.AppendString(b, mac)
+	}
+	return b, nil
+}
+
+func (p *PRPacket) UnmarshalBinary(b []byte) error {
+	return protocol.ConsumeFields(b, func(f protocol.WireField) error {
+		switch f.Number {
+		case 1:
+			p.PRPType = PRPPacketType(f.Varint)
+		case 2:
+			return unmarshalNode(f.Bytes, &p.Machine)
+		case 3:
+			p.IP = string(f.Bytes)
+		case 4:
+			p.SignedAt = int64(f.Varint)
+		case 5:
+			p.Signature = append([]byte{}, f.Bytes...)
+		case 6:
+			p.Certificate = append([]byte{}, f.Bytes...)
+		case 7:
+			entry := PRPacket{}
+			if err := entry.UnmarshalBinary(f.Bytes); err != nil {
+				return err
+			}
+			p.Entries = append(p.Entries, entry)
+		case 8:
+			p.PeerID = string(f.Bytes)
+		case 9:
+			p.MACs = append(p.MACs, string(f.Bytes))
+		}
+		return nil
+	})
+}
+
+func marshalNode(n *models.NetworkNode) []byte {
+	b := []byte{}
+	for num, value := range []string{n.PeerID, n.Hostname, n.OS, n.Arch, n.IP, n.Version} {
+		if value == "" {
+			continue
+		}
+		b = protowire.AppendTag(b, protowire.Number(num+1), protowire.BytesType)
+		b = protowire.AppendString(b, value)
+	}
+	if len(n.PublicKey) > 0 {
+		b = protowire.AppendTag(b, 7, protowire.BytesType)
+		b = protowire.AppendBytes(b, n.PublicKey)
+	}
+	for _, route := range n.LocalRoutes {
+		b = protowire.AppendTag(b, 8, protowire.BytesType)
+		b = protowire.AppendString(b, route)
+	}
+	return b
+}
+
+func unmarshalNode(b []byte, n *models.NetworkNode) error {
+	return protocol.ConsumeFields(b, func(f protocol.WireField) error {
+		switch f.Number {
+		case 1:
+			n.PeerID = string(f.Bytes)
+		case 2:
+			n.Hostname = string(f.Bytes)
+		case 3:
+			n.OS = string(f.Bytes)
+		case 4:
+			n.Arch = string(f.Bytes)
+		case 5:
+			n.IP = string(f.Bytes)
+		case 6:
+			n.Version = string(f.Bytes)
+		case 7:
+			n.PublicKey = append([]byte{}, f.Bytes...)
+		case 8:
+			n.LocalRoutes = append(n.LocalRoutes, string(f.Bytes))
+		}
+		return nil
+	})
+}
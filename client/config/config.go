@@ -13,6 +13,7 @@ type Config struct {
 	Libp2pLogLevel       string
 	LogLevel             string
 	DiscoveryPeers       []string
+	StaticPeers          []string
 	PublicDiscoveryPeers bool
 	DiscoveryInterval    int
 	InterfaceMTU         int
@@ -21,6 +22,46 @@ type Config struct {
 	RandomIdentity       bool
 	RandomPort           bool
 	StandaloneMode       bool
+	// Broadcaster implementation, stream or gossipsub
+	Broadcaster string
+	// Userspace runs the VPN on a netstack instead of a TUN device
+	Userspace bool
+	// TAP runs the VPN on layer 2, attached to Bridge if set
+	TAP    bool
+	Bridge string
+
+	// Discovery services
+	MDNSDiscovery bool
+	DisableDHT    bool
+	// PrivateDHT uses a DHT namespace only joined by the network members
+	PrivateDHT bool
+	// Peer list discovery backends
+	DNSDiscovery     []string
+	HTTPDiscovery    []string
+	CoreAPIDiscovery bool
+	// Offline disables DHT and discovery peers and relies only on mDNS
+	Offline bool
+
+	// Hook scripts and webhooks run on the node events, "[event,...=]command"
+	Hooks    []string
+	Webhooks []string
+	// PostUp and PostDown run when the VPN interface is up and torn down
+	PostUp   []string
+	PostDown []string
+
+	// Port forwards over the network, see forward.Parse and forward.ParseReverse
+	Forwards        []string
+	ReverseForwards []string
+	// Expose are the loopback ports the members can connect to, "port[/udp]"
+	Expose              []string
+	AllowReverseForward bool
+
+	// Relay service for the network members
+	RelayService         bool
+	RelayMaxReservations int
+	RelayMaxCircuits     int
+	RelayMaxDuration     int
+	RelayMaxData         int64
 }
 
 func Peers2List(peers []string) discovery.AddrList {
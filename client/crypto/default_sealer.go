@@ -1,5 +1,7 @@
 package crypto
 
+import "fmt"
+
 type Sealer interface {
 	Seal([]byte, []byte) ([]byte, error)
 	Unseal([]byte, []byte) ([]byte, error)
@@ -15,3 +17,17 @@ func (*DefaultSealer) Seal(message []byte, key []byte) ([]byte, error) {
 func (*DefaultSealer) Unseal(message []byte, key []byte) ([]byte, error) {
 	return Unseal(message, key)
 }
+
+// UnsealAny tries to unseal message with each key in order, returning the
+// index of the key that worked
+func UnsealAny(s Sealer, message []byte, keys [][]byte) ([]byte, int, error) {
+	err := fmt.Errorf("no key to unseal message")
+	for i, key := range keys {
+		var unsealed []byte
+		unsealed, err = s.Unseal(message, key)
+		if err == nil {
+			return unsealed, i, nil
+		}
+	}
+	return nil, -1, err
+}
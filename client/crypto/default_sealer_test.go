@@ -39,5 +39,24 @@ var _ = Describe("Crypto utilities", func() {
 			Expect(err).ToNot(HaveOccurred())
 			Expect(decoded).To(Equal(message))
 		})
+
+		It("Unseals with any of the keys", func() {
+			key := sha256.Sum256([]byte(RandStringRunes(32)))
+			otherKey := sha256.Sum256([]byte(RandStringRunes(32)))
+			message := []byte("foo")
+
+			s := &DefaultSealer{}
+
+			encoded, err := s.Seal(message, key[:])
+			Expect(err).ToNot(HaveOccurred())
+
+			decoded, i, err := UnsealAny(s, encoded, [][]byte{otherKey[:], key[:]})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(decoded).To(Equal(message))
+			Expect(i).To(Equal(1))
+
+			_, _, err = UnsealAny(s, encoded, [][]byte{otherKey[:]})
+			Expect(err).To(HaveOccurred())
+		})
 	})
 })
@@ -8,13 +8,17 @@ import (
 	"github.com/creachadair/otp"
 )
 
+// OTP_SKEW_WINDOWS is how many windows before and after the current one are
+// still accepted, so peers with slightly skewed clocks keep meeting each other
+const OTP_SKEW_WINDOWS = 1
+
 type OTPKey struct {
 	Key                 string
 	KeyLength, Interval int
 }
 
-func (o *OTPKey) TOTP(f func() hash.Hash) string {
-	cfg := otp.Config{
+func (o *OTPKey) config(f func() hash.Hash) otp.Config {
+	return otp.Config{
 		Hash:     f,           // default is sha1.New
 		Digits:   o.KeyLength, // default is 6
 		TimeStep: otp.TimeWindow(o.Interval),
@@ -23,10 +27,40 @@ func (o *OTPKey) TOTP(f func() hash.Hash) string {
 			return base64.StdEncoding.EncodeToString(hash)[:nb]
 		},
 	}
+}
+
+func (o *OTPKey) TOTP(f func() hash.Hash) string {
+	cfg := o.config(f)
 	return cfg.TOTP()
 }
 
+// TOTPWindow returns the TOTP of the window offset windows away from the current one
+func (o *OTPKey) TOTPWindow(f func() hash.Hash, offset int) string {
+	cfg := o.config(f)
+	return cfg.HOTP(uint64(int64(cfg.TimeStep()) + int64(offset)))
+}
+
+// TOTPWindows returns the TOTPs of the current window first, followed by the
+// previous and next OTP_SKEW_WINDOWS windows
+func (o *OTPKey) TOTPWindows(f func() hash.Hash) []string {
+	windows := []string{o.TOTPWindow(f, 0)}
+	for i := 1; i <= OTP_SKEW_WINDOWS; i++ {
+		windows = append(windows, o.TOTPWindow(f, -i), o.TOTPWindow(f, i))
+	}
+	return windows
+}
+
 func (o *OTPKey) TOTPSHA256(f func() hash.Hash) []byte {
 	k := sha256.Sum256([]byte(o.TOTP(f)))
 	return k[:]
 }
+
+// TOTPSHA256Windows returns the keys of TOTPWindows, the current window first
+func (o *OTPKey) TOTPSHA256Windows(f func() hash.Hash) [][]byte {
+	keys := [][]byte{}
+	for _, totp := range o.TOTPWindows(f) {
+		k := sha256.Sum256([]byte(totp))
+		keys = append(keys, k[:])
+	}
+	return keys
+}
@@ -42,3 +42,23 @@ func (s *OTPTestSuite) TestOTPGeneration() {
 
 	s.WithinDuration(now, last, 30*time.Second)
 }
+
+func (s *OTPTestSuite) TestOTPWindows() {
+	key0 := &OTPKey{
+		Key:       "0key1234",
+		KeyLength: 16,
+		Interval:  60,
+	}
+
+	windows := key0.TOTPWindows(sha256.New)
+	s.Len(windows, 1+2*OTP_SKEW_WINDOWS)
+	s.Equal(key0.TOTP(sha256.New), windows[0])
+	s.Equal(key0.TOTPWindow(sha256.New, -1), windows[1])
+	s.Equal(key0.TOTPWindow(sha256.New, 1), windows[2])
+	s.NotEqual(windows[0], windows[1])
+	s.NotEqual(windows[0], windows[2])
+
+	keys := key0.TOTPSHA256Windows(sha256.New)
+	s.Len(keys, len(windows))
+	s.Equal(key0.TOTPSHA256(sha256.New), keys[0])
+}
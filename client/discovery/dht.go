@@ -21,6 +21,7 @@ import (
 	"time"
 
 	"github.com/gfleury/solo/client/crypto"
+	"github.com/gfleury/solo/client/events"
 	"github.com/gfleury/solo/client/utils"
 
 	"github.com/ipfs/go-log"
@@ -29,12 +30,17 @@ import (
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/core/routing"
 	discovery "github.com/libp2p/go-libp2p/p2p/discovery/routing"
 )
 
 const (
 	DHT_FOUND = "DHT_FOUND"
+	// DISCOVERY_HINT tags the peers found by the discoveries that don't
+	// authenticate them, they are only tagged DHT_FOUND once they proved
+	// they are members
+	DISCOVERY_HINT = "DISCOVERY_HINT"
 )
 
 type DHT struct {
@@ -47,6 +53,14 @@ type DHT struct {
 	DiscoveryPeers    AddrList
 	DiscoveryInterval time.Duration
 	dhtOptions        []dht.Option
+
+	// Private uses a DHT only spoken by the network members instead of the
+	// public IPFS one, its protocol prefix is derived from the discovery key
+	Private bool
+	// BootstrapPeersFunc returns known network members to bootstrap the private DHT
+	BootstrapPeersFunc func() []peer.AddrInfo
+	// Events receives the members found
+	Events *events.Bus
 }
 
 func NewDHT(d ...dht.Option) *DHT {
@@ -54,11 +68,26 @@ func NewDHT(d ...dht.Option) *DHT {
 }
 
 func (d *DHT) Option(ctx context.Context) func(c *libp2p.Config) error {
+	if d.Private {
+		// The private DHT can only be created once the discovery key is known
+		return func(*libp2p.Config) error { return nil }
+	}
 	return libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
 		// make the DHT with the given Host
 		return d.startDHT(ctx, h)
 	})
 }
+
+// SetDiscoveryKey hands the network discovery key over to Run
+func (d *DHT) SetDiscoveryKey(key crypto.OTPKey) {
+	d.OTPKeyReceiver <- key
+}
+
+// SetEventBus sets the bus the members found are published to
+func (d *DHT) SetEventBus(bus *events.Bus) {
+	d.Events = bus
+}
+
 func (d *DHT) GetNextRendezvous() string {
 	totp := d.OTPKey.TOTP(sha256.New)
 
@@ -67,6 +96,34 @@ func (d *DHT) GetNextRendezvous() string {
 	return rv
 }
 
+// GetRendezvousWindows returns the rendezvous of the current OTP window
+// followed by the neighbour ones, so peers with skewed clocks still meet
+func (d *DHT) GetRendezvousWindows() []string {
+	rvs := []string{}
+	for _, totp := range d.OTPKey.TOTPWindows(sha256.New) {
+		rvs = append(rvs, crypto.MD5(totp))
+	}
+	d.latestRendezvous = rvs[0]
+	return rvs
+}
+
+// ProtocolPrefix is the private DHT protocol prefix of the network
+func (d *DHT) ProtocolPrefix() protocol.ID {
+	return protocol.ID("/solo/" + crypto.MD5(d.OTPKey.Key)[:16])
+}
+
+func (d *DHT) privateOptions() []dht.Option {
+	opts := []dht.Option{
+		dht.ProtocolPrefix(d.ProtocolPrefix()),
+		// Every member serves the private DHT, otherwise NATed members would leave it empty
+		dht.Mode(dht.ModeServer),
+	}
+	if d.BootstrapPeersFunc != nil {
+		opts = append(opts, dht.BootstrapPeersFunc(d.BootstrapPeersFunc))
+	}
+	return opts
+}
+
 func (d *DHT) startDHT(ctx context.Context, h host.Host) (*dht.IpfsDHT, error) {
 	if d.IpfsDHT == nil {
 		// Start a DHT, for use in peer discovery. We can't just make a new DHT
@@ -74,7 +131,12 @@ func (d *DHT) startDHT(ctx context.Context, h host.Host) (*dht.IpfsDHT, error) {
 		// DHT, so that the bootstrapping node of the DHT can go down without
 		// inhibiting future peer discovery.
 
-		kad, err := dht.New(ctx, h, d.dhtOptions...)
+		opts := d.dhtOptions
+		if d.Private {
+			opts = append(opts, d.privateOptions()...)
+		}
+
+		kad, err := dht.New(ctx, h, opts...)
 		if err != nil {
 			return nil, err
 		}
@@ -89,23 +151,31 @@ func (d *DHT) Run(c log.StandardLogger, ctx context.Context, host host.Host) err
 	// client because we want each peer to maintain its own local copy of the
 	// DHT, so that the bootstrapping node of the DHT can go down without
 	// inhibiting future peer discovery.
-	_, err := d.startDHT(ctx, host)
-	if err != nil {
-		return err
+	start := func() error {
+		_, err := d.startDHT(ctx, host)
+		if err != nil {
+			return err
+		}
+
+		// Bootstrap the DHT. In the default configuration, this spawns a Background
+		// thread that will refresh the peer table every five minutes.
+		c.Info("Bootstrapping DHT")
+		return d.IpfsDHT.Bootstrap(ctx)
 	}
 
-	// Bootstrap the DHT. In the default configuration, this spawns a Background
-	// thread that will refresh the peer table every five minutes.
-	c.Info("Bootstrapping DHT")
-	if err = d.IpfsDHT.Bootstrap(ctx); err != nil {
-		return err
+	if !d.Private {
+		if err := start(); err != nil {
+			return err
+		}
 	}
 
 	connect := func() {
 		d.bootstrapPeers(c, ctx, host)
-		rv := d.GetNextRendezvous()
-		c.Debugf("Announcing with key: %s", rv)
-		d.announceAndConnect(c, ctx, host, rv)
+		for _, rv := range d.GetRendezvousWindows() {
+			c.Debugf("Announcing with key: %s", rv)
+			err := d.announceAndConnect(c, ctx, host, rv)
+			observeDHT(d.IpfsDHT, err)
+		}
 	}
 
 	go func() {
@@ -115,6 +185,14 @@ func (d *DHT) Run(c log.StandardLogger, ctx context.Context, host host.Host) err
 		// Wait to receive the OTPKey from ConfigurationDiscovery
 		d.OTPKey = <-d.OTPKeyReceiver
 
+		if d.Private {
+			c.Infof("Using private DHT %s", d.ProtocolPrefix())
+			if err := start(); err != nil {
+				c.Errorf("Failed to start private DHT: %s", err)
+				return
+			}
+		}
+
 		t := utils.NewBackoffTicker(utils.BackoffMaxInterval(d.DiscoveryInterval))
 		defer t.Stop()
 		for {
@@ -161,6 +239,10 @@ func (d *DHT) FindClosePeers(logger log.StandardLogger) func(ctx context.Context
 		toStream := []peer.AddrInfo{}
 
 		go func() {
+			if d.IpfsDHT == nil {
+				close(peerChan)
+				return
+			}
 			closestPeers, err := d.GetClosestPeers(ctx, d.PeerID().String())
 			if err != nil {
 				logger.Error(err)
@@ -195,7 +277,10 @@ func (d *DHT) FindClosePeers(logger log.StandardLogger) func(ctx context.Context
 func (d *DHT) announceAndConnect(l log.StandardLogger, ctx context.Context, host host.Host, rv string) error {
 	l.Debugf("Announcing ourselves with addresses: %v", host.Addrs())
 	routingDiscovery := discovery.NewRoutingDiscovery(d.IpfsDHT)
-	routingDiscovery.Advertise(ctx, rv)
+	_, err := routingDiscovery.Advertise(ctx, rv)
+	if err != nil {
+		l.Debugf("Failed to announce ourselves: %s", err)
+	}
 
 	// Now, look for others who have announced
 	// This is like your friend telling you the location to meet you.
@@ -211,7 +296,10 @@ func (d *DHT) announceAndConnect(l log.StandardLogger, ctx context.Context, host
 			continue
 		}
 
-		TagPeerAsFound(host, p.ID)
+		if TagPeerAsFound(host, p.ID) {
+			d.Events.Publish(events.Event{Type: events.PEER_FOUND, PeerID: p.ID.String(), Detail: "dht"})
+		}
+		dhtPeersFound.Inc()
 
 		if host.Network().Connectedness(p.ID) != network.Connected {
 			l.Debugf("Found peer %s with %d addresses", p.ID, len(p.Addrs))
@@ -230,6 +318,27 @@ func (d *DHT) announceAndConnect(l log.StandardLogger, ctx context.Context, host
 	return nil
 }
 
-func TagPeerAsFound(myself host.Host, peerIDFound peer.ID) {
+// TagPeerAsFound marks the peer as a network member, returning true if it wasn't yet
+func TagPeerAsFound(myself host.Host, peerIDFound peer.ID) bool {
+	found := false
+	if tags := myself.ConnManager().GetTagInfo(peerIDFound); tags != nil {
+		_, found = tags.Tags[DHT_FOUND]
+	}
 	myself.ConnManager().UpsertTag(peerIDFound, DHT_FOUND, func(i int) int { return 0 })
+	return !found
+}
+
+// TagPeerAsHint marks the peer as found by an unauthenticated discovery, an
+// address hint, not a network member
+func TagPeerAsHint(myself host.Host, peerIDFound peer.ID) {
+	myself.ConnManager().UpsertTag(peerIDFound, DISCOVERY_HINT, func(i int) int { return 0 })
+}
+
+// IsPeerHinted tells if the peer was found by an unauthenticated discovery
+func IsPeerHinted(myself host.Host, peerID peer.ID) bool {
+	if tags := myself.ConnManager().GetTagInfo(peerID); tags != nil {
+		_, found := tags.Tags[DISCOVERY_HINT]
+		return found
+	}
+	return false
 }
@@ -128,3 +128,22 @@ func (s *DHTTestSuite) TestDHT600Seconds() {
 
 	s.Equal(h.Network().Connectedness(h2.ID()), network.Connected)
 }
+
+func (s *DHTTestSuite) TestDHTProtocolPrefix() {
+	d1 := discovery.NewDHT()
+	d1.OTPKey = crypto.OTPKey{Key: "sharedKey"}
+	d2 := discovery.NewDHT()
+	d2.OTPKey = crypto.OTPKey{Key: "otherKey"}
+
+	s.NotEqual(d1.ProtocolPrefix(), d2.ProtocolPrefix())
+	s.NotContains(string(d1.ProtocolPrefix()), "sharedKey")
+}
+
+func (s *DHTTestSuite) TestDHTRendezvousWindows() {
+	d := discovery.NewDHT()
+	d.OTPKey = crypto.OTPKey{Key: "sharedKey", KeyLength: 16, Interval: 60}
+
+	rvs := d.GetRendezvousWindows()
+	s.Len(rvs, 1+2*crypto.OTP_SKEW_WINDOWS)
+	s.Equal(d.GetNextRendezvous(), rvs[0])
+}
@@ -0,0 +1,104 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gfleury/solo/client/crypto"
+
+	"github.com/ipfs/go-log"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+)
+
+// MDNS discovers peers of the same network on the local link, it doesn't
+// need any bootstrap peer so it also works on networks without internet
+type MDNS struct {
+	OTPKeyReceiver chan crypto.OTPKey
+	OTPKey         crypto.OTPKey
+
+	service mdns.Service
+	host    host.Host
+	logger  log.StandardLogger
+	ctx     context.Context
+}
+
+func NewMDNS() *MDNS {
+	return &MDNS{OTPKeyReceiver: make(chan crypto.OTPKey, 1)}
+}
+
+func (d *MDNS) Option(ctx context.Context) func(c *libp2p.Config) error {
+	return func(*libp2p.Config) error { return nil }
+}
+
+// SetDiscoveryKey hands the network discovery key over to Run, without
+// waiting for it, only the first key is used
+func (d *MDNS) SetDiscoveryKey(key crypto.OTPKey) {
+	select {
+	case d.OTPKeyReceiver <- key:
+	default:
+	}
+}
+
+// ServiceName is the mDNS service announced, it is derived from the discovery
+// key so only members of the same network find each other
+func (d *MDNS) ServiceName() string {
+	return fmt.Sprintf("_solo-%s._udp", crypto.MD5(d.OTPKey.Key)[:16])
+}
+
+func (d *MDNS) Run(c log.StandardLogger, ctx context.Context, host host.Host) error {
+	d.host = host
+	d.logger = c
+	d.ctx = ctx
+
+	go func() {
+		// Wait to receive the OTPKey from ConfigurationDiscovery
+		select {
+		case d.OTPKey = <-d.OTPKeyReceiver:
+		case <-ctx.Done():
+			return
+		}
+
+		d.service = mdns.NewMdnsService(host, d.ServiceName(), d)
+
+		c.Infof("Starting mDNS discovery on %s", d.ServiceName())
+		if err := d.service.Start(); err != nil {
+			c.Errorf("Failed to start mDNS discovery: %s", err)
+			return
+		}
+
+		<-ctx.Done()
+		d.service.Close()
+	}()
+
+	return nil
+}
+
+// HandlePeerFound is called by the mDNS service for every peer announcing on
+// our service name. The name is sent in clear on the link, so the peer is
+// only an address hint until it proves it is a member
+func (d *MDNS) HandlePeerFound(p peer.AddrInfo) {
+	// Don't dial ourselves or peers without address
+	if p.ID == d.host.ID() || len(p.Addrs) == 0 {
+		return
+	}
+
+	TagPeerAsHint(d.host, p.ID)
+
+	if d.host.Network().Connectedness(p.ID) == network.Connected {
+		return
+	}
+
+	d.logger.Debugf("Found local peer %s with %d addresses", p.ID, len(p.Addrs))
+	timeoutCtx, cancelFunc := context.WithTimeout(d.ctx, 5*time.Second)
+	defer cancelFunc()
+	if err := d.host.Connect(timeoutCtx, p); err != nil {
+		d.logger.Debugf("Failed connecting to local peer %s with addresses: %s", p.ID, p.Addrs)
+	} else {
+		d.logger.Debugf("Connected to local peer %s with %d addresses", p.ID, len(p.Addrs))
+	}
+}
@@ -0,0 +1,83 @@
+package discovery_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gfleury/solo/client/crypto"
+	"github.com/gfleury/solo/client/discovery"
+	"github.com/gfleury/solo/client/logger"
+
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/stretchr/testify/suite"
+)
+
+type MDNSTestSuite struct {
+	suite.Suite
+}
+
+func TestMDNSTestSuite(t *testing.T) {
+	suite.Run(t, new(MDNSTestSuite))
+}
+
+func (s *MDNSTestSuite) TestMDNSServiceName() {
+	m1 := discovery.NewMDNS()
+	m1.OTPKey = crypto.OTPKey{Key: "sharedKey"}
+	m2 := discovery.NewMDNS()
+	m2.OTPKey = crypto.OTPKey{Key: "sharedKey"}
+	m3 := discovery.NewMDNS()
+	m3.OTPKey = crypto.OTPKey{Key: "otherKey"}
+
+	s.Equal(m1.ServiceName(), m2.ServiceName())
+	s.NotEqual(m1.ServiceName(), m3.ServiceName())
+	s.NotContains(m1.ServiceName(), "sharedKey")
+}
+
+func (s *MDNSTestSuite) TestMDNSSetDiscoveryKeyBeforeRun() {
+	m := discovery.NewMDNS()
+
+	// Doesn't wait for Run to receive the key
+	m.SetDiscoveryKey(crypto.OTPKey{Key: "sharedKey"})
+	m.SetDiscoveryKey(crypto.OTPKey{Key: "otherKey"})
+	s.Equal("sharedKey", (<-m.OTPKeyReceiver).Key)
+}
+
+func (s *MDNSTestSuite) TestMDNS() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	key := crypto.OTPKey{
+		Key:       "sharedKey",
+		KeyLength: 16,
+		Interval:  20,
+	}
+
+	h, _ := libp2p.New(libp2p.ListenAddrStrings("/ip4/0.0.0.0/tcp/0"))
+	h2, _ := libp2p.New(libp2p.ListenAddrStrings("/ip4/0.0.0.0/tcp/0"))
+
+	log := logger.New(log.LevelDebug)
+
+	m := discovery.NewMDNS()
+	m2 := discovery.NewMDNS()
+
+	m.Run(log, ctx, h)
+	m.SetDiscoveryKey(key)
+
+	m2.Run(log, ctx, h2)
+	m2.SetDiscoveryKey(key)
+
+	startTime := time.Now()
+
+	for h.Network().Connectedness(h2.ID()) != network.Connected && time.Since(startTime) < 20*time.Second {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	s.Equal(network.Connected, h.Network().Connectedness(h2.ID()))
+
+	// mDNS peers are only hints until they prove they are members
+	s.Eventually(func() bool { return discovery.IsPeerHinted(h, h2.ID()) }, 10*time.Second, 100*time.Millisecond)
+	s.NotContains(h.ConnManager().GetTagInfo(h2.ID()).Tags, discovery.DHT_FOUND)
+}
@@ -0,0 +1,40 @@
+package discovery
+
+import (
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	dhtRoutingTableSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "solo_dht_routing_table_size",
+		Help: "Number of peers in the DHT routing table",
+	})
+	dhtPeersFound = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "solo_dht_peers_found_total",
+		Help: "Network members found through the DHT",
+	})
+	dhtAnnounceErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "solo_dht_announce_errors_total",
+		Help: "Failed DHT announce and lookup rounds",
+	})
+	dhtLastAnnounce = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "solo_dht_last_announce_timestamp_seconds",
+		Help: "Unix time of the last successful DHT announce",
+	})
+)
+
+// observeDHT updates the DHT health metrics after an announce round
+func observeDHT(kad *dht.IpfsDHT, err error) {
+	if kad != nil {
+		dhtRoutingTableSize.Set(float64(kad.RoutingTable().Size()))
+	}
+	if err != nil {
+		dhtAnnounceErrors.Inc()
+		return
+	}
+	dhtLastAnnounce.Set(float64(time.Now().Unix()))
+}
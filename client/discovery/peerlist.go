@@ -0,0 +1,186 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gfleury/solo/client/events"
+	"github.com/gfleury/solo/client/utils"
+	"github.com/gfleury/solo/common"
+
+	"github.com/ipfs/go-log"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// PeerListFetcher returns the current list of network peers of a discovery backend
+type PeerListFetcher func(ctx context.Context, host host.Host) ([]peer.AddrInfo, error)
+
+// PeerList periodically fetches the network peers from a backend and
+// connects to them, used for the DNS, HTTP directory and core-api discovery
+type PeerList struct {
+	Name              string
+	DiscoveryInterval time.Duration
+	Fetch             PeerListFetcher
+	// Trusted backends authenticate their peers, they are tagged as members,
+	// the others are only address hints until they prove they are members
+	Trusted bool
+	// Events receives the members found
+	Events *events.Bus
+}
+
+func (d *PeerList) Option(ctx context.Context) func(c *libp2p.Config) error {
+	return func(*libp2p.Config) error { return nil }
+}
+
+// SetEventBus sets the bus the members found are published to
+func (d *PeerList) SetEventBus(bus *events.Bus) {
+	d.Events = bus
+}
+
+func (d *PeerList) Run(c log.StandardLogger, ctx context.Context, host host.Host) error {
+	go func() {
+		d.connect(c, ctx, host)
+
+		t := utils.NewBackoffTicker(utils.BackoffMaxInterval(d.DiscoveryInterval))
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				d.connect(c, ctx, host)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (d *PeerList) connect(c log.StandardLogger, ctx context.Context, host host.Host) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, 30*time.Second)
+	defer cancelFunc()
+
+	infos, err := d.Fetch(timeoutCtx, host)
+	if err != nil {
+		c.Debugf("[%s discovery] Failed to fetch peers: %s", d.Name, err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, info := range infos {
+		if info.ID == host.ID() || len(info.Addrs) == 0 {
+			continue
+		}
+
+		if !d.Trusted {
+			TagPeerAsHint(host, info.ID)
+		} else if TagPeerAsFound(host, info.ID) {
+			d.Events.Publish(events.Event{Type: events.PEER_FOUND, PeerID: info.ID.String(), Detail: d.Name})
+		}
+
+		if host.Network().Connectedness(info.ID) == network.Connected {
+			continue
+		}
+
+		wg.Add(1)
+		go func(info peer.AddrInfo) {
+			defer wg.Done()
+			if err := host.Connect(timeoutCtx, info); err != nil {
+				c.Debugf("[%s discovery] Failed connecting to %s: %s", d.Name, info.ID, err)
+			} else {
+				c.Debugf("[%s discovery] Connected to %s", d.Name, info.ID)
+			}
+		}(info)
+	}
+	wg.Wait()
+}
+
+// NewDNS discovers peers from the TXT records of domain, each record holds a
+// p2p multiaddr, optionally prefixed by "dnsaddr=" like the libp2p dnsaddr records
+func NewDNS(domain string) *PeerList {
+	return NewDNSWithResolver(domain, net.DefaultResolver)
+}
+
+// NewDNSWithResolver is NewDNS looking the records up with resolver
+func NewDNSWithResolver(domain string, resolver *net.Resolver) *PeerList {
+	return &PeerList{
+		Name: "dns",
+		Fetch: func(ctx context.Context, _ host.Host) ([]peer.AddrInfo, error) {
+			records, err := resolver.LookupTXT(ctx, domain)
+			if err != nil {
+				return nil, err
+			}
+			return parseP2PAddrs(records), nil
+		},
+	}
+}
+
+func parseP2PAddrs(records []string) []peer.AddrInfo {
+	addrs := []multiaddr.Multiaddr{}
+	for _, record := range records {
+		maddr, err := multiaddr.NewMultiaddr(strings.TrimPrefix(record, "dnsaddr="))
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, maddr)
+	}
+
+	infos, err := peer.AddrInfosFromP2pAddrs(addrs...)
+	if err != nil {
+		return []peer.AddrInfo{}
+	}
+	return infos
+}
+
+// NewHTTPDirectory discovers peers from a JSON list of {"ID": ..., "Addrs": [...]} served at url
+func NewHTTPDirectory(url string) *PeerList {
+	return &PeerList{
+		Name: "http",
+		Fetch: func(ctx context.Context, _ host.Host) ([]peer.AddrInfo, error) {
+			req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+			if err != nil {
+				return nil, err
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode > 399 {
+				return nil, fmt.Errorf("HTTP Error: %s", resp.Status)
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return nil, err
+			}
+
+			infos := []peer.AddrInfo{}
+			err = json.Unmarshal(body, &infos)
+			return infos, err
+		},
+	}
+}
+
+// NewCoreAPI discovers the activated nodes of our network from core-api, the
+// stream to serverID authenticates it
+func NewCoreAPI(serverID peer.ID) *PeerList {
+	return &PeerList{
+		Name:    "core-api",
+		Trusted: true,
+		Fetch: func(_ context.Context, host host.Host) ([]peer.AddrInfo, error) {
+			return common.GetSoloAPIP2PClient(serverID, host).GetNetworkPeers()
+		},
+	}
+}
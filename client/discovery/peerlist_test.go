@@ -0,0 +1,171 @@
+package discovery_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gfleury/solo/client/discovery"
+	"github.com/gfleury/solo/client/logger"
+	"github.com/gfleury/solo/common"
+
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p"
+	gostream "github.com/libp2p/go-libp2p-gostream"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/suite"
+)
+
+type PeerListTestSuite struct {
+	suite.Suite
+}
+
+func TestPeerListTestSuite(t *testing.T) {
+	suite.Run(t, new(PeerListTestSuite))
+}
+
+func (s *PeerListTestSuite) TestHTTPDirectory() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h, _ := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	h2, _ := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]peer.AddrInfo{
+			{ID: h.ID(), Addrs: h.Addrs()},
+			{ID: h2.ID(), Addrs: h2.Addrs()},
+		})
+	}))
+	defer server.Close()
+
+	d := discovery.NewHTTPDirectory(server.URL)
+	d.DiscoveryInterval = time.Second
+
+	infos, err := d.Fetch(ctx, h)
+	s.NoError(err)
+	s.Len(infos, 2)
+
+	d.Run(logger.New(log.LevelDebug), ctx, h)
+
+	s.waitConnected(h, h2)
+	// Anyone can serve a directory, its peers are only hints
+	s.True(discovery.IsPeerHinted(h, h2.ID()))
+	s.False(isPeerFound(h, h2.ID()))
+}
+
+func (s *PeerListTestSuite) TestDNS() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h, _ := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	h2, _ := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+
+	// A DNS server answering the TXT records of peers.solo.test
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	server := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.Question[0].Qtype == dns.TypeTXT && r.Question[0].Name == "peers.solo.test." {
+			m.Answer = append(m.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+				Txt: []string{"dnsaddr=" + h2.Addrs()[0].String() + "/p2p/" + h2.ID().String()},
+			}, &dns.TXT{
+				Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+				Txt: []string{"v=spf1 -all"},
+			})
+		}
+		w.WriteMsg(m)
+	})}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", conn.LocalAddr().String())
+		},
+	}
+
+	d := discovery.NewDNSWithResolver("peers.solo.test", resolver)
+	d.DiscoveryInterval = time.Second
+
+	// Records which are not p2p multiaddrs are skipped
+	infos, err := d.Fetch(ctx, h)
+	s.NoError(err)
+	s.Len(infos, 1)
+	s.Equal(h2.ID(), infos[0].ID)
+
+	d.Run(logger.New(log.LevelDebug), ctx, h)
+
+	s.waitConnected(h, h2)
+	// Anyone able to spoof or poison DNS can list peers, they are only hints
+	s.True(discovery.IsPeerHinted(h, h2.ID()))
+	s.False(isPeerFound(h, h2.ID()))
+}
+
+func (s *PeerListTestSuite) TestCoreAPI() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h, _ := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	h2, _ := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	api, _ := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+
+	// core-api serves the network peers over a libp2p stream
+	listener, err := gostream.Listen(api, common.SoloAPIP2PProtocol)
+	s.Require().NoError(err)
+	defer listener.Close()
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/node/peers" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(common.NetworkPeersResponse{Peers: []peer.AddrInfo{
+			{ID: h.ID(), Addrs: h.Addrs()},
+			{ID: h2.ID(), Addrs: h2.Addrs()},
+		}})
+	}))
+	h.Peerstore().AddAddrs(api.ID(), api.Addrs(), time.Hour)
+
+	d := discovery.NewCoreAPI(api.ID())
+	d.DiscoveryInterval = time.Second
+
+	infos, err := d.Fetch(ctx, h)
+	s.NoError(err)
+	s.Len(infos, 2)
+
+	d.Run(logger.New(log.LevelDebug), ctx, h)
+
+	s.waitConnected(h, h2)
+	// core-api authenticated the stream and its peers, they are members
+	s.True(isPeerFound(h, h2.ID()))
+	s.False(discovery.IsPeerHinted(h, h2.ID()))
+}
+
+func (s *PeerListTestSuite) waitConnected(h, h2 host.Host) {
+	startTime := time.Now()
+	for h.Network().Connectedness(h2.ID()) != network.Connected && time.Since(startTime) < 10*time.Second {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	s.Equal(network.Connected, h.Network().Connectedness(h2.ID()))
+}
+
+func isPeerFound(h host.Host, peerID peer.ID) bool {
+	tags := h.ConnManager().GetTagInfo(peerID)
+	if tags == nil {
+		return false
+	}
+	_, found := tags.Tags[discovery.DHT_FOUND]
+	return found
+}
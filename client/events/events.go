@@ -0,0 +1,180 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Type of the peer lifecycle events
+type Type string
+
+const (
+	// PEER_FOUND a network member was found by discovery
+	PEER_FOUND Type = "peer_found"
+	// PEER_JOINED the first connection to a network member was opened
+	PEER_JOINED Type = "peer_joined"
+	// PEER_LEFT the last connection to a network member was closed
+	PEER_LEFT Type = "peer_left"
+	// PEER_MISSING a peer stopped announcing its IP
+	PEER_MISSING Type = "peer_missing"
+	// ROUTE_LEARNED the peer of an IP was learned or changed
+	ROUTE_LEARNED Type = "route_learned"
+	// STREAM_OPENED a VPN data stream was opened with a peer
+	STREAM_OPENED Type = "stream_opened"
+	// HANDSHAKE_FAILED the noise handshake of a VPN data stream failed
+	HANDSHAKE_FAILED Type = "handshake_failed"
+	// ROUTE_REMOVED the peer of an IP expired or was evicted
+	ROUTE_REMOVED Type = "route_removed"
+	// INTERFACE_UP the VPN interface is ready, IP is its address
+	INTERFACE_UP Type = "interface_up"
+	// INTERFACE_DOWN the VPN service is stopping
+	INTERFACE_DOWN Type = "interface_down"
+	// CONFIGURATION_CHANGED the network configuration was received
+	CONFIGURATION_CHANGED Type = "configuration_changed"
+)
+
+// Types are all the event types
+var Types = []Type{
+	PEER_FOUND, PEER_JOINED, PEER_LEFT, PEER_MISSING,
+	ROUTE_LEARNED, ROUTE_REMOVED, STREAM_OPENED, HANDSHAKE_FAILED,
+	INTERFACE_UP, INTERFACE_DOWN, CONFIGURATION_CHANGED,
+}
+
+const (
+	// Events buffered for each subscriber, slow subscribers lose the next ones
+	EVENTS_BUFFER = 256
+	// Last events kept for the subscribers that just arrived
+	EVENTS_HISTORY = 128
+)
+
+type Event struct {
+	Type   Type
+	Time   time.Time
+	PeerID string `json:",omitempty"`
+	IP     string `json:",omitempty"`
+	Detail string `json:",omitempty"`
+}
+
+// Bus delivers the events published by the node subsystems to its
+// subscribers, publishing never blocks. A nil Bus drops every event
+type Bus struct {
+	sync.Mutex
+
+	subscribers map[*Subscription]bool
+	history     []Event
+}
+
+func NewBus() *Bus {
+	return &Bus{subscribers: map[*Subscription]bool{}}
+}
+
+// Subscription receives the events of its types, or all of them if none, on C
+type Subscription struct {
+	C <-chan Event
+
+	c chan Event
+	// fn is called instead for the subscriptions of SubscribeFunc
+	fn    func(Event)
+	types typeFilter
+	bus   *Bus
+}
+
+// typeFilter matches the events of its types, or all of them if empty
+type typeFilter map[Type]bool
+
+func newTypeFilter(types []Type) typeFilter {
+	f := typeFilter{}
+	for _, t := range types {
+		f[t] = true
+	}
+	return f
+}
+
+func (f typeFilter) matches(e Event) bool {
+	return len(f) == 0 || f[e.Type]
+}
+
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	eventsTotal.WithLabelValues(string(e.Type)).Inc()
+
+	b.Lock()
+	b.history = append(b.history, e)
+	if len(b.history) > EVENTS_HISTORY {
+		b.history = b.history[len(b.history)-EVENTS_HISTORY:]
+	}
+
+	// Channels are fed under the lock, Close may close them, the callbacks
+	// run after it so they can publish or subscribe in turn
+	fns := []func(Event){}
+	for s := range b.subscribers {
+		if !s.types.matches(e) {
+			continue
+		}
+		if s.fn != nil {
+			fns = append(fns, s.fn)
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+		}
+	}
+	b.Unlock()
+
+	for _, fn := range fns {
+		fn(e)
+	}
+}
+
+// Subscribe returns a subscription to the events of types, all events if none
+func (b *Bus) Subscribe(types ...Type) *Subscription {
+	s, _ := b.subscribeWithHistory(types)
+	return s
+}
+
+// SubscribeFunc calls fn with the events of types, all events if none, as
+// they are published, so none is lost. fn must not block the publisher
+func (b *Bus) SubscribeFunc(fn func(Event), types ...Type) *Subscription {
+	s := &Subscription{fn: fn, types: newTypeFilter(types), bus: b}
+
+	b.Lock()
+	defer b.Unlock()
+	b.subscribers[s] = true
+	return s
+}
+
+// subscribeWithHistory subscribes and returns the history up to the subscription
+func (b *Bus) subscribeWithHistory(types []Type) (*Subscription, []Event) {
+	c := make(chan Event, EVENTS_BUFFER)
+	s := &Subscription{C: c, c: c, types: newTypeFilter(types), bus: b}
+
+	b.Lock()
+	defer b.Unlock()
+	b.subscribers[s] = true
+	return s, append([]Event{}, b.history...)
+}
+
+// History returns the last EVENTS_HISTORY events
+func (b *Bus) History() []Event {
+	b.Lock()
+	defer b.Unlock()
+	return append([]Event{}, b.history...)
+}
+
+// Close stops the subscription and closes C
+func (s *Subscription) Close() {
+	s.bus.Lock()
+	defer s.bus.Unlock()
+	if s.bus.subscribers[s] {
+		delete(s.bus.subscribers, s)
+		if s.c != nil {
+			close(s.c)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package events_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gfleury/solo/client/events"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBus(t *testing.T) {
+	bus := events.NewBus()
+
+	all := bus.Subscribe()
+	routes := bus.Subscribe(events.ROUTE_LEARNED)
+
+	bus.Publish(events.Event{Type: events.PEER_JOINED, PeerID: "peer1"})
+	bus.Publish(events.Event{Type: events.ROUTE_LEARNED, PeerID: "peer1", IP: "10.2.3.1"})
+
+	e := <-all.C
+	require.Equal(t, events.PEER_JOINED, e.Type)
+	require.False(t, e.Time.IsZero())
+	require.Equal(t, events.ROUTE_LEARNED, (<-all.C).Type)
+	require.Equal(t, "10.2.3.1", (<-routes.C).IP)
+	require.Empty(t, routes.C)
+
+	// Closed subscriptions don't receive anything else
+	routes.Close()
+	bus.Publish(events.Event{Type: events.ROUTE_LEARNED})
+	_, ok := <-routes.C
+	require.False(t, ok)
+
+	// Slow subscribers lose events instead of blocking the publishers
+	for i := 0; i < events.EVENTS_BUFFER+10; i++ {
+		bus.Publish(events.Event{Type: events.PEER_LEFT})
+	}
+	require.Len(t, all.C, events.EVENTS_BUFFER)
+	require.Len(t, bus.History(), events.EVENTS_HISTORY)
+
+	// Function subscribers receive every event, as it is published
+	received := []events.Event{}
+	fn := bus.SubscribeFunc(func(e events.Event) { received = append(received, e) }, events.PEER_JOINED)
+	for i := 0; i < events.EVENTS_BUFFER+10; i++ {
+		bus.Publish(events.Event{Type: events.PEER_JOINED})
+	}
+	bus.Publish(events.Event{Type: events.PEER_LEFT})
+	require.Len(t, received, events.EVENTS_BUFFER+10)
+	fn.Close()
+	bus.Publish(events.Event{Type: events.PEER_JOINED})
+	require.Len(t, received, events.EVENTS_BUFFER+10)
+
+	// Function subscribers may publish and close their subscription
+	var reentrant *events.Subscription
+	reentrant = bus.SubscribeFunc(func(e events.Event) {
+		bus.Publish(events.Event{Type: events.PEER_LEFT, PeerID: e.PeerID})
+		reentrant.Close()
+	}, events.PEER_MISSING)
+	left := bus.Subscribe(events.PEER_LEFT)
+	bus.Publish(events.Event{Type: events.PEER_MISSING, PeerID: "peer2"})
+	require.Equal(t, "peer2", (<-left.C).PeerID)
+	bus.Publish(events.Event{Type: events.PEER_MISSING, PeerID: "peer3"})
+	require.Empty(t, left.C)
+
+	// A nil bus drops the events
+	var nilBus *events.Bus
+	nilBus.Publish(events.Event{Type: events.PEER_LEFT})
+}
+
+// eventsCount returns the solo_events_total counter of type
+func eventsCount(t *testing.T, eventType events.Type) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != "solo_events_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "type" && label.GetValue() == string(eventType) {
+					return metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func TestBusMetrics(t *testing.T) {
+	bus := events.NewBus()
+	before := eventsCount(t, events.CONFIGURATION_CHANGED)
+
+	// Counted even without subscribers, or with slow ones
+	for i := 0; i < events.EVENTS_BUFFER+10; i++ {
+		bus.Publish(events.Event{Type: events.CONFIGURATION_CHANGED})
+	}
+	require.Equal(t, before+events.EVENTS_BUFFER+10, eventsCount(t, events.CONFIGURATION_CHANGED))
+}
+
+func TestBusHTTP(t *testing.T) {
+	bus := events.NewBus()
+	bus.Publish(events.Event{Type: events.PEER_JOINED, PeerID: "peer1"})
+	bus.Publish(events.Event{Type: events.PEER_LEFT, PeerID: "peer1"})
+
+	server := httptest.NewServer(bus)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?type=peer_left")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	received := []events.Event{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		e := events.Event{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+		received = append(received, e)
+	}
+	require.Len(t, received, 1)
+	require.Equal(t, events.PEER_LEFT, received[0].Type)
+
+	// Following receives the history and the new events
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"?follow=true", nil)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	scanner = bufio.NewScanner(resp.Body)
+	require.True(t, scanner.Scan())
+	require.True(t, scanner.Scan())
+
+	bus.Publish(events.Event{Type: events.STREAM_OPENED, PeerID: "peer2"})
+	require.True(t, scanner.Scan())
+	e := events.Event{}
+	require.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+	require.Equal(t, events.STREAM_OPENED, e.Type)
+}
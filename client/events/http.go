@@ -0,0 +1,54 @@
+package events
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ServeHTTP writes the recent events as JSON lines, with follow=true it keeps
+// streaming the new ones until the client goes away. The type parameter,
+// repeatable, filters the events
+func (b *Bus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	types := []Type{}
+	for _, t := range r.URL.Query()["type"] {
+		types = append(types, Type(t))
+	}
+	follow := r.URL.Query().Get("follow") == "true"
+
+	var s *Subscription
+	history := b.History()
+	if follow {
+		s, history = b.subscribeWithHistory(types)
+		defer s.Close()
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+	filter := newTypeFilter(types)
+	for _, e := range history {
+		if filter.matches(e) {
+			encoder.Encode(e)
+		}
+	}
+	if !follow {
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	for {
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case e, ok := <-s.C:
+			if !ok {
+				return
+			}
+			if err := encoder.Encode(e); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,12 @@
+package events
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// eventsTotal counts the published events of each type, see Bus.Publish
+var eventsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "solo_events_total",
+	Help: "Peer lifecycle events by type",
+}, []string{"type"})
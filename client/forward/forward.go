@@ -0,0 +1,134 @@
+package forward
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// FORWARD_RESOLVE_TIMEOUT bounds the PRP lookup of a forward target
+	FORWARD_RESOLVE_TIMEOUT = 10 * time.Second
+	// FORWARD_UDP_TIMEOUT closes the UDP sessions idle for longer
+	FORWARD_UDP_TIMEOUT = 2 * time.Minute
+	// FORWARD_MAX_DATAGRAM is the largest UDP datagram forwarded
+	FORWARD_MAX_DATAGRAM = 65535
+	// FORWARD_UDP_QUEUE is the amount of datagrams waiting for a UDP session
+	// stream, the next ones are dropped
+	FORWARD_UDP_QUEUE = 64
+)
+
+// Forward is a port forwarded over the mesh, a local forward listens on
+// Bind and connects to Port on Host, a reverse one asks Host to listen on
+// Port and connects back to Bind
+type Forward struct {
+	Network string
+	Bind    string
+	Host    string
+	Port    int
+	Reverse bool
+}
+
+func (f Forward) String() string {
+	if f.Reverse {
+		return fmt.Sprintf("%s:%d -> %s/%s", f.Host, f.Port, f.Bind, f.Network)
+	}
+	return fmt.Sprintf("%s -> %s:%d/%s", f.Bind, f.Host, f.Port, f.Network)
+}
+
+// Parse parses a local forward, "[bind:]port:host:port[/udp]", the host is
+// a member hostname, overlay IP or peer ID, the bind address defaults to
+// the loopback
+func Parse(spec string) (Forward, error) {
+	f := Forward{Network: "tcp"}
+
+	spec, err := f.parseNetwork(spec)
+	if err != nil {
+		return f, err
+	}
+
+	parts := strings.Split(spec, ":")
+	switch len(parts) {
+	case 3:
+		parts = append([]string{"127.0.0.1"}, parts...)
+	case 4:
+	default:
+		return f, fmt.Errorf("invalid forward %q, use [bind:]port:host:port[/udp]", spec)
+	}
+
+	if _, err := strconv.Atoi(parts[1]); err != nil {
+		return f, fmt.Errorf("invalid forward port %q", parts[1])
+	}
+	f.Bind = net.JoinHostPort(parts[0], parts[1])
+	f.Host = parts[2]
+	f.Port, err = strconv.Atoi(parts[3])
+	if err != nil {
+		return f, fmt.Errorf("invalid forward port %q", parts[3])
+	}
+	return f, nil
+}
+
+// ParseReverse parses a reverse forward, "host:port:[address:]port[/udp]",
+// host listens on its loopback port and its connections are forwarded to
+// the local address, the loopback by default
+func ParseReverse(spec string) (Forward, error) {
+	f := Forward{Network: "tcp", Reverse: true}
+
+	spec, err := f.parseNetwork(spec)
+	if err != nil {
+		return f, err
+	}
+
+	parts := strings.Split(spec, ":")
+	switch len(parts) {
+	case 3:
+		parts = []string{parts[0], parts[1], "127.0.0.1", parts[2]}
+	case 4:
+	default:
+		return f, fmt.Errorf("invalid reverse forward %q, use host:port:[address:]port[/udp]", spec)
+	}
+
+	f.Host = parts[0]
+	f.Port, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return f, fmt.Errorf("invalid forward port %q", parts[1])
+	}
+	if _, err := strconv.Atoi(parts[3]); err != nil {
+		return f, fmt.Errorf("invalid forward port %q", parts[3])
+	}
+	f.Bind = net.JoinHostPort(parts[2], parts[3])
+	return f, nil
+}
+
+func (f *Forward) parseNetwork(spec string) (string, error) {
+	spec, network, found := strings.Cut(spec, "/")
+	if !found {
+		return spec, nil
+	}
+	switch network {
+	case "tcp", "udp":
+		f.Network = network
+		return spec, nil
+	}
+	return spec, fmt.Errorf("invalid forward network %q, use tcp or udp", network)
+}
+
+// ParseExpose parses an exposed port, "port[/udp]", accepted from the members
+func ParseExpose(spec string) (string, error) {
+	f := Forward{Network: "tcp"}
+	spec, err := f.parseNetwork(spec)
+	if err != nil {
+		return "", err
+	}
+	port, err := strconv.Atoi(spec)
+	if err != nil {
+		return "", fmt.Errorf("invalid exposed port %q", spec)
+	}
+	return exposedKey(f.Network, port), nil
+}
+
+func exposedKey(network string, port int) string {
+	return fmt.Sprintf("%d/%s", port, network)
+}
@@ -0,0 +1,247 @@
+package forward_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gfleury/solo/client/discovery"
+	"github.com/gfleury/solo/client/forward"
+	"github.com/gfleury/solo/client/logger"
+)
+
+func TestParse(t *testing.T) {
+	f, err := forward.Parse("8080:db-host:5432")
+	require.NoError(t, err)
+	require.Equal(t, forward.Forward{Network: "tcp", Bind: "127.0.0.1:8080", Host: "db-host", Port: 5432}, f)
+
+	f, err = forward.Parse("0.0.0.0:5353:10.1.0.2:53/udp")
+	require.NoError(t, err)
+	require.Equal(t, forward.Forward{Network: "udp", Bind: "0.0.0.0:5353", Host: "10.1.0.2", Port: 53}, f)
+
+	f, err = forward.ParseReverse("db-host:9000:3000")
+	require.NoError(t, err)
+	require.Equal(t, forward.Forward{Network: "tcp", Bind: "127.0.0.1:3000", Host: "db-host", Port: 9000, Reverse: true}, f)
+
+	for _, spec := range []string{"8080", "8080:db-host", "a:db-host:5432", "8080:db-host:b", "8080:db-host:5432/sctp"} {
+		_, err = forward.Parse(spec)
+		require.Error(t, err, spec)
+	}
+
+	exposed, err := forward.ParseExpose("53/udp")
+	require.NoError(t, err)
+	require.Equal(t, "53/udp", exposed)
+	_, err = forward.ParseExpose("http")
+	require.Error(t, err)
+}
+
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func newHosts(t *testing.T) (host.Host, host.Host) {
+	h1, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { h1.Close() })
+	h2, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { h2.Close() })
+
+	require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+	// Both are members of the network
+	discovery.TagPeerAsFound(h1, h2.ID())
+	discovery.TagPeerAsFound(h2, h1.ID())
+	return h1, h2
+}
+
+func runService(t *testing.T, ctx context.Context, h host.Host, s *forward.Service, target host.Host) {
+	s.Resolve = func(context.Context, string) (peer.ID, error) {
+		return target.ID(), nil
+	}
+	require.NoError(t, s.Run(ctx, logger.New(log.LevelDebug), h, nil))
+}
+
+func startEcho(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func requireEcho(t *testing.T, address string) {
+	var conn net.Conn
+	var err error
+	// Reverse forwards listen asynchronously
+	require.Eventually(t, func() bool {
+		conn, err = net.Dial("tcp", address)
+		return err == nil
+	}, 10*time.Second, 100*time.Millisecond)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	reply := make([]byte, 5)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(reply))
+}
+
+func TestForward(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1, h2 := newHosts(t)
+	echoPort := startEcho(t)
+	localPort := freePort(t)
+	deniedPort := freePort(t)
+
+	runService(t, ctx, h1, forward.NewService([]forward.Forward{
+		{Network: "tcp", Bind: fmt.Sprintf("127.0.0.1:%d", localPort), Host: "h2", Port: echoPort},
+		{Network: "tcp", Bind: fmt.Sprintf("127.0.0.1:%d", deniedPort), Host: "h2", Port: echoPort + 1},
+	}, nil, false), h2)
+	runService(t, ctx, h2, forward.NewService(nil, []string{fmt.Sprintf("%d/tcp", echoPort)}, false), h1)
+
+	requireEcho(t, fmt.Sprintf("127.0.0.1:%d", localPort))
+
+	// Ports not exposed are closed right away
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", deniedPort))
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	require.Equal(t, io.EOF, err)
+}
+
+func TestReverseForward(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1, h2 := newHosts(t)
+	echoPort := startEcho(t)
+	remotePort := freePort(t)
+
+	runService(t, ctx, h2, forward.NewService(nil, nil, true), h1)
+	runService(t, ctx, h1, forward.NewService([]forward.Forward{
+		{Network: "tcp", Bind: fmt.Sprintf("127.0.0.1:%d", echoPort), Host: "h2", Port: remotePort, Reverse: true},
+	}, nil, false), h2)
+
+	requireEcho(t, fmt.Sprintf("127.0.0.1:%d", remotePort))
+}
+
+func startUDPEcho(t *testing.T) int {
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { echo.Close() })
+	go func() {
+		b := make([]byte, 1500)
+		for {
+			n, addr, err := echo.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			echo.WriteTo(b[:n], addr)
+		}
+	}()
+	return echo.LocalAddr().(*net.UDPAddr).Port
+}
+
+func requireUDPEcho(t *testing.T, conn net.Conn, message string) {
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	reply := make([]byte, 1500)
+	n, err := conn.Read(reply)
+	require.NoError(t, err)
+	require.Equal(t, message, string(reply[:n]))
+}
+
+func TestForwardUDP(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1, h2 := newHosts(t)
+	echoPort := startUDPEcho(t)
+	localPort := freePort(t)
+
+	runService(t, ctx, h1, forward.NewService([]forward.Forward{
+		{Network: "udp", Bind: fmt.Sprintf("127.0.0.1:%d", localPort), Host: "h2", Port: echoPort},
+	}, nil, false), h2)
+	runService(t, ctx, h2, forward.NewService(nil, []string{fmt.Sprintf("%d/udp", echoPort)}, false), h1)
+
+	conn, err := net.Dial("udp", fmt.Sprintf("127.0.0.1:%d", localPort))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	requireUDPEcho(t, conn, "hello")
+}
+
+func TestForwardUDPSlowOpen(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1, h2 := newHosts(t)
+	echoPort := startUDPEcho(t)
+	localPort := freePort(t)
+	runService(t, ctx, h2, forward.NewService(nil, []string{fmt.Sprintf("%d/udp", echoPort)}, false), h1)
+
+	// The stream of the first client takes until release to open
+	release := make(chan struct{})
+	var resolves atomic.Int32
+	s := forward.NewService([]forward.Forward{
+		{Network: "udp", Bind: fmt.Sprintf("127.0.0.1:%d", localPort), Host: "h2", Port: echoPort},
+	}, nil, false)
+	s.Resolve = func(context.Context, string) (peer.ID, error) {
+		if resolves.Add(1) == 1 {
+			<-release
+		}
+		return h2.ID(), nil
+	}
+	require.NoError(t, s.Run(ctx, logger.New(log.LevelDebug), h1, nil))
+
+	slow, err := net.Dial("udp", fmt.Sprintf("127.0.0.1:%d", localPort))
+	require.NoError(t, err)
+	defer slow.Close()
+	_, err = slow.Write([]byte("first"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return resolves.Load() == 1 }, 10*time.Second, 10*time.Millisecond)
+	_, err = slow.Write([]byte("second"))
+	require.NoError(t, err)
+
+	// Other clients are served meanwhile
+	conn, err := net.Dial("udp", fmt.Sprintf("127.0.0.1:%d", localPort))
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	requireUDPEcho(t, conn, "hello")
+
+	// The datagrams were queued until the stream opened
+	close(release)
+	requireUDPEcho(t, slow, "first")
+	requireUDPEcho(t, slow, "second")
+}
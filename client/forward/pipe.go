@@ -0,0 +1,165 @@
+package forward
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+type halfCloser interface {
+	CloseWrite() error
+}
+
+// pipe copies both ways between conn and the stream, each side is half
+// closed once the other is done writing
+func pipe(conn net.Conn, stream network.Stream) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		io.Copy(stream, conn)
+		stream.CloseWrite()
+	}()
+
+	io.Copy(conn, stream)
+	if c, ok := conn.(halfCloser); ok {
+		c.CloseWrite()
+	}
+	<-done
+
+	conn.Close()
+	stream.Close()
+}
+
+// pipeDatagrams carries the datagrams of the connected UDP conn on the
+// stream until it is idle for FORWARD_UDP_TIMEOUT
+func pipeDatagrams(conn net.Conn, stream network.Stream) {
+	defer stream.Close()
+	defer conn.Close()
+
+	go func() {
+		datagram := make([]byte, FORWARD_MAX_DATAGRAM)
+		for {
+			n, err := readDatagram(stream, datagram)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			conn.Write(datagram[:n])
+		}
+	}()
+
+	datagram := make([]byte, FORWARD_MAX_DATAGRAM)
+	for {
+		conn.SetReadDeadline(time.Now().Add(FORWARD_UDP_TIMEOUT))
+		n, err := conn.Read(datagram)
+		if err != nil {
+			return
+		}
+		if err := writeDatagram(stream, datagram[:n]); err != nil {
+			return
+		}
+	}
+}
+
+// serveDatagrams forwards the datagrams received on conn, each client
+// address gets its own session, queued until serveSession opened its stream
+func (s *Service) serveDatagrams(conn net.PacketConn, open func() (network.Stream, error)) {
+	var mutex sync.Mutex
+	sessions := map[string]chan []byte{}
+
+	datagram := make([]byte, FORWARD_MAX_DATAGRAM)
+	for {
+		n, addr, err := conn.ReadFrom(datagram)
+		if err != nil {
+			mutex.Lock()
+			for key, datagrams := range sessions {
+				close(datagrams)
+				delete(sessions, key)
+			}
+			mutex.Unlock()
+			return
+		}
+
+		// Sent under the lock, so an ending session doesn't get it
+		mutex.Lock()
+		datagrams, found := sessions[addr.String()]
+		if !found {
+			datagrams = make(chan []byte, FORWARD_UDP_QUEUE)
+			sessions[addr.String()] = datagrams
+
+			go func(addr net.Addr, datagrams chan []byte) {
+				s.serveSession(conn, addr, open, datagrams)
+				mutex.Lock()
+				if sessions[addr.String()] == datagrams {
+					delete(sessions, addr.String())
+				}
+				mutex.Unlock()
+			}(addr, datagrams)
+		}
+		select {
+		case datagrams <- append([]byte{}, datagram[:n]...):
+		default:
+		}
+		mutex.Unlock()
+	}
+}
+
+// serveSession opens the stream of a client address and is its only
+// writer, it writes the client datagrams, sends the replies back and closes
+// the stream once idle for FORWARD_UDP_TIMEOUT or once datagrams is closed
+func (s *Service) serveSession(conn net.PacketConn, addr net.Addr, open func() (network.Stream, error), datagrams <-chan []byte) {
+	stream, err := open()
+	if err != nil {
+		s.logger.Errorf("Forward of %s failed: %s", conn.LocalAddr(), err)
+		return
+	}
+
+	replied := make(chan struct{}, 1)
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		reply := make([]byte, FORWARD_MAX_DATAGRAM)
+		for {
+			n, err := readDatagram(stream, reply)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(reply[:n], addr)
+			select {
+			case replied <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	idle := time.NewTimer(FORWARD_UDP_TIMEOUT)
+	defer idle.Stop()
+	for {
+		select {
+		case datagram, ok := <-datagrams:
+			if !ok {
+				stream.Reset()
+				return
+			}
+			if err := writeDatagram(stream, datagram); err != nil {
+				stream.Reset()
+				return
+			}
+		case <-replied:
+		case <-readDone:
+			stream.Close()
+			return
+		case <-idle.C:
+			stream.Close()
+			return
+		}
+
+		if !idle.Stop() {
+			<-idle.C
+		}
+		idle.Reset(FORWARD_UDP_TIMEOUT)
+	}
+}
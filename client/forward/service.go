@@ -0,0 +1,341 @@
+package forward
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-log"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/gfleury/solo/client/broadcast"
+	"github.com/gfleury/solo/client/protocol"
+)
+
+const (
+	// FORWARD_RETRY_INTERVAL is the wait before a lost reverse forward is asked again
+	FORWARD_RETRY_INTERVAL = 10 * time.Second
+)
+
+// Service pipes connections between the nodes over the FORWARD protocol,
+// without IP routing, it runs our local and reverse forwards and serves
+// the exposed ports and reverse forwards to the network members
+type Service struct {
+	sync.Mutex
+
+	Forwards []Forward
+	// Expose are the "port/network" loopback ports the members can connect to
+	Expose []string
+	// AllowReverse lets the members listen on our loopback ports
+	AllowReverse bool
+	// Resolve returns the peer of a forward host, from the PRP table by default
+	Resolve func(ctx context.Context, host string) (peer.ID, error)
+
+	logger    log.StandardLogger
+	host      host.Host
+	broadcast broadcast.Broadcaster
+
+	// reverse are the local addresses of our reverse forwards, by peer and port
+	reverse map[string]string
+}
+
+func NewService(forwards []Forward, expose []string, allowReverse bool) *Service {
+	return &Service{Forwards: forwards, Expose: expose, AllowReverse: allowReverse, reverse: map[string]string{}}
+}
+
+func (s *Service) Run(ctx context.Context, logger log.StandardLogger, host host.Host, b broadcast.Broadcaster) error {
+	s.logger = logger
+	s.host = host
+	s.broadcast = b
+	if s.Resolve == nil {
+		s.Resolve = s.resolve
+	}
+
+	host.SetStreamHandler(protocol.FORWARD.ID(), s.streamHandler(ctx))
+
+	for _, f := range s.Forwards {
+		if f.Reverse {
+			go s.runReverse(ctx, f)
+			continue
+		}
+		if err := s.runLocal(ctx, f); err != nil {
+			return err
+		}
+		s.logger.Infof("Forwarding %s", f)
+	}
+	return nil
+}
+
+// resolve returns the peer announcing the IP, or the hostname, of host
+func (s *Service) resolve(ctx context.Context, host string) (peer.ID, error) {
+	if peerID, err := peer.Decode(host); err == nil {
+		return peerID, nil
+	}
+
+	ip := host
+	if net.ParseIP(host) == nil {
+		found := false
+		if table := s.broadcast.Table(); table != nil {
+			ip, found = table.LookupHostname(host)
+		}
+		if !found {
+			return "", fmt.Errorf("unknown host %s", host)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, FORWARD_RESOLVE_TIMEOUT)
+	defer cancel()
+	for {
+		m, found, requestedNotLongAgo := s.broadcast.Lookup(ip)
+		if found {
+			return peer.Decode(m.PeerID)
+		}
+		if !requestedNotLongAgo {
+			s.broadcast.PRPRequest(ctx, ip)
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("no peer found for %s", ip)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// open opens a FORWARD stream with req to peerID
+func (s *Service) open(ctx context.Context, peerID peer.ID, req request) (network.Stream, error) {
+	stream, err := s.host.NewStream(ctx, peerID, protocol.FORWARD.ID())
+	if err != nil {
+		return nil, err
+	}
+	if err := req.write(stream); err != nil {
+		stream.Reset()
+		return nil, err
+	}
+	if err := readStatus(stream); err != nil {
+		stream.Reset()
+		return nil, err
+	}
+	return stream, nil
+}
+
+// runLocal listens on the forward bind address, each connection is piped
+// to the forward port of its host
+func (s *Service) runLocal(ctx context.Context, f Forward) error {
+	open := func() (network.Stream, error) {
+		peerID, err := s.Resolve(ctx, f.Host)
+		if err != nil {
+			return nil, err
+		}
+		return s.open(ctx, peerID, request{Type: FORWARD_CONNECT, Network: f.Network, Port: f.Port})
+	}
+	return s.listen(ctx, f.Network, f.Bind, open)
+}
+
+// runReverse asks the forward host to listen on the forward port for as
+// long as we run, its connections come back to the forward bind address
+func (s *Service) runReverse(ctx context.Context, f Forward) {
+	for {
+		err := s.reverseOnce(ctx, f)
+		if ctx.Err() != nil {
+			return
+		}
+		s.logger.Errorf("Reverse forward %s lost: %v", f, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(FORWARD_RETRY_INTERVAL):
+		}
+	}
+}
+
+func (s *Service) reverseOnce(ctx context.Context, f Forward) error {
+	peerID, err := s.Resolve(ctx, f.Host)
+	if err != nil {
+		return err
+	}
+
+	key := reverseKey(peerID, f.Network, f.Port)
+	s.Lock()
+	s.reverse[key] = f.Bind
+	s.Unlock()
+	defer func() {
+		s.Lock()
+		delete(s.reverse, key)
+		s.Unlock()
+	}()
+
+	stream, err := s.open(ctx, peerID, request{Type: FORWARD_LISTEN, Network: f.Network, Port: f.Port})
+	if err != nil {
+		return err
+	}
+	s.logger.Infof("Forwarding %s", f)
+
+	go func() {
+		<-ctx.Done()
+		stream.Reset()
+	}()
+	// The stream carries nothing else, it is only closed
+	_, err = io.Copy(io.Discard, stream)
+	return err
+}
+
+func reverseKey(peerID peer.ID, network string, port int) string {
+	return peerID.String() + "/" + exposedKey(network, port)
+}
+
+func (s *Service) streamHandler(ctx context.Context) func(stream network.Stream) {
+	return func(stream network.Stream) {
+		remotePeer := stream.Conn().RemotePeer()
+		if !broadcast.IsPeerFoundByDiscovery(s.host, remotePeer) {
+			s.logger.Debugf("Forward from %s denied, not a network member", remotePeer)
+			stream.Reset()
+			return
+		}
+
+		req, err := readRequest(stream)
+		if err != nil {
+			s.logger.Debugf("Invalid forward request from %s: %s", remotePeer, err)
+			stream.Reset()
+			return
+		}
+
+		switch req.Type {
+		case FORWARD_CONNECT:
+			s.handleConnect(stream, remotePeer, req)
+		case FORWARD_LISTEN:
+			s.handleListen(ctx, stream, remotePeer, req)
+		}
+	}
+}
+
+// exposed reports if the members can connect to port
+func (s *Service) exposed(network string, port int) bool {
+	for _, exposed := range s.Expose {
+		if exposed == exposedKey(network, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleConnect pipes the stream to the exposed port, or to the local
+// address of our reverse forward to the remote peer
+func (s *Service) handleConnect(stream network.Stream, remotePeer peer.ID, req request) {
+	s.Lock()
+	address, reverse := s.reverse[reverseKey(remotePeer, req.Network, req.Port)]
+	s.Unlock()
+	if !reverse {
+		if !s.exposed(req.Network, req.Port) {
+			s.logger.Debugf("Forward from %s to %s denied, not exposed", remotePeer, exposedKey(req.Network, req.Port))
+			writeStatus(stream, FORWARD_DENIED)
+			stream.Close()
+			return
+		}
+		address = net.JoinHostPort("127.0.0.1", strconv.Itoa(req.Port))
+	}
+
+	conn, err := net.Dial(req.Network, address)
+	if err != nil {
+		s.logger.Debugf("Forward from %s to %s failed: %s", remotePeer, address, err)
+		writeStatus(stream, FORWARD_FAILED)
+		stream.Close()
+		return
+	}
+	if err := writeStatus(stream, FORWARD_OK); err != nil {
+		conn.Close()
+		stream.Reset()
+		return
+	}
+
+	if req.Network == "udp" {
+		pipeDatagrams(conn, stream)
+		return
+	}
+	pipe(conn, stream)
+}
+
+// handleListen listens on the loopback port while the stream is open, its
+// connections are forwarded back to the remote peer
+func (s *Service) handleListen(ctx context.Context, stream network.Stream, remotePeer peer.ID, req request) {
+	if !s.AllowReverse {
+		s.logger.Debugf("Reverse forward from %s denied", remotePeer)
+		writeStatus(stream, FORWARD_DENIED)
+		stream.Close()
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	open := func() (network.Stream, error) {
+		return s.open(ctx, remotePeer, request{Type: FORWARD_CONNECT, Network: req.Network, Port: req.Port})
+	}
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(req.Port))
+	if err := s.listen(ctx, req.Network, address, open); err != nil {
+		s.logger.Debugf("Reverse forward from %s failed: %s", remotePeer, err)
+		writeStatus(stream, FORWARD_FAILED)
+		stream.Close()
+		return
+	}
+	if err := writeStatus(stream, FORWARD_OK); err != nil {
+		stream.Reset()
+		return
+	}
+	s.logger.Infof("Reverse forwarding %s to %s", address, remotePeer)
+
+	// Listen until the remote peer closes the stream
+	io.Copy(io.Discard, stream)
+	stream.Close()
+}
+
+// listen accepts the connections, or UDP sessions, on address until ctx is
+// done, each one is piped to a stream from open
+func (s *Service) listen(ctx context.Context, transport, address string, open func() (network.Stream, error)) error {
+	if transport == "udp" {
+		conn, err := net.ListenPacket(transport, address)
+		if err != nil {
+			return err
+		}
+		go func() {
+			<-ctx.Done()
+			conn.Close()
+		}()
+		go s.serveDatagrams(conn, open)
+		return nil
+	}
+
+	l, err := net.Listen(transport, address)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				stream, err := open()
+				if err != nil {
+					s.logger.Errorf("Forward of %s failed: %s", address, err)
+					conn.Close()
+					return
+				}
+				pipe(conn, stream)
+			}()
+		}
+	}()
+	return nil
+}
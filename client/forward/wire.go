@@ -0,0 +1,96 @@
+package forward
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Forward stream requests, the first bytes of each FORWARD stream
+const (
+	// FORWARD_CONNECT connects the stream to a loopback port of the receiver
+	FORWARD_CONNECT = 0x01
+	// FORWARD_LISTEN makes the receiver listen on a loopback port for as long
+	// as the stream is open, each connection comes back as a FORWARD_CONNECT
+	FORWARD_LISTEN = 0x02
+)
+
+// Forward stream request status
+const (
+	FORWARD_OK     = 0x00
+	FORWARD_DENIED = 0x01
+	FORWARD_FAILED = 0x02
+)
+
+// request is a forward stream request, type, network and port
+type request struct {
+	Type    byte
+	Network string
+	Port    int
+}
+
+func (r request) write(w io.Writer) error {
+	b := []byte{r.Type, 0, 0, 0}
+	if r.Network == "udp" {
+		b[1] = 1
+	}
+	binary.BigEndian.PutUint16(b[2:], uint16(r.Port))
+	_, err := w.Write(b)
+	return err
+}
+
+func readRequest(r io.Reader) (request, error) {
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return request{}, err
+	}
+
+	req := request{Type: b[0], Network: "tcp", Port: int(binary.BigEndian.Uint16(b[2:]))}
+	if b[1] == 1 {
+		req.Network = "udp"
+	}
+	if req.Type != FORWARD_CONNECT && req.Type != FORWARD_LISTEN {
+		return req, fmt.Errorf("unknown forward request %d", req.Type)
+	}
+	return req, nil
+}
+
+func writeStatus(w io.Writer, status byte) error {
+	_, err := w.Write([]byte{status})
+	return err
+}
+
+func readStatus(r io.Reader) error {
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	switch b[0] {
+	case FORWARD_OK:
+		return nil
+	case FORWARD_DENIED:
+		return fmt.Errorf("forward denied by the peer")
+	}
+	return fmt.Errorf("forward failed on the peer")
+}
+
+// UDP datagrams are carried on the streams prefixed by their length
+func writeDatagram(w io.Writer, datagram []byte) error {
+	b := make([]byte, 2+len(datagram))
+	binary.BigEndian.PutUint16(b, uint16(len(datagram)))
+	copy(b[2:], datagram)
+	_, err := w.Write(b)
+	return err
+}
+
+func readDatagram(r io.Reader, b []byte) (int, error) {
+	size := make([]byte, 2)
+	if _, err := io.ReadFull(r, size); err != nil {
+		return 0, err
+	}
+	n := int(binary.BigEndian.Uint16(size))
+	if n > len(b) {
+		return 0, fmt.Errorf("datagram of %d bytes is too large", n)
+	}
+	return io.ReadFull(r, b[:n])
+}
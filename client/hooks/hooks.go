@@ -0,0 +1,262 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-log"
+
+	"github.com/gfleury/solo/client/events"
+)
+
+// HOOK_TIMEOUT is how long a hook script or webhook can take
+const HOOK_TIMEOUT = 30 * time.Second
+
+// Hook runs Command with the shell, or posts to URL, on the events of Types,
+// all of them if empty
+type Hook struct {
+	Types   []events.Type
+	Command string
+	URL     string
+}
+
+// Parse parses a hook script "[event,...=]command"
+func Parse(spec string) (Hook, error) {
+	types, command, err := parseSpec(spec)
+	return Hook{Types: types, Command: command}, err
+}
+
+// ParseWebhook parses a webhook "[event,...=]url"
+func ParseWebhook(spec string) (Hook, error) {
+	types, url, err := parseSpec(spec)
+	if err == nil && !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		err = fmt.Errorf("invalid webhook URL %q", url)
+	}
+	return Hook{Types: types, URL: url}, err
+}
+
+// parseSpec splits the event types before the first "=", if they are valid
+// types, from the action
+func parseSpec(spec string) ([]events.Type, string, error) {
+	prefix, action, found := strings.Cut(spec, "=")
+	if !found {
+		return nil, spec, nil
+	}
+
+	types := []events.Type{}
+	for _, name := range strings.Split(prefix, ",") {
+		t, ok := parseType(strings.TrimSpace(name))
+		if !ok {
+			// The "=" belongs to the action, like an environment variable
+			return nil, spec, nil
+		}
+		types = append(types, t)
+	}
+	if action == "" {
+		return nil, "", fmt.Errorf("hook %q has nothing to run", spec)
+	}
+	return types, action, nil
+}
+
+func parseType(name string) (events.Type, bool) {
+	for _, t := range events.Types {
+		if string(t) == name {
+			return t, true
+		}
+	}
+	return "", false
+}
+
+func (h *Hook) matches(e events.Event) bool {
+	if len(h.Types) == 0 {
+		return true
+	}
+	for _, t := range h.Types {
+		if t == e.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// Runner runs the hooks of the events of a bus. Each hook runs its events
+// one at a time in the order they were published, without waiting for the
+// other hooks
+type Runner struct {
+	Hooks  []Hook
+	Logger log.StandardLogger
+	// Env is added to the environment of the hook scripts
+	Env []string
+
+	subscription *events.Subscription
+	queues       []*hookQueue
+	wg           sync.WaitGroup
+}
+
+// hookQueue holds the events waiting for a hook, without limit so no event
+// is lost to slow hooks
+type hookQueue struct {
+	sync.Mutex
+	cond *sync.Cond
+
+	hook   *Hook
+	events []events.Event
+	closed bool
+}
+
+func newHookQueue(hook *Hook) *hookQueue {
+	q := &hookQueue{hook: hook}
+	q.cond = sync.NewCond(q)
+	return q
+}
+
+func (q *hookQueue) push(e events.Event) {
+	q.Lock()
+	defer q.Unlock()
+	q.events = append(q.events, e)
+	q.cond.Signal()
+}
+
+// close lets pop return false once the queued events are over
+func (q *hookQueue) close() {
+	q.Lock()
+	defer q.Unlock()
+	q.closed = true
+	q.cond.Signal()
+}
+
+// pop waits for the next event, false once closed and empty
+func (q *hookQueue) pop() (events.Event, bool) {
+	q.Lock()
+	defer q.Unlock()
+	for len(q.events) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.events) == 0 {
+		return events.Event{}, false
+	}
+	e := q.events[0]
+	q.events = q.events[1:]
+	return e, true
+}
+
+func NewRunner(logger log.StandardLogger, hooks []Hook, env ...string) *Runner {
+	return &Runner{Hooks: hooks, Logger: logger, Env: env}
+}
+
+// Start runs the hooks of the events published to bus from now on
+func (r *Runner) Start(bus *events.Bus) {
+	if len(r.Hooks) == 0 || r.subscription != nil {
+		return
+	}
+
+	r.queues = make([]*hookQueue, len(r.Hooks))
+	for i := range r.Hooks {
+		r.queues[i] = newHookQueue(&r.Hooks[i])
+		r.wg.Add(1)
+		go func(q *hookQueue) {
+			defer r.wg.Done()
+			for e, ok := q.pop(); ok; e, ok = q.pop() {
+				r.run(q.hook, e)
+			}
+		}(r.queues[i])
+	}
+
+	// Queued as they are published, bursts aren't lost like on a channel
+	r.subscription = bus.SubscribeFunc(func(e events.Event) {
+		for _, q := range r.queues {
+			if q.hook.matches(e) {
+				q.push(e)
+			}
+		}
+	})
+}
+
+// Stop waits for the hooks of the events already published, like the
+// interface down ones, and stops running new ones
+func (r *Runner) Stop() {
+	if r.subscription == nil {
+		return
+	}
+	r.subscription.Close()
+	for _, q := range r.queues {
+		q.close()
+	}
+	r.wg.Wait()
+}
+
+// run runs the hook h for the event e
+func (r *Runner) run(h *Hook, e events.Event) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		r.Logger.Errorf("Failed to encode event %s: %s", e.Type, err)
+		return
+	}
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), HOOK_TIMEOUT)
+	defer cancelFunc()
+	if h.URL != "" {
+		err = r.post(ctx, h.URL, payload)
+	} else {
+		err = r.exec(ctx, h.Command, e, payload)
+	}
+
+	if err != nil {
+		r.Logger.Errorf("Hook for %s failed: %s", e.Type, err)
+	}
+}
+
+// exec runs the command with the event in the environment and as JSON in stdin
+func (r *Runner) exec(ctx context.Context, command string, e events.Event, payload []byte) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+
+	cmd.Env = append(os.Environ(), r.Env...)
+	cmd.Env = append(cmd.Env,
+		"SOLO_EVENT="+string(e.Type),
+		"SOLO_TIME="+e.Time.Format(time.RFC3339),
+		"SOLO_PEER_ID="+e.PeerID,
+		"SOLO_IP="+e.IP,
+		"SOLO_DETAIL="+e.Detail,
+	)
+	cmd.Stdin = bytes.NewReader(payload)
+
+	output, err := cmd.CombinedOutput()
+	if len(output) > 0 {
+		r.Logger.Debugf("Hook %q output: %s", command, output)
+	}
+	return err
+}
+
+// post sends the event as JSON to the webhook url
+func (r *Runner) post(ctx context.Context, url string, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 399 {
+		return fmt.Errorf("HTTP Error: %s", resp.Status)
+	}
+	return nil
+}
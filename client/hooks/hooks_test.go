@@ -0,0 +1,120 @@
+package hooks_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gfleury/solo/client/events"
+	"github.com/gfleury/solo/client/hooks"
+	"github.com/gfleury/solo/client/logger"
+	"github.com/ipfs/go-log/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	h, err := hooks.Parse("interface_up,peer_joined=ip route add 10.0.0.0/8 dev $SOLO_INTERFACE")
+	require.NoError(t, err)
+	require.Equal(t, []events.Type{events.INTERFACE_UP, events.PEER_JOINED}, h.Types)
+	require.Equal(t, "ip route add 10.0.0.0/8 dev $SOLO_INTERFACE", h.Command)
+
+	// Not an event list, the whole spec is the command
+	h, err = hooks.Parse("FOO=bar ./script.sh")
+	require.NoError(t, err)
+	require.Empty(t, h.Types)
+	require.Equal(t, "FOO=bar ./script.sh", h.Command)
+
+	_, err = hooks.Parse("interface_up=")
+	require.Error(t, err)
+
+	h, err = hooks.ParseWebhook("peer_left=https://example.com/hook?a=b")
+	require.NoError(t, err)
+	require.Equal(t, []events.Type{events.PEER_LEFT}, h.Types)
+	require.Equal(t, "https://example.com/hook?a=b", h.URL)
+
+	_, err = hooks.ParseWebhook("peer_left=/tmp/script")
+	require.Error(t, err)
+}
+
+func TestRunner(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+
+	received := make(chan events.Event, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		e := events.Event{}
+		json.Unmarshal(body, &e)
+		received <- e
+	}))
+	defer server.Close()
+
+	bus := events.NewBus()
+	runner := hooks.NewRunner(logger.New(log.LevelError), []hooks.Hook{
+		{Types: []events.Type{events.INTERFACE_DOWN}, Command: "echo $SOLO_EVENT $SOLO_IP $SOLO_INTERFACE >> " + out},
+		{Types: []events.Type{events.PEER_JOINED}, URL: server.URL},
+	}, "SOLO_INTERFACE=utun0")
+	runner.Start(bus)
+
+	bus.Publish(events.Event{Type: events.PEER_JOINED, PeerID: "peer1"})
+	bus.Publish(events.Event{Type: events.PEER_LEFT, PeerID: "peer1"})
+	bus.Publish(events.Event{Type: events.INTERFACE_DOWN, IP: "10.1.0.1/24"})
+
+	// Stop waits for the hooks of the published events
+	runner.Stop()
+
+	dat, err := os.ReadFile(out)
+	require.NoError(t, err)
+	require.Equal(t, "interface_down 10.1.0.1/24 utun0\n", string(dat))
+
+	require.Len(t, received, 1)
+	e := <-received
+	require.Equal(t, events.PEER_JOINED, e.Type)
+	require.Equal(t, "peer1", e.PeerID)
+}
+
+func TestRunnerSlowHook(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+
+	var count atomic.Int32
+	started, release := make(chan struct{}), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if count.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+	}))
+	defer server.Close()
+
+	bus := events.NewBus()
+	runner := hooks.NewRunner(logger.New(log.LevelError), []hooks.Hook{
+		{Types: []events.Type{events.PEER_JOINED}, URL: server.URL},
+		{Types: []events.Type{events.INTERFACE_DOWN}, Command: "echo $SOLO_EVENT >> " + out},
+	})
+	runner.Start(bus)
+
+	// The webhook is stuck on the first event while more events than the
+	// subscription buffer are published
+	bus.Publish(events.Event{Type: events.PEER_JOINED})
+	<-started
+	for i := 0; i < events.EVENTS_BUFFER+10; i++ {
+		bus.Publish(events.Event{Type: events.PEER_JOINED})
+	}
+	bus.Publish(events.Event{Type: events.INTERFACE_DOWN})
+
+	// The other hooks don't wait for it
+	require.Eventually(t, func() bool {
+		dat, _ := os.ReadFile(out)
+		return string(dat) == "interface_down\n"
+	}, 10*time.Second, 10*time.Millisecond)
+
+	// And none of its events are lost
+	close(release)
+	runner.Stop()
+	require.Equal(t, int32(events.EVENTS_BUFFER+11), count.Load())
+}
@@ -16,6 +16,16 @@ func LinkSetMTU(link Link, mtu int) error {
 	return nil
 }
 
+// LinkSetUp brings the link up
+func LinkSetUp(link Link) error {
+	return exec.Command("ifconfig", link.name, "up").Run()
+}
+
+// LinkSetMaster adds the link as a member of the bridge master
+func LinkSetMaster(link Link, master string) error {
+	return exec.Command("ifconfig", master, "addm", link.name).Run()
+}
+
 func LinkByName(name string) (Link, error) {
 	link := Link{name: name}
 	return link, nil
@@ -16,6 +16,16 @@ func LinkSetMTU(link Link, mtu int) error {
 	return nil
 }
 
+// LinkSetUp brings the link up
+func LinkSetUp(link Link) error {
+	return exec.Command("ip", "link", "set", "dev", link.name, "up").Run()
+}
+
+// LinkSetMaster attaches the link to the bridge master
+func LinkSetMaster(link Link, master string) error {
+	return exec.Command("ip", "link", "set", "dev", link.name, "master", master).Run()
+}
+
 func LinkByName(name string) (Link, error) {
 	link := Link{name: name}
 	return link, nil
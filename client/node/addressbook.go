@@ -0,0 +1,262 @@
+package node
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"gopkg.in/yaml.v2"
+
+	"github.com/gfleury/solo/client/broadcast"
+	"github.com/gfleury/solo/client/discovery"
+	"github.com/gfleury/solo/client/events"
+)
+
+const (
+	// Peers not seen for longer than this are dropped from the address book
+	ADDRESS_BOOK_MAX_AGE = 7 * 24 * time.Hour
+	// Maximum amount of peers kept in the address book
+	ADDRESS_BOOK_MAX_PEERS = 256
+	// Interval to persist the address book and redial disconnected static peers
+	KNOWN_PEERS_INTERVAL = time.Minute
+)
+
+type AddressBookEntry struct {
+	Addrs    []string
+	LastSeen time.Time
+}
+
+// AddressBook keeps the addresses of recently seen peers across restarts
+type AddressBook struct {
+	sync.Mutex
+
+	Name string
+	// Dir stores the address book, IDENTITY_STORE_DIR by default
+	Dir   string
+	Peers map[string]AddressBookEntry
+}
+
+func NewAddressBook() *AddressBook {
+	return NewAddressBookWithName("addressbook")
+}
+
+func NewAddressBookWithName(name string) *AddressBook {
+	return &AddressBook{Name: name, Dir: IDENTITY_STORE_DIR, Peers: map[string]AddressBookEntry{}}
+}
+
+func (a *AddressBook) path() string {
+	return filepath.Join(a.Dir, a.Name)
+}
+
+// Load reads the persisted address book, a missing file is an empty address book
+func (a *AddressBook) Load() error {
+	a.Lock()
+	defer a.Unlock()
+
+	dat, err := os.ReadFile(a.path())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return yaml.Unmarshal(dat, &a.Peers)
+}
+
+func (a *AddressBook) Save() error {
+	a.Lock()
+	defer a.Unlock()
+
+	dat, err := yaml.Marshal(a.Peers)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(a.Dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(a.path(), dat, 0600)
+}
+
+// Add records the peer addresses as seen now
+func (a *AddressBook) Add(info peer.AddrInfo) {
+	if len(info.Addrs) == 0 {
+		return
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	addrs := make([]string, 0, len(info.Addrs))
+	for _, addr := range info.Addrs {
+		addrs = append(addrs, addr.String())
+	}
+	a.Peers[info.ID.String()] = AddressBookEntry{Addrs: addrs, LastSeen: time.Now()}
+
+	a.evict()
+}
+
+// evict drops expired peers and the oldest ones past ADDRESS_BOOK_MAX_PEERS
+func (a *AddressBook) evict() {
+	ids := make([]string, 0, len(a.Peers))
+	for id, entry := range a.Peers {
+		if time.Since(entry.LastSeen) > ADDRESS_BOOK_MAX_AGE {
+			delete(a.Peers, id)
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	if len(ids) <= ADDRESS_BOOK_MAX_PEERS {
+		return
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return a.Peers[ids[i]].LastSeen.Before(a.Peers[ids[j]].LastSeen)
+	})
+	for _, id := range ids[:len(ids)-ADDRESS_BOOK_MAX_PEERS] {
+		delete(a.Peers, id)
+	}
+}
+
+// AddrInfos returns the valid and not expired peers of the address book
+func (a *AddressBook) AddrInfos() []peer.AddrInfo {
+	a.Lock()
+	defer a.Unlock()
+
+	infos := []peer.AddrInfo{}
+	for id, entry := range a.Peers {
+		if time.Since(entry.LastSeen) > ADDRESS_BOOK_MAX_AGE {
+			continue
+		}
+		peerID, err := peer.Decode(id)
+		if err != nil {
+			continue
+		}
+		info := peer.AddrInfo{ID: peerID}
+		for _, addr := range entry.Addrs {
+			maddr, err := multiaddr.NewMultiaddr(addr)
+			if err != nil {
+				continue
+			}
+			info.Addrs = append(info.Addrs, maddr)
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// staticPeers parses the configured static peers multiaddrs, skipping the invalid ones
+func (e *Node) staticPeers() []peer.AddrInfo {
+	valid := []multiaddr.Multiaddr{}
+	for _, addr := range e.config.StaticPeers {
+		if _, err := peer.AddrInfoFromP2pAddr(addr); err != nil {
+			e.config.Logger.Errorf("Invalid static peer %s: %s", addr, err)
+			continue
+		}
+		valid = append(valid, addr)
+	}
+	infos, _ := peer.AddrInfosFromP2pAddrs(valid...)
+	return infos
+}
+
+// connectKnownPeers dials the static and the address book peers in parallel,
+// the peers are tagged as network members only if trusted, the configured
+// static peers
+func (e *Node) connectKnownPeers(ctx context.Context, infos []peer.AddrInfo, trusted bool) {
+	var wg sync.WaitGroup
+	for _, info := range infos {
+		if info.ID == e.host.ID() || e.host.Network().Connectedness(info.ID) == network.Connected {
+			continue
+		}
+
+		wg.Add(1)
+		go func(info peer.AddrInfo) {
+			defer wg.Done()
+			timeoutCtx, cancelFunc := context.WithTimeout(ctx, 10*time.Second)
+			defer cancelFunc()
+			if err := e.host.Connect(timeoutCtx, info); err != nil {
+				e.config.Logger.Debugf("Failed connecting to known peer %s: %s", info.ID, err)
+				return
+			}
+			e.config.Logger.Debugf("Connected to known peer %s", info.ID)
+			if trusted {
+				discovery.TagPeerAsFound(e.host, info.ID)
+			}
+		}(info)
+	}
+	wg.Wait()
+}
+
+// recordKnownPeers stores the addresses of the connected network members
+func (e *Node) recordKnownPeers() {
+	for _, peerID := range e.host.Network().Peers() {
+		if !broadcast.IsPeerFoundByDiscovery(e.host, peerID) {
+			continue
+		}
+		e.config.AddressBook.Add(e.host.Peerstore().PeerInfo(peerID))
+	}
+
+	if err := e.config.AddressBook.Save(); err != nil {
+		e.config.Logger.Errorf("Failed to save address book: %s", err)
+	}
+}
+
+// maintainKnownPeers reconnects to static and recently seen peers at startup,
+// without waiting for discovery, then keeps static peers connected and the
+// address book up to date
+func (e *Node) maintainKnownPeers(ctx context.Context) {
+	staticPeers := e.staticPeers()
+	for _, info := range staticPeers {
+		e.host.ConnManager().Protect(info.ID, "static-peer")
+	}
+
+	// The address book peers may have left the network since, like the
+	// peers hinted by discovery they are members again once they announce
+	// their address
+	routes := e.events.Subscribe(events.ROUTE_LEARNED)
+	defer routes.Close()
+
+	e.connectKnownPeers(ctx, staticPeers, true)
+	if e.config.AddressBook != nil {
+		if err := e.config.AddressBook.Load(); err != nil {
+			e.config.Logger.Errorf("Failed to load address book: %s", err)
+		}
+		e.connectKnownPeers(ctx, e.config.AddressBook.AddrInfos(), false)
+	}
+
+	t := time.NewTicker(KNOWN_PEERS_INTERVAL)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			e.connectKnownPeers(ctx, staticPeers, true)
+			if e.config.AddressBook != nil {
+				e.recordKnownPeers()
+			}
+			go e.verifyPeers()
+		case event := <-routes.C:
+			e.tagAnnouncedPeer(event.PeerID)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// memberPeers returns the connected network members and the known peers,
+// used to bootstrap the private DHT
+func (e *Node) memberPeers() []peer.AddrInfo {
+	infos := append(e.Peers(), e.staticPeers()...)
+	if e.config.AddressBook != nil {
+		infos = append(infos, e.config.AddressBook.AddrInfos()...)
+	}
+	return infos
+}
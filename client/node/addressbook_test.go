@@ -0,0 +1,41 @@
+package node_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gfleury/solo/client/node"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddressBook(t *testing.T) {
+	dir := t.TempDir()
+
+	info, err := peer.AddrInfoFromString("/ip4/192.0.2.1/tcp/5544/p2p/12D3KooWGXAXwKmP4Pg3QWUnrghQaJiHLJrKScSVpTUn59hGT7Vh")
+	require.NoError(t, err)
+
+	a := node.NewAddressBook()
+	a.Dir = dir
+	require.NoError(t, a.Load())
+	require.Empty(t, a.AddrInfos())
+
+	a.Add(*info)
+	// Peers without addresses are useless to reconnect
+	a.Add(peer.AddrInfo{ID: "nothing"})
+	require.NoError(t, a.Save())
+
+	b := node.NewAddressBook()
+	b.Dir = dir
+	require.NoError(t, b.Load())
+
+	infos := b.AddrInfos()
+	require.Len(t, infos, 1)
+	require.Equal(t, info.ID, infos[0].ID)
+	require.Equal(t, []multiaddr.Multiaddr{info.Addrs[0]}, infos[0].Addrs)
+
+	// Expired entries are not returned
+	b.Peers[info.ID.String()] = node.AddressBookEntry{Addrs: []string{info.Addrs[0].String()}, LastSeen: time.Now().Add(-node.ADDRESS_BOOK_MAX_AGE - time.Hour)}
+	require.Empty(t, b.AddrInfos())
+}
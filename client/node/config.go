@@ -22,6 +22,8 @@ import (
 	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
 
@@ -29,6 +31,9 @@ import (
 	"github.com/gfleury/solo/client/broadcast/metapacket"
 	"github.com/gfleury/solo/client/crypto"
 	discovery "github.com/gfleury/solo/client/discovery"
+	"github.com/gfleury/solo/client/events"
+	"github.com/gfleury/solo/client/forward"
+	"github.com/gfleury/solo/client/hooks"
 	"github.com/gfleury/solo/common/models"
 )
 
@@ -47,18 +52,57 @@ type Config struct {
 	NetworkServices  []NetworkService
 	Logger           log.StandardLogger
 
+	InterfaceName      string
 	InterfaceAddress   string
 	InterfaceMTU       int
+	CreateInterface    bool
 	PublishLocalRoutes bool
+	// Broadcaster is the broadcast.New implementation, stream or gossipsub
+	Broadcaster string
+	// Userspace runs the VPN on a netstack instead of a TUN device, without root
+	Userspace bool
+	// TAP switches Ethernet frames on a TAP device, attached to Bridge if set
+	TAP    bool
+	Bridge string
+	// PeerACL refuses the VPN traffic of the peers it returns false for
+	PeerACL func(peer.ID) bool
 
 	AdditionalOptions, Options []libp2p.Option
 
+	// MaxConnections is the connection manager high watermark
+	MaxConnections int
+	HolePunch      bool
+	// RelayService relays connections for the network members with RelayResources
+	RelayService   bool
+	RelayResources relay.Resources
+
+	// StoreDir persists the identity and the address book
+	StoreDir string
+
 	DiscoveryPeers       discovery.AddrList
 	PublicDiscoveryPeers bool
 	StandaloneMode       bool
 
+	// StaticPeers are always kept connected
+	StaticPeers discovery.AddrList
+	// AddressBook persists recently seen peers, nil disables it
+	AddressBook *AddressBook
+
 	ConnectionConfigToken string
 	Sealer                crypto.Sealer
+
+	// AddressCertificate certifies our IP, issued by the AddressAuthority (core-api)
+	AddressCertificate []byte
+	AddressAuthority   peer.ID
+
+	// Hooks run on the node events
+	Hooks []hooks.Hook
+
+	// Forwards are the ports forwarded over the FORWARD protocol, Expose the
+	// "port/network" loopback ports the members can connect to
+	Forwards            []forward.Forward
+	Expose              []string
+	AllowReverseForward bool
 }
 
 type StreamHandler func(*Node) func(stream network.Stream)
@@ -70,10 +114,25 @@ type DiscoveryService interface {
 	Option(context.Context) func(c *libp2p.Config) error
 }
 
+// DiscoveryKeyReceiver is a DiscoveryService that waits for the network discovery key
+type DiscoveryKeyReceiver interface {
+	SetDiscoveryKey(crypto.OTPKey)
+}
+
+// EventBusReceiver is a DiscoveryService or NetworkService publishing to the node events
+type EventBusReceiver interface {
+	SetEventBus(*events.Bus)
+}
+
 type NetworkService interface {
 	Run(context.Context, log.StandardLogger, host.Host, broadcast.Broadcaster) error
 }
 
+// NetworkServiceStopper is a NetworkService with a teardown, run by Node.Stop
+type NetworkServiceStopper interface {
+	Stop() error
+}
+
 func FromBase64(enableDHT bool, bb string, d *discovery.DHT) func(cfg *Config) error {
 	if d == nil {
 		d = discovery.NewDHT()
@@ -21,8 +21,12 @@ import (
 
 	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
 	conngater "github.com/libp2p/go-libp2p/p2p/net/conngater"
 	multiaddr "github.com/multiformats/go-multiaddr"
+
+	"github.com/gfleury/solo/client/vpn"
+	"github.com/gfleury/solo/common/models"
 )
 
 const (
@@ -72,6 +76,66 @@ func (e *Node) Host() host.Host {
 	return e.host
 }
 
+// Peers returns the connected members of our network
+func (e *Node) Peers() []peer.AddrInfo {
+	infos := []peer.AddrInfo{}
+	if e.host == nil {
+		return infos
+	}
+
+	for _, peerID := range e.host.Network().Peers() {
+		if e.isNetworkMember(peerID) {
+			infos = append(infos, e.host.Peerstore().PeerInfo(peerID))
+		}
+	}
+	return infos
+}
+
+// Routes returns the known routes of the network by IP, empty until started
+func (e *Node) Routes() map[string]models.NetworkNode {
+	if e.Broadcaster == nil || e.Broadcaster.Table() == nil {
+		return map[string]models.NetworkNode{}
+	}
+	return e.Broadcaster.Table().Routes()
+}
+
+// LookupHostname returns the overlay IP of the network member named hostname
+func (e *Node) LookupHostname(hostname string) (string, bool) {
+	if e.Broadcaster == nil || e.Broadcaster.Table() == nil {
+		return "", false
+	}
+	return e.Broadcaster.Table().LookupHostname(hostname)
+}
+
+// Netstack returns the userspace stack of the VPN to dial and listen on the
+// overlay, nil unless started with WithUserspace
+func (e *Node) Netstack() *vpn.Netstack {
+	vpnService, ok := e.vpnService()
+	if !ok {
+		return nil
+	}
+	return vpnService.Netstack()
+}
+
+// ConnectionTypes returns the connection type, direct or relayed, of the VPN
+// stream to each peer
+func (e *Node) ConnectionTypes() map[peer.ID]vpn.ConnectionType {
+	vpnService, ok := e.vpnService()
+	if !ok {
+		return map[peer.ID]vpn.ConnectionType{}
+	}
+	return vpnService.ConnectionTypes()
+}
+
+// vpnService returns the VPN service, the first network service
+func (e *Node) vpnService() (*vpn.VPNService, bool) {
+	if len(e.config.NetworkServices) == 0 {
+		return nil, false
+	}
+	vpnService, ok := e.config.NetworkServices[0].(*vpn.VPNService)
+	return vpnService, ok
+}
+
 // ConnectionGater returns the underlying libp2p conngater
 func (e *Node) ConnectionGater() *conngater.BasicConnectionGater {
 	return e.cg
@@ -115,6 +179,7 @@ func (e *Node) genHost(ctx context.Context) (host.Host, error) {
 		e.config.Logger.Info("Using persistent node Identification")
 		// generate Identity privkey if its not already persisted
 		identity := NewIdentity()
+		identity.Dir = e.config.StoreDir
 
 		privateKey, err := identity.LoadOrGeneratePrivateKey(0)
 		if err != nil {
@@ -0,0 +1,69 @@
+package node
+
+import (
+	"github.com/libp2p/go-libp2p/core/network"
+
+	"github.com/gfleury/solo/client/broadcast"
+	"github.com/gfleury/solo/client/config"
+	"github.com/gfleury/solo/client/events"
+	"github.com/gfleury/solo/client/hooks"
+)
+
+// Events returns the bus the node subsystems publish the peer lifecycle events to
+func (e *Node) Events() *events.Bus {
+	return e.events
+}
+
+// peerNotifiee publishes the network members joining, on their first
+// connection, and leaving, when their last connection is closed, the other
+// peers are verified on their first connection
+func (e *Node) peerNotifiee() network.Notifiee {
+	return &network.NotifyBundle{
+		ConnectedF: func(n network.Network, c network.Conn) {
+			peerID := c.RemotePeer()
+			if len(n.ConnsToPeer(peerID)) != 1 {
+				return
+			}
+			if !broadcast.IsPeerFoundByDiscovery(e.host, peerID) {
+				// Joined once it proved it is a member
+				go e.verifyMember(peerID)
+				return
+			}
+			e.events.Publish(events.Event{Type: events.PEER_JOINED, PeerID: peerID.String(), Detail: c.RemoteMultiaddr().String()})
+		},
+		DisconnectedF: func(n network.Network, c network.Conn) {
+			peerID := c.RemotePeer()
+			if n.Connectedness(peerID) == network.Connected || !broadcast.IsPeerFoundByDiscovery(e.host, peerID) {
+				return
+			}
+			e.events.Publish(events.Event{Type: events.PEER_LEFT, PeerID: peerID.String()})
+		},
+	}
+}
+
+// parseHooks returns the configured hook scripts and webhooks, the post up
+// and down ones run on the interface up and down events
+func parseHooks(cliConfig config.Config) ([]hooks.Hook, error) {
+	nodeHooks := []hooks.Hook{}
+	for _, spec := range cliConfig.Hooks {
+		h, err := hooks.Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		nodeHooks = append(nodeHooks, h)
+	}
+	for _, spec := range cliConfig.Webhooks {
+		h, err := hooks.ParseWebhook(spec)
+		if err != nil {
+			return nil, err
+		}
+		nodeHooks = append(nodeHooks, h)
+	}
+	for _, command := range cliConfig.PostUp {
+		nodeHooks = append(nodeHooks, hooks.Hook{Types: []events.Type{events.INTERFACE_UP}, Command: command})
+	}
+	for _, command := range cliConfig.PostDown {
+		nodeHooks = append(nodeHooks, hooks.Hook{Types: []events.Type{events.INTERFACE_DOWN}, Command: command})
+	}
+	return nodeHooks, nil
+}
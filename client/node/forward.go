@@ -0,0 +1,26 @@
+package node
+
+import (
+	"github.com/gfleury/solo/client/config"
+	"github.com/gfleury/solo/client/forward"
+)
+
+// parseForwards returns the local and reverse forwards of the CLI config
+func parseForwards(cliConfig config.Config) ([]forward.Forward, error) {
+	forwards := []forward.Forward{}
+	for _, spec := range cliConfig.Forwards {
+		f, err := forward.Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		forwards = append(forwards, f)
+	}
+	for _, spec := range cliConfig.ReverseForwards {
+		f, err := forward.ParseReverse(spec)
+		if err != nil {
+			return nil, err
+		}
+		forwards = append(forwards, f)
+	}
+	return forwards, nil
+}
@@ -27,7 +27,9 @@ func init() {
 }
 
 type Identity struct {
-	Name       string
+	Name string
+	// Dir stores the identity, IDENTITY_STORE_DIR by default
+	Dir        string
 	PrivateKey crypto.PrivKey
 }
 
@@ -36,13 +38,13 @@ func NewIdentity() *Identity {
 }
 
 func NewIdentityWithName(name string) *Identity {
-	i := &Identity{Name: name}
+	i := &Identity{Name: name, Dir: IDENTITY_STORE_DIR}
 	return i
 }
 
 func (i *Identity) LoadOrGeneratePrivateKey(seed int64) (crypto.PrivKey, error) {
 	// Check if we have any privkey identity cached already
-	keyFile := filepath.Join(IDENTITY_STORE_DIR, i.Name)
+	keyFile := filepath.Join(i.Dir, i.Name)
 	dat, err := os.ReadFile(keyFile)
 	if err == nil && len(dat) > 0 {
 		i.PrivateKey, err = crypto.UnmarshalPrivateKey(dat)
@@ -60,7 +62,7 @@ func (i *Identity) LoadOrGeneratePrivateKey(seed int64) (crypto.PrivKey, error)
 			return nil, err
 		}
 
-		err = os.MkdirAll(IDENTITY_STORE_DIR, 0700)
+		err = os.MkdirAll(i.Dir, 0700)
 		if err != nil {
 			return nil, err
 		}
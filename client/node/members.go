@@ -0,0 +1,88 @@
+package node
+
+import (
+	"context"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/gfleury/solo/client/broadcast"
+	"github.com/gfleury/solo/client/discovery"
+	"github.com/gfleury/solo/client/events"
+)
+
+// MEMBER_PROOF_TIMEOUT bounds the table sync asked to a hinted peer
+const MEMBER_PROOF_TIMEOUT = 10 * time.Second
+
+// broadcaster returns the node Broadcaster, nil until it is started
+func (e *Node) broadcaster() broadcast.Broadcaster {
+	e.Lock()
+	defer e.Unlock()
+	return e.Broadcaster
+}
+
+// announced tells if the PRP table holds an address announcement signed by
+// peerID, it came over the sealed broadcast so peerID is a member
+func (e *Node) announced(peerID peer.ID) bool {
+	b := e.broadcaster()
+	if b == nil || b.Table() == nil {
+		return false
+	}
+	for _, machine := range b.Table().Routes() {
+		if machine.PeerID == peerID.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// tagAnnouncedPeer tags as a network member the connected peer whose signed
+// address announcement was learned by the PRP table
+func (e *Node) tagAnnouncedPeer(id string) {
+	peerID, err := peer.Decode(id)
+	if err != nil || peerID == e.host.ID() || e.host.Network().Connectedness(peerID) != network.Connected {
+		return
+	}
+	if !discovery.TagPeerAsFound(e.host, peerID) {
+		return
+	}
+	e.config.Logger.Debugf("Peer %s announced its address, tagged as network member", peerID)
+	e.events.Publish(events.Event{Type: events.PEER_FOUND, PeerID: peerID.String(), Detail: "announced"})
+	// It connected before it was a member
+	joined := events.Event{Type: events.PEER_JOINED, PeerID: peerID.String()}
+	if conns := e.host.Network().ConnsToPeer(peerID); len(conns) > 0 {
+		joined.Detail = conns[0].RemoteMultiaddr().String()
+	}
+	e.events.Publish(joined)
+}
+
+// verifyMember tags the connected peerID as a network member once it proved
+// it is one. Its announcement may already be in our table, or else the peers
+// hinted by discovery are asked for their table, sealed with the network key,
+// the answer carries their announcement and is learned as a route
+func (e *Node) verifyMember(peerID peer.ID) {
+	if e.announced(peerID) {
+		e.tagAnnouncedPeer(peerID.String())
+		return
+	}
+
+	b := e.broadcaster()
+	if b == nil || !discovery.IsPeerHinted(e.host, peerID) {
+		return
+	}
+	ctx, cancelFunc := context.WithTimeout(context.Background(), MEMBER_PROOF_TIMEOUT)
+	defer cancelFunc()
+	if err := b.SyncTableFrom(ctx, peerID); err != nil {
+		e.config.Logger.Debugf("Failed to ask the hinted peer %s for its table: %s", peerID, err)
+	}
+}
+
+// verifyPeers verifies the connected peers not tagged as members yet
+func (e *Node) verifyPeers() {
+	for _, peerID := range e.host.Network().Peers() {
+		if !broadcast.IsPeerFoundByDiscovery(e.host, peerID) {
+			e.verifyMember(peerID)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package node_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gfleury/solo/client/broadcast"
+	"github.com/gfleury/solo/client/discovery"
+	"github.com/gfleury/solo/client/node"
+	"github.com/gfleury/solo/common/models"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHintedPeersMembership(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	token := models.GenerateNewConnectionData(120).Base64()
+
+	// A directory listing two members and a host outside the network
+	var lock sync.Mutex
+	var directory []peer.AddrInfo
+	fetch := func(context.Context, host.Host) ([]peer.AddrInfo, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		return directory, nil
+	}
+
+	outsider, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer outsider.Close()
+
+	// TCP only, QUIC is not needed here
+	listen := discovery.AddrList{multiaddr.StringCast("/ip4/127.0.0.1/tcp/0")}
+	newNode := func(address string) *node.Node {
+		e, err := node.New(
+			node.WithStandalone(token),
+			node.WithInterface("", address, false),
+			node.WithUserspace(),
+			node.WithRandomIdentity(),
+			node.WithRandomPort(),
+			node.WithStoreDir(t.TempDir()),
+			node.WithListenAddresses(listen),
+			node.WithDiscoveryService(&discovery.PeerList{Name: "directory", DiscoveryInterval: time.Second, Fetch: fetch}),
+		)
+		require.NoError(t, err)
+		require.NoError(t, e.Start(ctx))
+		return e
+	}
+	e1 := newNode("10.2.4.1/24")
+	defer e1.Stop()
+	e2 := newNode("10.2.4.2/24")
+	defer e2.Stop()
+
+	lock.Lock()
+	// Loopback is filtered from the advertised addresses
+	for _, h := range []host.Host{e1.Host(), e2.Host(), outsider} {
+		directory = append(directory, peer.AddrInfo{ID: h.ID(), Addrs: h.Network().ListenAddresses()})
+	}
+	lock.Unlock()
+
+	// The members prove it to each other with their sealed tables
+	require.Eventually(t, func() bool {
+		return broadcast.IsPeerFoundByDiscovery(e1.Host(), e2.Host().ID()) &&
+			broadcast.IsPeerFoundByDiscovery(e2.Host(), e1.Host().ID())
+	}, 30*time.Second, 100*time.Millisecond)
+
+	// The outsider is connected and hinted, never a member
+	require.Eventually(t, func() bool {
+		return e1.Host().Network().Connectedness(outsider.ID()) == network.Connected
+	}, 10*time.Second, 100*time.Millisecond)
+	require.True(t, discovery.IsPeerHinted(e1.Host(), outsider.ID()))
+	require.Never(t, func() bool {
+		return broadcast.IsPeerFoundByDiscovery(e1.Host(), outsider.ID())
+	}, 2*time.Second, 100*time.Millisecond)
+	require.Len(t, e1.Peers(), 1)
+}
@@ -31,6 +31,7 @@ import (
 	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
 	"github.com/libp2p/go-libp2p/p2p/net/conngater"
 	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
 	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
 	"github.com/libp2p/go-libp2p/p2p/security/noise"
 
@@ -38,6 +39,9 @@ import (
 	"github.com/gfleury/solo/client/config"
 	"github.com/gfleury/solo/client/crypto"
 	discovery "github.com/gfleury/solo/client/discovery"
+	"github.com/gfleury/solo/client/events"
+	"github.com/gfleury/solo/client/forward"
+	"github.com/gfleury/solo/client/hooks"
 	"github.com/gfleury/solo/client/logger"
 	"github.com/gfleury/solo/client/utils"
 	"github.com/gfleury/solo/client/vpn"
@@ -49,74 +53,117 @@ type Node struct {
 	config      Config
 	Broadcaster broadcast.Broadcaster
 
-	host host.Host
-	cg   *conngater.BasicConnectionGater
+	host   host.Host
+	cg     *conngater.BasicConnectionGater
+	events *events.Bus
+	hooks  *hooks.Runner
+
+	// cancel stops the services started by Start, done is closed by Stop
+	cancel   context.CancelFunc
+	done     chan struct{}
+	stopOnce sync.Once
 	sync.Mutex
 }
 
 var defaultLibp2pOptions = []libp2p.Option{
 	libp2p.EnableNATService(),
-	libp2p.EnableRelayService(),
 	libp2p.EnableRelay(),
 }
 
-func NewWithConfig(cliConfig config.Config) (*Node, error) {
-	lvl, err := log.LevelFromString(cliConfig.LogLevel)
-	if err != nil {
-		lvl = log.LevelError
+// New creates a node configured with opts, without touching any process
+// wide state, it needs at least one discovery service
+func New(opts ...Option) (*Node, error) {
+	e := &Node{events: events.NewBus(), done: make(chan struct{})}
+	e.config = Config{
+		ListenAddresses: []discovery.AddrList{},
+		Logger:          logger.New(log.LevelError),
+		InterfaceName:   DEFAULT_INTERFACE_NAME,
+		MaxConnections:  DEFAULT_MAX_CONNECTIONS,
+		Broadcaster:     broadcast.BROADCASTER_STREAM,
+		RelayResources:  relay.DefaultResources(),
+		StoreDir:        IDENTITY_STORE_DIR,
+		Sealer:          &crypto.DefaultSealer{},
+	}
+	for _, opt := range opts {
+		if err := opt(&e.config); err != nil {
+			return nil, err
+		}
 	}
-	logger := logger.New(lvl)
 
-	if cliConfig.Libp2pLogLevel != "" {
-		if strings.Contains(cliConfig.Libp2pLogLevel, ":") {
-			logCfg := strings.Split(cliConfig.Libp2pLogLevel, ":")
-			err = log.SetLogLevel(logCfg[0], logCfg[1])
-			if err != nil {
-				return nil, err
+	if len(e.config.DiscoveryService) == 0 {
+		return nil, fmt.Errorf("no discovery service enabled")
+	}
+
+	// Configure VPN
+	if len(e.config.NetworkServices) == 0 {
+		e.config.NetworkServices = []NetworkService{vpn.VPNNetworkService(vpn.InterfaceConfig{
+			InterfaceMTU:     e.config.InterfaceMTU,
+			InterfaceName:    e.config.InterfaceName,
+			InterfaceAddress: e.config.InterfaceAddress,
+			CreateInterface:  e.config.CreateInterface,
+			Userspace:        e.config.Userspace,
+			TAP:              e.config.TAP,
+			Bridge:           e.config.Bridge,
+			PeerACL:          e.config.PeerACL,
+		})}
+	}
+	if _, ok := e.vpnService(); !ok {
+		return nil, fmt.Errorf("the first network service must be a *vpn.VPNService")
+	}
+
+	// Configure port forwarding
+	if len(e.config.Forwards) > 0 || len(e.config.Expose) > 0 || e.config.AllowReverseForward {
+		e.config.NetworkServices = append(e.config.NetworkServices,
+			forward.NewService(e.config.Forwards, e.config.Expose, e.config.AllowReverseForward))
+	}
+
+	// Publish the services events on the node bus
+	for _, sd := range e.config.DiscoveryService {
+		if receiver, ok := sd.(EventBusReceiver); ok {
+			receiver.SetEventBus(e.events)
+		}
+		if dhtService, ok := sd.(*discovery.DHT); ok {
+			if len(dhtService.DiscoveryPeers) == 0 {
+				dhtService.DiscoveryPeers = e.config.DiscoveryPeers
 			}
-		} else {
-			lvl, err := log.LevelFromString(cliConfig.LogLevel)
-			if err != nil {
-				return nil, err
+			if dhtService.BootstrapPeersFunc == nil {
+				dhtService.BootstrapPeersFunc = e.memberPeers
 			}
-			log.SetAllLoggers(lvl)
 		}
 	}
-
-	if cliConfig.PublicDiscoveryPeers {
-		cliConfig.DiscoveryPeers = []string{}
-		for _, peer := range dht.DefaultBootstrapPeers {
-			cliConfig.DiscoveryPeers = append(cliConfig.DiscoveryPeers, peer.String())
+	for _, s := range e.config.NetworkServices {
+		if receiver, ok := s.(EventBusReceiver); ok {
+			receiver.SetEventBus(e.events)
 		}
 	}
 
-	discoveryPeers := config.Peers2List(cliConfig.DiscoveryPeers)
+	// Configure LibP2P
+	libp2pOpts, err := e.libp2pOptions()
+	if err != nil {
+		return nil, err
+	}
+	e.config.Options = libp2pOpts
 
-	// Configure DHT Discovery
-	dhtOpts := []dht.Option{}
-	dhtService := discovery.NewDHT(dhtOpts...)
-	dhtService.DiscoveryInterval = time.Duration(cliConfig.DiscoveryInterval) * time.Second
-	// dhtService.OTPKey = connectionCfg.DiscoveryKey
-	dhtService.DiscoveryPeers = discoveryPeers
+	// Only persistent nodes keep an address book of their peers
+	if !e.config.RandomIdentity && e.config.AddressBook == nil {
+		e.config.AddressBook = NewAddressBook()
+		e.config.AddressBook.Dir = e.config.StoreDir
+	}
 
-	// Configure VPN
-	vpnService := vpn.VPNNetworkService(vpn.InterfaceConfig{
-		InterfaceMTU:     cliConfig.InterfaceMTU,
-		InterfaceName:    cliConfig.InterfaceName,
-		InterfaceAddress: cliConfig.InterfaceAddress,
-		CreateInterface:  cliConfig.CreateInterface,
-		// PreSharedKey:     connectionCfg.VPNPreSharedKey,
-	})
+	e.hooks = hooks.NewRunner(e.config.Logger, e.config.Hooks, "SOLO_INTERFACE="+e.config.InterfaceName)
 
-	// Configure LibP2P
+	return e, nil
+}
 
+// libp2pOptions returns the libp2p host options of the node configuration
+func (e *Node) libp2pOptions() ([]libp2p.Option, error) {
 	cm, err := connmgr.NewConnManager(
 		1,
-		cliConfig.MaxConnections,
+		e.config.MaxConnections,
 		connmgr.WithGracePeriod(80*time.Second),
 	)
 	if err != nil {
-		logger.Fatal("could not create connection manager")
+		return nil, fmt.Errorf("could not create connection manager: %w", err)
 	}
 
 	libp2pOpts := []libp2p.Option{
@@ -137,53 +184,182 @@ func NewWithConfig(cliConfig config.Config) (*Node, error) {
 
 	rc, err := rcmgr.NewResourceManager(limiter)
 	if err != nil {
-		logger.Fatal("could not create resource manager")
+		return nil, fmt.Errorf("could not create resource manager: %w", err)
 	}
 
 	libp2pOpts = append(libp2pOpts, libp2p.ResourceManager(rc))
 
-	if cliConfig.HolePunch {
+	if e.config.HolePunch {
 		libp2pOpts = append(libp2pOpts, libp2p.EnableHolePunching())
 	}
 
-	// Enable auto-relay, for behind NAT clients
-	libp2pOpts = append(libp2pOpts, libp2p.EnableAutoRelayWithPeerSource(dhtService.FindClosePeers(logger)))
-	pi, err := peer.AddrInfoFromP2pAddr(discoveryPeers[0])
-	if err != nil {
-		return nil, err
+	// Relay only for members of our network, with the configured limits
+	if e.config.RelayService {
+		libp2pOpts = append(libp2pOpts, libp2p.EnableRelayService(
+			relay.WithResources(e.config.RelayResources),
+			relay.WithACL(&networkRelayACL{node: e}),
+		))
 	}
-	libp2pOpts = append(libp2pOpts, libp2p.EnableAutoRelayWithStaticRelays([]peer.AddrInfo{*pi}))
+
+	// Enable auto-relay, for behind NAT clients, preferring relays from our network
+	staticRelays := []peer.AddrInfo{}
+	if len(e.config.DiscoveryPeers) > 0 {
+		pi, err := peer.AddrInfoFromP2pAddr(e.config.DiscoveryPeers[0])
+		if err != nil {
+			return nil, err
+		}
+		staticRelays = append(staticRelays, *pi)
+	}
+	libp2pOpts = append(libp2pOpts, libp2p.EnableAutoRelayWithPeerSource(e.relayPeerSource(staticRelays)))
 
 	// Use default addrsFactory to filter listenAddresses
 	addrsFactory := libp2p.AddrsFactory(utils.DefaultAddrsFactory)
 	libp2pOpts = append(libp2pOpts, addrsFactory)
 
+	return libp2pOpts, nil
+}
+
+// NewWithConfig creates a node from the cli configuration, unlike New it
+// also sets the process wide libp2p log levels and identify threshold
+func NewWithConfig(cliConfig config.Config) (*Node, error) {
+	lvl, err := log.LevelFromString(cliConfig.LogLevel)
+	if err != nil {
+		lvl = log.LevelError
+	}
+	logger := logger.New(lvl)
+
+	if cliConfig.Libp2pLogLevel != "" {
+		if strings.Contains(cliConfig.Libp2pLogLevel, ":") {
+			logCfg := strings.Split(cliConfig.Libp2pLogLevel, ":")
+			err = log.SetLogLevel(logCfg[0], logCfg[1])
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			lvl, err := log.LevelFromString(cliConfig.LogLevel)
+			if err != nil {
+				return nil, err
+			}
+			log.SetAllLoggers(lvl)
+		}
+	}
+
 	// Force holepunch to activate easily and faster after seen only once
 	identify.ActivationThresh = 1
 
-	nodeConfig := Config{
-		// BroadcastKey:      connectionCfg.BroadcastKey,
-		ListenAddresses:       []discovery.AddrList{},
-		RandomIdentity:        cliConfig.RandomIdentity,
-		RandomPort:            cliConfig.RandomPort,
-		DiscoveryService:      []DiscoveryService{dhtService},
-		NetworkServices:       []NetworkService{vpnService},
-		Logger:                logger,
-		InterfaceAddress:      cliConfig.InterfaceAddress,
-		InterfaceMTU:          cliConfig.InterfaceMTU,
-		AdditionalOptions:     []libp2p.Option{},
-		Options:               libp2pOpts,
-		DiscoveryPeers:        discoveryPeers,
-		Sealer:                &crypto.DefaultSealer{},
-		PublicDiscoveryPeers:  cliConfig.PublicDiscoveryPeers,
-		ConnectionConfigToken: cliConfig.Token,
-		StandaloneMode:        cliConfig.StandaloneMode,
-		PublishLocalRoutes:    cliConfig.PublishLocalRoutes,
-	}
-
-	return &Node{
-		config: nodeConfig,
-	}, nil
+	if cliConfig.PublicDiscoveryPeers {
+		cliConfig.DiscoveryPeers = []string{}
+		for _, peer := range dht.DefaultBootstrapPeers {
+			cliConfig.DiscoveryPeers = append(cliConfig.DiscoveryPeers, peer.String())
+		}
+	}
+
+	if cliConfig.Offline {
+		// Without standalone mode the network configuration comes from
+		// core-api, which can't be reached offline
+		if !cliConfig.StandaloneMode {
+			return nil, fmt.Errorf("offline mode needs standalone mode")
+		}
+		cliConfig.DiscoveryPeers = []string{}
+		cliConfig.DisableDHT = true
+		cliConfig.MDNSDiscovery = true
+		cliConfig.DNSDiscovery = []string{}
+		cliConfig.HTTPDiscovery = []string{}
+	}
+
+	discoveryPeers := config.Peers2List(cliConfig.DiscoveryPeers)
+
+	opts := []Option{
+		WithLogger(logger),
+		WithInterface(cliConfig.InterfaceName, cliConfig.InterfaceAddress, cliConfig.CreateInterface),
+		WithDiscoveryPeers(cliConfig.DiscoveryPeers...),
+		WithStaticPeers(cliConfig.StaticPeers...),
+		WithBroadcaster(cliConfig.Broadcaster),
+		func(cfg *Config) error {
+			cfg.PublicDiscoveryPeers = cliConfig.PublicDiscoveryPeers
+			cfg.ConnectionConfigToken = cliConfig.Token
+			cfg.StandaloneMode = cliConfig.StandaloneMode
+			return nil
+		},
+	}
+	if cliConfig.InterfaceMTU > 0 {
+		opts = append(opts, WithInterfaceMTU(cliConfig.InterfaceMTU))
+	}
+	if cliConfig.MaxConnections > 0 {
+		opts = append(opts, WithMaxConnections(cliConfig.MaxConnections))
+	}
+	if cliConfig.RandomIdentity {
+		opts = append(opts, WithRandomIdentity())
+	}
+	if cliConfig.RandomPort {
+		opts = append(opts, WithRandomPort())
+	}
+	if cliConfig.Userspace {
+		opts = append(opts, WithUserspace())
+	}
+	if cliConfig.TAP {
+		opts = append(opts, WithTAP(cliConfig.Bridge))
+	}
+	if cliConfig.PublishLocalRoutes {
+		opts = append(opts, WithPublishLocalRoutes())
+	}
+	if cliConfig.HolePunch {
+		opts = append(opts, WithHolePunch())
+	}
+	if cliConfig.RelayService {
+		opts = append(opts, WithRelayService(relayResources(cliConfig)))
+	}
+
+	// Configure DHT Discovery
+	if !cliConfig.DisableDHT {
+		dhtService := discovery.NewDHT()
+		dhtService.DiscoveryInterval = time.Duration(cliConfig.DiscoveryInterval) * time.Second
+		dhtService.Private = cliConfig.PrivateDHT
+		opts = append(opts, WithDiscoveryService(dhtService))
+	}
+
+	// Configure mDNS Discovery
+	if cliConfig.MDNSDiscovery {
+		opts = append(opts, WithDiscoveryService(discovery.NewMDNS()))
+	}
+
+	// Configure peer list Discovery backends
+	peerLists := []*discovery.PeerList{}
+	for _, domain := range cliConfig.DNSDiscovery {
+		peerLists = append(peerLists, discovery.NewDNS(domain))
+	}
+	for _, url := range cliConfig.HTTPDiscovery {
+		peerLists = append(peerLists, discovery.NewHTTPDirectory(url))
+	}
+	if cliConfig.CoreAPIDiscovery && !cliConfig.StandaloneMode && len(discoveryPeers) > 0 {
+		// core-api is served by the first discovery peer, like the registration
+		peerInfo, err := peer.AddrInfoFromP2pAddr(discoveryPeers[0])
+		if err != nil {
+			return nil, err
+		}
+		peerLists = append(peerLists, discovery.NewCoreAPI(peerInfo.ID))
+	}
+	for _, peerList := range peerLists {
+		peerList.DiscoveryInterval = time.Duration(cliConfig.DiscoveryInterval) * time.Second
+		opts = append(opts, WithDiscoveryService(peerList))
+	}
+
+	forwards, err := parseForwards(cliConfig)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, WithForwards(forwards...), WithExpose(cliConfig.Expose...))
+	if cliConfig.AllowReverseForward {
+		opts = append(opts, WithAllowReverseForward())
+	}
+
+	nodeHooks, err := parseHooks(cliConfig)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, WithHooks(nodeHooks...))
+
+	return New(opts...)
 }
 
 func (e *Node) Register(ctx context.Context) error {
@@ -199,6 +375,12 @@ func (e *Node) Register(ctx context.Context) error {
 	e.config.Logger.Info("Node ID:", e.host.ID())
 	e.config.Logger.Info("Node Addresses:", e.host.Addrs())
 
+	if len(e.config.DiscoveryPeers) == 0 {
+		err = fmt.Errorf("registration needs at least one discovery peer")
+		e.config.Logger.Error(err.Error())
+		return err
+	}
+
 	peerInfo, err := peer.AddrInfoFromP2pAddr(e.config.DiscoveryPeers[0])
 	if err != nil {
 		e.config.Logger.Error(err.Error())
@@ -228,6 +410,11 @@ func (e *Node) configurationDiscovery(ctx context.Context) error {
 	var connectionCfg *models.YAMLConnectionConfig
 	var err error
 
+	vpnService, ok := e.vpnService()
+	if !ok {
+		return fmt.Errorf("the first network service must be a *vpn.VPNService")
+	}
+
 	if e.config.StandaloneMode {
 		connectionCfg, err = models.YAMLConnectionConfigFromToken(e.config.ConnectionConfigToken)
 		if err != nil {
@@ -236,6 +423,9 @@ func (e *Node) configurationDiscovery(ctx context.Context) error {
 	} else {
 	OUT:
 		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			for _, peerID := range e.host.Peerstore().PeersWithKeys() {
 				if peerID == e.host.ID() {
 					// Skip ourselves
@@ -250,16 +440,26 @@ func (e *Node) configurationDiscovery(ctx context.Context) error {
 						return fmt.Errorf("node not found, register the node first: %s", err)
 					case http.StatusFailedDependency:
 						e.config.Logger.Errorf("node is not activated yet, go to interface and enter code")
-						time.Sleep(10 * time.Second)
+						select {
+						case <-ctx.Done():
+							return ctx.Err()
+						case <-time.After(10 * time.Second):
+						}
 						continue
 					default:
 						e.config.Logger.Errorf("failed to discovery configuration from: %s with %s", peerID, err)
-						time.Sleep(10 * time.Second)
+						select {
+						case <-ctx.Done():
+							return ctx.Err()
+						case <-time.After(10 * time.Second):
+						}
 						continue
 					}
 				}
 				e.config.InterfaceAddress = cfg.InterfaceAddress
-				e.config.NetworkServices[0].(*vpn.VPNService).Config.InterfaceAddress = cfg.InterfaceAddress
+				e.config.AddressCertificate = cfg.AddressCertificate
+				e.config.AddressAuthority = peerID
+				vpnService.Config.InterfaceAddress = cfg.InterfaceAddress
 				connectionCfg, err = models.YAMLConnectionConfigFromToken(cfg.ConnectionConfigToken)
 				if err != nil {
 					return err
@@ -272,21 +472,47 @@ func (e *Node) configurationDiscovery(ctx context.Context) error {
 				}
 				break OUT
 			}
+
+			// No core-api known yet, wait for discovery
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Second):
+			}
 		}
 
 	}
 
 	// Fill last configuration items from Connection Token
-	e.config.DiscoveryService[0].(*discovery.DHT).OTPKeyReceiver <- connectionCfg.DiscoveryKey
-	e.config.NetworkServices[0].(*vpn.VPNService).Config.PreSharedKey = connectionCfg.VPNPreSharedKey
+	for _, sd := range e.config.DiscoveryService {
+		if receiver, ok := sd.(DiscoveryKeyReceiver); ok {
+			receiver.SetDiscoveryKey(connectionCfg.DiscoveryKey)
+		}
+	}
+	vpnService.Config.PreSharedKey = connectionCfg.VPNPreSharedKey
 	e.config.BroadcastKey = connectionCfg.BroadcastKey
 
+	e.events.Publish(events.Event{Type: events.CONFIGURATION_CHANGED, IP: e.config.InterfaceAddress, Detail: e.config.AddressAuthority.String()})
+
 	return nil
 }
 
-// Start joins the node over the p2p network
-func (e *Node) Start(ctx context.Context) error {
-	var err error
+// Start joins the node over the p2p network and returns once its network
+// services run, the node runs until ctx is done or Stop is called
+func (e *Node) Start(ctx context.Context) (err error) {
+	e.Lock()
+	if e.cancel != nil {
+		e.Unlock()
+		return fmt.Errorf("node already started")
+	}
+	ctx, e.cancel = context.WithCancel(ctx)
+	e.Unlock()
+
+	defer func() {
+		if err != nil {
+			e.Stop()
+		}
+	}()
 
 	e.config.Logger.Info("Starting Solo P2P network")
 
@@ -300,6 +526,13 @@ func (e *Node) Start(ctx context.Context) error {
 	e.config.Logger.Info("Node ID:", e.host.ID())
 	e.config.Logger.Info("Node Addresses:", e.host.Addrs())
 
+	// Publish the members joining and leaving and run the hooks of the events
+	e.host.Network().Notify(e.peerNotifiee())
+	e.hooks.Start(e.events)
+
+	// Reconnect to static and recently seen peers while discovery warms up
+	go e.maintainKnownPeers(ctx)
+
 	// Startup discovery
 	err = e.startDiscovery(ctx)
 	if err != nil {
@@ -325,25 +558,63 @@ func (e *Node) Start(ctx context.Context) error {
 	}
 
 	// Start eventual declared NetworkServices
-	var networkServices sync.WaitGroup
 	for _, s := range e.config.NetworkServices {
-		err := s.Run(ctx, e.config.Logger, e.Host(), e.Broadcaster)
+		err = s.Run(ctx, e.config.Logger, e.Host(), e.Broadcaster)
 		if err != nil {
 			return fmt.Errorf("error while starting network service: '%w'", err)
 		}
-		networkServices.Add(1)
 	}
 
-	// Wait for all Network Services to complete
-	networkServices.Wait()
+	// Stop the node with its context
+	go func() {
+		<-ctx.Done()
+		e.Stop()
+	}()
 
 	return nil
 }
 
+// Stop tears the network services down, waits for their hooks and closes the host
+func (e *Node) Stop() {
+	e.stopOnce.Do(func() {
+		for _, s := range e.config.NetworkServices {
+			if stopper, ok := s.(NetworkServiceStopper); ok {
+				if err := stopper.Stop(); err != nil {
+					e.config.Logger.Errorf("Failed to stop network service: %s", err)
+				}
+			}
+		}
+		e.hooks.Stop()
+
+		e.Lock()
+		if e.cancel != nil {
+			e.cancel()
+		}
+		e.Unlock()
+
+		if e.host != nil {
+			e.host.Network().Close()
+			e.host.ConnManager().Close()
+			e.host.Close()
+		}
+		close(e.done)
+	})
+}
+
+// Done is closed once the node is stopped
+func (e *Node) Done() <-chan struct{} {
+	return e.done
+}
+
+// Logger returns the logger of the node and its services
+func (e *Node) Logger() log.StandardLogger {
+	return e.config.Logger
+}
+
 func (e *Node) startDiscovery(ctx context.Context) error {
 	for _, sd := range e.config.DiscoveryService {
 		if err := sd.Run(e.config.Logger, ctx, e.host); err != nil {
-			e.config.Logger.Fatal(fmt.Errorf("while starting service discovery %+v: '%w", sd, err))
+			return fmt.Errorf("while starting service discovery %+v: '%w'", sd, err)
 		}
 	}
 
@@ -352,19 +623,51 @@ func (e *Node) startDiscovery(ctx context.Context) error {
 }
 
 func (e *Node) startBroadcastService(ctx context.Context) error {
-	e.Broadcaster = broadcast.NewStreamBroadcaster(
+	b, err := broadcast.New(
+		e.config.Broadcaster,
 		e.config.Logger,
 		e.config.DiscoveryPeers,
 		e.config.BroadcastKey,
 		e.config.PublishLocalRoutes,
 	)
+	if err != nil {
+		return err
+	}
+	// Read by the peers verification, see members.go
+	e.Lock()
+	e.Broadcaster = b
+	e.Unlock()
 
 	// Configure Broadcast and PRP
-	myIP, _, err := net.ParseCIDR(e.config.InterfaceAddress)
+	myIP, subnet, err := net.ParseCIDR(e.config.InterfaceAddress)
 	if err != nil {
 		return err
 	}
+
+	if table := e.Broadcaster.Table(); table != nil {
+		table.SetEventBus(e.events)
+		table.SetSubnet(subnet)
+
+		// Verify the address certificates with the core-api key
+		if e.config.AddressAuthority != "" {
+			authority, err := e.config.AddressAuthority.ExtractPublicKey()
+			if err != nil {
+				return err
+			}
+			table.SetAddressCertificate(e.config.AddressCertificate, authority)
+		}
+	}
 	go e.Broadcaster.Start(ctx, e.host, myIP.String())
 
+	// The peers discovered before the broadcaster ran are verified once it
+	// announces
+	go func() {
+		select {
+		case <-time.After(broadcast.ANNOUNCE_DELAY):
+			e.verifyPeers()
+		case <-ctx.Done():
+		}
+	}()
+
 	return nil
 }
@@ -10,12 +10,16 @@ import (
 	"github.com/gfleury/solo/client/broadcast/protocol"
 	"github.com/gfleury/solo/client/broadcast/prp"
 	"github.com/gfleury/solo/client/config"
+	"github.com/gfleury/solo/client/discovery"
 	"github.com/gfleury/solo/client/logger"
 	"github.com/gfleury/solo/client/node"
 	"github.com/gfleury/solo/cmd"
 	"github.com/gfleury/solo/common/models"
 	rendezvous "github.com/gfleury/solo/server/core-api/rendezvous"
 	"github.com/ipfs/go-log"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -84,7 +88,9 @@ func (s *NodeTestSuite) TestNodeDiscoveryBasic() {
 			case <-ctx.Done():
 				return
 			default:
-				e.Broadcaster.SendPacket(ctx, metapacket.NewMetaPacket(protocol.Type_PRP, &prp.PRPacket{PRPType: prp.PRPReply, IP: "10.2.3.4", Machine: models.NetworkNode{PeerID: e.Host().ID().String()}}))
+				reply := &prp.PRPacket{PRPType: prp.PRPReply, IP: "10.2.3.4", Machine: models.NetworkNode{PeerID: e.Host().ID().String(), IP: "10.2.3.4"}}
+				reply.Sign(e.Host().Peerstore().PrivKey(e.Host().ID()))
+				e.Broadcaster.SendPacket(ctx, metapacket.NewMetaPacket(protocol.Type_PRP, reply))
 				e2.Broadcaster.SendPacket(ctx, metapacket.NewFromPayload(prp.NewPRPRequestPacket("10.2.3.1")))
 				e.Broadcaster.SendPacket(ctx, metapacket.NewFromPayload(prp.NewPRPRequestPacket("10.2.3.2")))
 				time.Sleep(2 * time.Second)
@@ -264,3 +270,70 @@ func XXXTestTryConnectingRemote(t *testing.T) {
 	}
 
 }
+
+func (s *NodeTestSuite) TestStartStop() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newNode := func(opts ...node.Option) *node.Node {
+		e, err := node.New(append([]node.Option{
+			node.WithInterface("", "10.2.5.1/24", false),
+			node.WithUserspace(),
+			node.WithRandomIdentity(),
+			node.WithRandomPort(),
+			node.WithStoreDir(s.T().TempDir()),
+			node.WithDiscoveryService(&discovery.PeerList{Name: "none", DiscoveryInterval: time.Minute, Fetch: noPeers}),
+		}, opts...)...)
+		s.Require().NoError(err)
+		return e
+	}
+
+	// Stop tears a started node down
+	e := newNode(node.WithStandalone(s.token))
+	s.Require().NoError(e.Start(ctx))
+	s.Error(e.Start(ctx))
+	e.Stop()
+	s.requireDone(e)
+	s.Empty(e.Host().Network().Peers())
+	e.Stop()
+
+	// And so does its context
+	nodeCtx, nodeCancel := context.WithCancel(ctx)
+	e = newNode(node.WithStandalone(s.token))
+	s.Require().NoError(e.Start(nodeCtx))
+	nodeCancel()
+	s.requireDone(e)
+
+	// A node retrying a peer that isn't core-api returns once its context is done
+	notAPI, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	s.Require().NoError(err)
+	defer notAPI.Close()
+	nodeCtx, nodeCancel = context.WithCancel(ctx)
+	e = newNode(node.WithDiscoveryService(&discovery.PeerList{Name: "static", DiscoveryInterval: time.Minute,
+		Fetch: func(context.Context, host.Host) ([]peer.AddrInfo, error) {
+			return []peer.AddrInfo{{ID: notAPI.ID(), Addrs: notAPI.Addrs()}}, nil
+		}}))
+	started := make(chan error)
+	go func() { started <- e.Start(nodeCtx) }()
+	time.Sleep(time.Second)
+	nodeCancel()
+	select {
+	case err := <-started:
+		s.ErrorIs(err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		s.FailNow("Start didn't return once its context was done")
+	}
+	s.requireDone(e)
+}
+
+func (s *NodeTestSuite) requireDone(e *node.Node) {
+	select {
+	case <-e.Done():
+	case <-time.After(5 * time.Second):
+		s.FailNow("node not stopped")
+	}
+}
+
+func noPeers(context.Context, host.Host) ([]peer.AddrInfo, error) {
+	return nil, nil
+}
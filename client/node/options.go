@@ -0,0 +1,254 @@
+package node
+
+import (
+	"fmt"
+
+	"github.com/ipfs/go-log"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+
+	discovery "github.com/gfleury/solo/client/discovery"
+	"github.com/gfleury/solo/client/forward"
+	"github.com/gfleury/solo/client/hooks"
+)
+
+const (
+	// Defaults of the nodes created with New
+	DEFAULT_INTERFACE_NAME  = "utun0"
+	DEFAULT_MAX_CONNECTIONS = 256
+)
+
+// Option configures the nodes created with New
+type Option func(cfg *Config) error
+
+// WithLogger sets the logger of the node and its services
+func WithLogger(logger log.StandardLogger) Option {
+	return func(cfg *Config) error {
+		cfg.Logger = logger
+		return nil
+	}
+}
+
+// WithStandalone joins the network of the connection token, without core-api
+func WithStandalone(token string) Option {
+	return func(cfg *Config) error {
+		cfg.StandaloneMode = true
+		cfg.ConnectionConfigToken = token
+		return nil
+	}
+}
+
+// WithInterface sets the VPN interface name and CIDR address, create
+// false expects the interface to exist already
+func WithInterface(name, address string, create bool) Option {
+	return func(cfg *Config) error {
+		cfg.InterfaceName = name
+		cfg.InterfaceAddress = address
+		cfg.CreateInterface = create
+		return nil
+	}
+}
+
+// WithUserspace runs the VPN on a userspace netstack instead of a TUN
+// device, the overlay is reached with Node.Netstack
+func WithUserspace() Option {
+	return func(cfg *Config) error {
+		cfg.Userspace = true
+		return nil
+	}
+}
+
+// WithTAP switches Ethernet frames on a TAP interface instead of IP packets,
+// attached to the bridge if not empty
+func WithTAP(bridge string) Option {
+	return func(cfg *Config) error {
+		cfg.TAP = true
+		cfg.Bridge = bridge
+		return nil
+	}
+}
+
+// WithInterfaceMTU sets the VPN interface MTU, by default it is selected
+// from the host links, see vpn.AutoMTU
+func WithInterfaceMTU(mtu int) Option {
+	return func(cfg *Config) error {
+		cfg.InterfaceMTU = mtu
+		return nil
+	}
+}
+
+// WithDiscoveryPeers sets the bootstrap peers multiaddrs, the first one
+// serves core-api and is the static relay
+func WithDiscoveryPeers(addrs ...string) Option {
+	return func(cfg *Config) error {
+		for _, addr := range addrs {
+			if err := cfg.DiscoveryPeers.Set(addr); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// WithStaticPeers sets peers multiaddrs that are always kept connected
+func WithStaticPeers(addrs ...string) Option {
+	return func(cfg *Config) error {
+		for _, addr := range addrs {
+			if _, err := peer.AddrInfoFromString(addr); err != nil {
+				return fmt.Errorf("invalid static peer %s: %w", addr, err)
+			}
+			if err := cfg.StaticPeers.Set(addr); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// WithDiscoveryService adds discovery services, at least one is needed
+func WithDiscoveryService(services ...DiscoveryService) Option {
+	return func(cfg *Config) error {
+		cfg.DiscoveryService = append(cfg.DiscoveryService, services...)
+		return nil
+	}
+}
+
+// WithNetworkService replaces the default VPN service, the first one must
+// be a *vpn.VPNService
+func WithNetworkService(services ...NetworkService) Option {
+	return func(cfg *Config) error {
+		cfg.NetworkServices = append(cfg.NetworkServices, services...)
+		return nil
+	}
+}
+
+// WithRandomIdentity uses a new identity instead of the persisted one,
+// without address book
+func WithRandomIdentity() Option {
+	return func(cfg *Config) error {
+		cfg.RandomIdentity = true
+		return nil
+	}
+}
+
+// WithRandomPort listens on random ports instead of DEFAULT_BASE_PORT
+func WithRandomPort() Option {
+	return func(cfg *Config) error {
+		cfg.RandomPort = true
+		return nil
+	}
+}
+
+// WithListenAddresses listens on addrs instead of the default ports
+func WithListenAddresses(addrs discovery.AddrList) Option {
+	return func(cfg *Config) error {
+		cfg.ListenAddresses = append(cfg.ListenAddresses, addrs)
+		return nil
+	}
+}
+
+// WithStoreDir persists the identity and the address book in dir
+// instead of IDENTITY_STORE_DIR
+func WithStoreDir(dir string) Option {
+	return func(cfg *Config) error {
+		cfg.StoreDir = dir
+		return nil
+	}
+}
+
+// WithBroadcaster selects the broadcast.New implementation
+func WithBroadcaster(kind string) Option {
+	return func(cfg *Config) error {
+		cfg.Broadcaster = kind
+		return nil
+	}
+}
+
+// WithPublishLocalRoutes publishes the local routes to the other peers
+func WithPublishLocalRoutes() Option {
+	return func(cfg *Config) error {
+		cfg.PublishLocalRoutes = true
+		return nil
+	}
+}
+
+// WithMaxConnections sets the connection manager high watermark
+func WithMaxConnections(max int) Option {
+	return func(cfg *Config) error {
+		cfg.MaxConnections = max
+		return nil
+	}
+}
+
+// WithHolePunch enables hole punching to bypass NAT
+func WithHolePunch() Option {
+	return func(cfg *Config) error {
+		cfg.HolePunch = true
+		return nil
+	}
+}
+
+// WithRelayService relays connections for the network members with resources
+func WithRelayService(resources relay.Resources) Option {
+	return func(cfg *Config) error {
+		cfg.RelayService = true
+		cfg.RelayResources = resources
+		return nil
+	}
+}
+
+// WithHooks runs hook scripts and webhooks on the node events
+func WithHooks(nodeHooks ...hooks.Hook) Option {
+	return func(cfg *Config) error {
+		cfg.Hooks = append(cfg.Hooks, nodeHooks...)
+		return nil
+	}
+}
+
+// WithForwards forwards the ports over the network, see forward.Parse
+func WithForwards(forwards ...forward.Forward) Option {
+	return func(cfg *Config) error {
+		cfg.Forwards = append(cfg.Forwards, forwards...)
+		return nil
+	}
+}
+
+// WithExpose lets the network members connect to our loopback ports, "port[/udp]"
+func WithExpose(ports ...string) Option {
+	return func(cfg *Config) error {
+		for _, port := range ports {
+			exposed, err := forward.ParseExpose(port)
+			if err != nil {
+				return err
+			}
+			cfg.Expose = append(cfg.Expose, exposed)
+		}
+		return nil
+	}
+}
+
+// WithAllowReverseForward lets the network members listen on our loopback ports
+func WithAllowReverseForward() Option {
+	return func(cfg *Config) error {
+		cfg.AllowReverseForward = true
+		return nil
+	}
+}
+
+// WithPeerACL refuses the VPN traffic of the peers acl returns false for,
+// they get ICMP administratively prohibited errors
+func WithPeerACL(acl func(peer.ID) bool) Option {
+	return func(cfg *Config) error {
+		cfg.PeerACL = acl
+		return nil
+	}
+}
+
+// WithLibp2pOptions adds options to the libp2p host, applied after ours
+func WithLibp2pOptions(opts ...libp2p.Option) Option {
+	return func(cfg *Config) error {
+		cfg.AdditionalOptions = append(cfg.AdditionalOptions, opts...)
+		return nil
+	}
+}
@@ -0,0 +1,51 @@
+package node_test
+
+import (
+	"testing"
+
+	"github.com/gfleury/solo/client/config"
+	"github.com/gfleury/solo/client/discovery"
+	"github.com/gfleury/solo/client/forward"
+	"github.com/gfleury/solo/client/node"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewOptions(t *testing.T) {
+	// A node has to discover its network somehow
+	_, err := node.New(node.WithRandomIdentity())
+	require.Error(t, err)
+
+	_, err = node.New(node.WithDiscoveryService(discovery.NewMDNS()), node.WithDiscoveryPeers("not a multiaddr"))
+	require.Error(t, err)
+
+	// Static peers need their peer ID
+	_, err = node.New(node.WithDiscoveryService(discovery.NewMDNS()), node.WithStaticPeers("/ip4/192.0.2.1/tcp/5544"))
+	require.Error(t, err)
+
+	// The VPN has to be the first network service
+	_, err = node.New(node.WithDiscoveryService(discovery.NewMDNS()), node.WithNetworkService(forward.NewService(nil, nil, false)))
+	require.Error(t, err)
+
+	e, err := node.New(
+		node.WithDiscoveryService(discovery.NewMDNS()),
+		node.WithInterface("", "10.2.3.1/24", false),
+		node.WithRandomIdentity(),
+		node.WithRandomPort(),
+		node.WithStoreDir(t.TempDir()),
+	)
+	require.NoError(t, err)
+	require.NotNil(t, e.Events())
+	require.Empty(t, e.Peers())
+	require.Empty(t, e.Routes())
+
+	// Stopping a node never started only closes it
+	e.Stop()
+	e.Stop()
+	<-e.Done()
+}
+
+func TestNewWithConfigOffline(t *testing.T) {
+	// core-api can't be reached to configure the network
+	_, err := node.NewWithConfig(config.Config{Offline: true, RandomIdentity: true, RandomPort: true})
+	require.Error(t, err)
+}
@@ -0,0 +1,106 @@
+package node
+
+import (
+	"context"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/autorelay"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/proto"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	ma "github.com/multiformats/go-multiaddr"
+
+	"github.com/gfleury/solo/client/broadcast"
+	"github.com/gfleury/solo/client/config"
+)
+
+// relayResources tunes relay.DefaultResources with the limits from the cli config,
+// zero values keep the libp2p defaults
+func relayResources(cliConfig config.Config) relay.Resources {
+	resources := relay.DefaultResources()
+
+	if cliConfig.RelayMaxReservations > 0 {
+		resources.MaxReservations = cliConfig.RelayMaxReservations
+	}
+	if cliConfig.RelayMaxCircuits > 0 {
+		resources.MaxCircuits = cliConfig.RelayMaxCircuits
+	}
+	if cliConfig.RelayMaxDuration > 0 {
+		resources.Limit.Duration = time.Duration(cliConfig.RelayMaxDuration) * time.Second
+	}
+	if cliConfig.RelayMaxData > 0 {
+		resources.Limit.Data = cliConfig.RelayMaxData
+	}
+
+	return resources
+}
+
+// networkRelayACL only relays traffic between members of our own network
+type networkRelayACL struct {
+	node *Node
+}
+
+func (a *networkRelayACL) AllowReserve(p peer.ID, src ma.Multiaddr) bool {
+	allowed := a.node.isNetworkMember(p)
+	if !allowed {
+		a.node.config.Logger.Debugf("Relay reservation denied for %s from %s, not a network member", p, src)
+	}
+	return allowed
+}
+
+func (a *networkRelayACL) AllowConnect(src peer.ID, srcAddr ma.Multiaddr, dst peer.ID) bool {
+	allowed := a.node.isNetworkMember(src) && a.node.isNetworkMember(dst)
+	if !allowed {
+		a.node.config.Logger.Debugf("Relay connection denied from %s to %s, not network members", src, dst)
+	}
+	return allowed
+}
+
+// isNetworkMember reports if peerID was found by our network discovery
+func (e *Node) isNetworkMember(peerID peer.ID) bool {
+	if e.host == nil {
+		return false
+	}
+	return broadcast.IsPeerFoundByDiscovery(e.host, peerID)
+}
+
+// networkRelays returns the connected network members offering the relay service
+func (e *Node) networkRelays() []peer.AddrInfo {
+	relays := []peer.AddrInfo{}
+	if e.host == nil {
+		return relays
+	}
+
+	for _, peerID := range e.host.Network().Peers() {
+		if !e.isNetworkMember(peerID) {
+			continue
+		}
+		protocols, err := e.host.Peerstore().SupportsProtocols(peerID, proto.ProtoIDv2Hop)
+		if err != nil || len(protocols) == 0 {
+			continue
+		}
+		relays = append(relays, peer.AddrInfo{ID: peerID, Addrs: e.host.Peerstore().Addrs(peerID)})
+	}
+
+	return relays
+}
+
+// relayPeerSource feeds AutoRelay with relays from our own network first
+// and falls back to the static ones (the discovery peers)
+func (e *Node) relayPeerSource(staticRelays []peer.AddrInfo) autorelay.PeerSource {
+	return func(ctx context.Context, numPeers int) <-chan peer.AddrInfo {
+		candidates := append(e.networkRelays(), staticRelays...)
+		if len(candidates) > numPeers {
+			candidates = candidates[:numPeers]
+		}
+
+		peerChan := make(chan peer.AddrInfo, len(candidates))
+		for _, candidate := range candidates {
+			e.config.Logger.Debugf("[relay discovery] Relay candidate '%s'", candidate.ID)
+			peerChan <- candidate
+		}
+		close(peerChan)
+
+		return peerChan
+	}
+}
@@ -0,0 +1,126 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-log"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/proto"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gfleury/solo/client/discovery"
+	"github.com/gfleury/solo/client/logger"
+)
+
+func newRelayTestHost(t *testing.T) host.Host {
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+func newRelayTestNode(h host.Host) *Node {
+	return &Node{host: h, config: Config{Logger: logger.New(log.LevelDebug)}}
+}
+
+func connectRelayTestHosts(t *testing.T, h host.Host, peers ...host.Host) {
+	for _, p := range peers {
+		require.NoError(t, h.Connect(context.Background(), peer.AddrInfo{ID: p.ID(), Addrs: p.Addrs()}))
+	}
+}
+
+func TestNetworkRelayACL(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	relayHost := newRelayTestHost(t)
+	e := newRelayTestNode(relayHost)
+	r, err := relay.New(relayHost, relay.WithACL(&networkRelayACL{node: e}))
+	require.NoError(t, err)
+	defer r.Close()
+
+	member := newRelayTestHost(t)
+	member2 := newRelayTestHost(t)
+	outsider := newRelayTestHost(t)
+	relayInfo := peer.AddrInfo{ID: relayHost.ID(), Addrs: relayHost.Addrs()}
+	connectRelayTestHosts(t, relayHost, member, member2, outsider)
+	discovery.TagPeerAsFound(relayHost, member.ID())
+	discovery.TagPeerAsFound(relayHost, member2.ID())
+
+	// Only the members reserve a slot
+	_, err = client.Reserve(ctx, member, relayInfo)
+	require.NoError(t, err)
+	_, err = client.Reserve(ctx, outsider, relayInfo)
+	require.Error(t, err)
+
+	// Only the members are relayed to the members
+	circuit := peer.AddrInfo{ID: member.ID(), Addrs: []ma.Multiaddr{
+		ma.StringCast("/p2p/" + relayHost.ID().String() + "/p2p-circuit"),
+	}}
+	for _, h := range []host.Host{member2, outsider} {
+		h.Peerstore().AddAddrs(relayHost.ID(), relayHost.Addrs(), time.Hour)
+	}
+	require.NoError(t, member2.Connect(ctx, circuit))
+	require.Error(t, outsider.Connect(ctx, circuit))
+
+	// Both ends have to be members
+	acl := &networkRelayACL{node: e}
+	require.True(t, acl.AllowConnect(member.ID(), nil, member2.ID()))
+	require.False(t, acl.AllowConnect(member.ID(), nil, outsider.ID()))
+	require.False(t, acl.AllowConnect(outsider.ID(), nil, member.ID()))
+	require.True(t, acl.AllowReserve(member.ID(), nil))
+	require.False(t, acl.AllowReserve(outsider.ID(), nil))
+
+	// A node not started yet has no members
+	require.False(t, (&networkRelayACL{node: newRelayTestNode(nil)}).AllowReserve(member.ID(), nil))
+}
+
+func TestRelayPeerSource(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := newRelayTestHost(t)
+	e := newRelayTestNode(h)
+
+	// Two relays, only one of them in our network, and a peer without relay
+	memberRelay := newRelayTestHost(t)
+	outsiderRelay := newRelayTestHost(t)
+	for _, relayHost := range []host.Host{memberRelay, outsiderRelay} {
+		r, err := relay.New(relayHost)
+		require.NoError(t, err)
+		defer r.Close()
+	}
+	member := newRelayTestHost(t)
+	connectRelayTestHosts(t, h, memberRelay, outsiderRelay, member)
+	discovery.TagPeerAsFound(h, memberRelay.ID())
+	discovery.TagPeerAsFound(h, member.ID())
+
+	// Identify learns the relay protocol of the peers
+	require.Eventually(t, func() bool {
+		protocols, _ := h.Peerstore().SupportsProtocols(memberRelay.ID(), proto.ProtoIDv2Hop)
+		return len(protocols) > 0
+	}, 10*time.Second, 100*time.Millisecond)
+
+	static := peer.AddrInfo{ID: newRelayTestHost(t).ID()}
+	source := e.relayPeerSource([]peer.AddrInfo{static})
+
+	candidates := []peer.ID{}
+	for info := range source(ctx, 10) {
+		candidates = append(candidates, info.ID)
+	}
+	require.Equal(t, []peer.ID{memberRelay.ID(), static.ID}, candidates)
+
+	// Our network relays come first
+	candidates = []peer.ID{}
+	for info := range source(ctx, 1) {
+		candidates = append(candidates, info.ID)
+	}
+	require.Equal(t, []peer.ID{memberRelay.ID()}, candidates)
+}
@@ -5,9 +5,11 @@ import (
 )
 
 const (
-	ALLEIN         Protocol = "/allein/0.1"
-	BROADCAST      Protocol = "/broadcast/0.1"
-	NOISEHANDSHAKE Protocol = "/noisehandshake/0.1"
+	ALLEIN           Protocol = "/allein/0.1"
+	BROADCAST        Protocol = "/broadcast/0.2"
+	NOISEHANDSHAKE   Protocol = "/noisehandshake/0.1"
+	FORWARD          Protocol = "/forward/0.1"
+	BROADCAST_DIRECT Protocol = "/broadcast/direct/0.1"
 )
 
 type Protocol string
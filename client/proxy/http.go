@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+func (s *Server) serveHTTP(conn net.Conn, reader *bufio.Reader) error {
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		return err
+	}
+
+	if req.Method == http.MethodConnect {
+		return s.serveHTTPConnect(conn, reader, req)
+	}
+
+	// Plain requests carry the absolute URL of the destination
+	if req.URL.Host == "" {
+		httpReply(conn, http.StatusBadRequest)
+		return fmt.Errorf("http request without host %s", req.URL)
+	}
+	port := req.URL.Port()
+	if port == "" {
+		port = "80"
+	}
+
+	dst, err := s.dial(req.URL.Hostname(), port)
+	if err != nil {
+		httpReply(conn, http.StatusBadGateway)
+		return err
+	}
+
+	// One request per connection, the next one may be for another host
+	req.Header.Del("Proxy-Connection")
+	req.Header.Del("Proxy-Authorization")
+	req.Close = true
+	if err := req.Write(dst); err != nil {
+		dst.Close()
+		httpReply(conn, http.StatusBadGateway)
+		return err
+	}
+
+	pipe(conn, reader, dst)
+	return nil
+}
+
+func (s *Server) serveHTTPConnect(conn net.Conn, reader *bufio.Reader, req *http.Request) error {
+	host, port, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		httpReply(conn, http.StatusBadRequest)
+		return err
+	}
+
+	dst, err := s.dial(host, port)
+	if err != nil {
+		httpReply(conn, http.StatusBadGateway)
+		return err
+	}
+	if err := httpReply(conn, http.StatusOK); err != nil {
+		dst.Close()
+		return err
+	}
+
+	pipe(conn, reader, dst)
+	return nil
+}
+
+func httpReply(conn net.Conn, status int) error {
+	_, err := fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n\r\n", status, http.StatusText(status))
+	return err
+}
@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/ipfs/go-log"
+)
+
+const (
+	// PROXY_DIAL_TIMEOUT bounds the connection to an overlay destination
+	PROXY_DIAL_TIMEOUT = 30 * time.Second
+	// SOCKS5 requests start with the protocol version, anything else is HTTP
+	SOCKS5_VERSION = 0x05
+)
+
+// Dialer connects to an overlay address, like net.Dialer.DialContext
+type Dialer func(ctx context.Context, network, address string) (net.Conn, error)
+
+// Resolver returns the overlay IP of a host name
+type Resolver func(host string) (string, bool)
+
+// Server is a SOCKS5 and HTTP (CONNECT and plain requests) proxy into the
+// overlay network, both protocols are served on the same listener
+type Server struct {
+	Dial    Dialer
+	Resolve Resolver
+	Logger  log.StandardLogger
+}
+
+func NewServer(logger log.StandardLogger, dial Dialer, resolve Resolver) *Server {
+	return &Server{Dial: dial, Resolve: resolve, Logger: logger}
+}
+
+// Serve accepts the proxy clients of l until it is closed
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	version, err := reader.Peek(1)
+	if err != nil {
+		return
+	}
+
+	if version[0] == SOCKS5_VERSION {
+		err = s.serveSOCKS5(conn, reader)
+	} else {
+		err = s.serveHTTP(conn, reader)
+	}
+	if err != nil {
+		s.Logger.Debugf("[proxy] %s: %s", conn.RemoteAddr(), err)
+	}
+}
+
+// dial connects to host:port on the overlay, host names are resolved with
+// the PRP table
+func (s *Server) dial(host string, port string) (net.Conn, error) {
+	if net.ParseIP(host) == nil {
+		ip, ok := s.Resolve(host)
+		if !ok {
+			return nil, fmt.Errorf("unknown host %s", host)
+		}
+		host = ip
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), PROXY_DIAL_TIMEOUT)
+	defer cancel()
+	return s.Dial(ctx, "tcp", net.JoinHostPort(host, port))
+}
+
+// pipe copies both ways between the client, read from reader, and the
+// destination until the destination is done
+func pipe(conn net.Conn, reader io.Reader, dst net.Conn) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		io.Copy(dst, reader)
+		if halfCloser, ok := dst.(interface{ CloseWrite() error }); ok {
+			halfCloser.CloseWrite()
+		}
+	}()
+
+	io.Copy(conn, dst)
+	conn.Close()
+	dst.Close()
+	<-done
+}
@@ -0,0 +1,142 @@
+package proxy_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/ipfs/go-log/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gfleury/solo/client/logger"
+	"github.com/gfleury/solo/client/proxy"
+)
+
+func startProxy(t *testing.T) string {
+	dialer := &net.Dialer{}
+	hosts := map[string]string{"staging": "127.0.0.1"}
+	s := proxy.NewServer(logger.New(log.LevelDebug), dialer.DialContext, func(host string) (string, bool) {
+		ip, ok := hosts[host]
+		return ip, ok
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go s.Serve(l)
+
+	return l.Addr().String()
+}
+
+func startEcho(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	return port
+}
+
+func requireEcho(t *testing.T, conn net.Conn) {
+	_, err := conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	reply := make([]byte, 5)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(reply))
+}
+
+func socks5Connect(t *testing.T, proxyAddr, host string, port int) (net.Conn, byte) {
+	conn, err := net.Dial("tcp", proxyAddr)
+	require.NoError(t, err)
+
+	_, err = conn.Write([]byte{proxy.SOCKS5_VERSION, 1, proxy.SOCKS5_NO_AUTH})
+	require.NoError(t, err)
+	method := make([]byte, 2)
+	_, err = io.ReadFull(conn, method)
+	require.NoError(t, err)
+	require.Equal(t, []byte{proxy.SOCKS5_VERSION, proxy.SOCKS5_NO_AUTH}, method)
+
+	request := []byte{proxy.SOCKS5_VERSION, proxy.SOCKS5_CMD_CONNECT, 0, proxy.SOCKS5_ATYP_DOMAIN, byte(len(host))}
+	request = append(request, host...)
+	request = append(request, byte(port>>8), byte(port))
+	_, err = conn.Write(request)
+	require.NoError(t, err)
+
+	reply := make([]byte, 10)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	return conn, reply[1]
+}
+
+func TestSOCKS5(t *testing.T) {
+	proxyAddr := startProxy(t)
+	var port int
+	fmt.Sscan(startEcho(t), &port)
+
+	conn, status := socks5Connect(t, proxyAddr, "staging", port)
+	defer conn.Close()
+	require.Equal(t, byte(proxy.SOCKS5_SUCCEEDED), status)
+	requireEcho(t, conn)
+
+	// Hosts unknown to the PRP table are unreachable
+	conn2, status := socks5Connect(t, proxyAddr, "production", port)
+	defer conn2.Close()
+	require.Equal(t, byte(proxy.SOCKS5_HOST_UNREACH), status)
+}
+
+func TestHTTPConnect(t *testing.T) {
+	proxyAddr := startProxy(t)
+	port := startEcho(t)
+
+	conn, err := net.Dial("tcp", proxyAddr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "CONNECT staging:%s HTTP/1.1\r\nHost: staging:%s\r\n\r\n", port, port)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: http.MethodConnect})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	requireEcho(t, conn)
+}
+
+func TestHTTPProxy(t *testing.T) {
+	proxyAddr := startProxy(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello %s", r.URL.Path)
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	proxyURL, _ := url.Parse("http://" + proxyAddr)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	resp, err := client.Get(fmt.Sprintf("http://staging:%s/world", port))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "hello /world", string(body))
+
+	resp, err = client.Get(fmt.Sprintf("http://production:%s/", port))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadGateway, resp.StatusCode)
+}
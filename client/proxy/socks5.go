@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// SOCKS5 (RFC 1928) constants, only the CONNECT command without
+// authentication is supported
+const (
+	SOCKS5_NO_AUTH          = 0x00
+	SOCKS5_NO_ACCEPTABLE    = 0xff
+	SOCKS5_CMD_CONNECT      = 0x01
+	SOCKS5_ATYP_IPV4        = 0x01
+	SOCKS5_ATYP_DOMAIN      = 0x03
+	SOCKS5_ATYP_IPV6        = 0x04
+	SOCKS5_SUCCEEDED        = 0x00
+	SOCKS5_HOST_UNREACH     = 0x04
+	SOCKS5_CMD_UNSUPPORTED  = 0x07
+	SOCKS5_ATYP_UNSUPPORTED = 0x08
+)
+
+func (s *Server) serveSOCKS5(conn net.Conn, reader *bufio.Reader) error {
+	// Greeting: version, methods count and methods
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return err
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(reader, methods); err != nil {
+		return err
+	}
+
+	noAuth := false
+	for _, method := range methods {
+		if method == SOCKS5_NO_AUTH {
+			noAuth = true
+		}
+	}
+	if !noAuth {
+		conn.Write([]byte{SOCKS5_VERSION, SOCKS5_NO_ACCEPTABLE})
+		return fmt.Errorf("socks5 client needs authentication")
+	}
+	if _, err := conn.Write([]byte{SOCKS5_VERSION, SOCKS5_NO_AUTH}); err != nil {
+		return err
+	}
+
+	// Request: version, command, reserved, address type, address and port
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(reader, request); err != nil {
+		return err
+	}
+	if request[0] != SOCKS5_VERSION {
+		return fmt.Errorf("invalid socks version %d", request[0])
+	}
+
+	var host string
+	switch request[3] {
+	case SOCKS5_ATYP_IPV4, SOCKS5_ATYP_IPV6:
+		ip := make(net.IP, net.IPv4len)
+		if request[3] == SOCKS5_ATYP_IPV6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(reader, ip); err != nil {
+			return err
+		}
+		host = ip.String()
+	case SOCKS5_ATYP_DOMAIN:
+		length, err := reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		domain := make([]byte, length)
+		if _, err := io.ReadFull(reader, domain); err != nil {
+			return err
+		}
+		host = string(domain)
+	default:
+		socks5Reply(conn, SOCKS5_ATYP_UNSUPPORTED)
+		return fmt.Errorf("unsupported socks5 address type %d", request[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(reader, port); err != nil {
+		return err
+	}
+
+	if request[1] != SOCKS5_CMD_CONNECT {
+		socks5Reply(conn, SOCKS5_CMD_UNSUPPORTED)
+		return fmt.Errorf("unsupported socks5 command %d", request[1])
+	}
+
+	dst, err := s.dial(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	if err != nil {
+		socks5Reply(conn, SOCKS5_HOST_UNREACH)
+		return err
+	}
+	if err := socks5Reply(conn, SOCKS5_SUCCEEDED); err != nil {
+		dst.Close()
+		return err
+	}
+
+	pipe(conn, reader, dst)
+	return nil
+}
+
+// socks5Reply answers a request, the bound address is left empty
+func socks5Reply(conn net.Conn, status byte) error {
+	_, err := conn.Write([]byte{SOCKS5_VERSION, status, 0x00, SOCKS5_ATYP_IPV4, 0, 0, 0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,119 @@
+package vpn
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+
+	"github.com/gfleury/solo/client/protocol"
+)
+
+type ConnectionType int
+
+const (
+	ConnectionUnknown ConnectionType = iota
+	ConnectionDirect
+	ConnectionRelayed
+)
+
+func (c ConnectionType) String() string {
+	switch c {
+	case ConnectionDirect:
+		return "direct"
+	case ConnectionRelayed:
+		return "relayed"
+	}
+	return "unknown"
+}
+
+// ConnectionTypeOf tells if conn goes through a circuit relay or straight to the peer
+func ConnectionTypeOf(conn network.Conn) ConnectionType {
+	if conn == nil {
+		return ConnectionUnknown
+	}
+	if conn.Stat().Transient {
+		return ConnectionRelayed
+	}
+	if _, err := conn.RemoteMultiaddr().ValueForProtocol(multiaddr.P_CIRCUIT); err == nil {
+		return ConnectionRelayed
+	}
+	return ConnectionDirect
+}
+
+// ConnectionTypes reports the connection type used by the VPN stream of each peer
+func (v *VPNService) ConnectionTypes() map[peer.ID]ConnectionType {
+	connectionTypes := map[peer.ID]ConnectionType{}
+	if v.host == nil || v.vpnInterface == nil {
+		return connectionTypes
+	}
+
+	for _, peerID := range v.host.Network().Peers() {
+		soloStream, found := v.vpnInterface.streamMap.Get(v.vpnInterface.getOutboundStreamKey(peerID))
+		if !found {
+			continue
+		}
+		if stream, ok := soloStream.Stream.(network.Stream); ok {
+			connectionTypes[peerID] = ConnectionTypeOf(stream.Conn())
+		}
+	}
+	return connectionTypes
+}
+
+// upgradeNotifiee watches for new direct connections so relayed VPN streams
+// can be moved over them as soon as hole punching succeeds
+func (v *VPNService) upgradeNotifiee() network.Notifiee {
+	return &network.NotifyBundle{
+		ConnectedF: func(_ network.Network, conn network.Conn) {
+			if ConnectionTypeOf(conn) != ConnectionDirect {
+				return
+			}
+			go v.upgradeStream(conn.RemotePeer())
+		},
+	}
+}
+
+// upgradeStream re-opens the outbound VPN stream to dstID over a direct
+// connection, the noise session is reused so the receiver keeps decrypting
+// without a new handshake
+func (v *VPNService) upgradeStream(dstID peer.ID) {
+	streamKey := v.vpnInterface.getOutboundStreamKey(dstID)
+	soloStream, found := v.vpnInterface.streamMap.Get(streamKey)
+	if !found || soloStream.NoiseStream == nil {
+		return
+	}
+	oldStream, ok := soloStream.Stream.(network.Stream)
+	if !ok || ConnectionTypeOf(oldStream.Conn()) != ConnectionRelayed {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), v.timeout)
+	defer cancel()
+
+	// Without WithUseTransient libp2p only opens the stream over a direct connection
+	stream, err := v.host.NewStream(ctx, dstID, protocol.ALLEIN.ID())
+	if err != nil {
+		v.logger.Debugf("Could not upgrade VPN stream to %s: %s", dstID, err)
+		return
+	}
+
+	// Both streams share the noise nonces, so the peer must have read all
+	// the old stream before anything is sent on the new one. The writes are
+	// held while our side of the old stream is closed and the peer closes
+	// its own once it read everything
+	lock := v.vpnInterface.streamLock(streamKey)
+	lock.Lock()
+	oldStream.CloseWrite()
+	oldStream.SetReadDeadline(time.Now().Add(v.timeout))
+	if _, err := io.Copy(io.Discard, oldStream); err != nil {
+		v.logger.Debugf("VPN stream to %s not drained: %s", dstID, err)
+	}
+	v.vpnInterface.streamMap.Replace(streamKey, stream)
+	lock.Unlock()
+	oldStream.Close()
+
+	v.logger.Infof("VPN stream to %s upgraded from %s to %s connection", dstID, ConnectionRelayed, ConnectionTypeOf(stream.Conn()))
+}
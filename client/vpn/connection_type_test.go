@@ -0,0 +1,122 @@
+package vpn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/gfleury/solo/client/broadcast"
+	"github.com/gfleury/solo/client/crypto/noise"
+	"github.com/gfleury/solo/client/logger"
+	"github.com/gfleury/solo/client/utils"
+	"github.com/gfleury/solo/client/vpn/stream_map"
+)
+
+type ConnectionTypeTestSuite struct {
+	suite.Suite
+}
+
+func TestConnectionTypeTestSuite(t *testing.T) {
+	suite.Run(t, new(ConnectionTypeTestSuite))
+}
+
+func (s *ConnectionTypeTestSuite) TestConnectionTypeOf() {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFunc()
+
+	h1, _ := NewTestHost("0")
+	h2, _ := NewTestHost("0")
+
+	err := TestConnectHosts(ctx, h1, h2)
+	s.NoError(err)
+
+	conns := h1.Network().ConnsToPeer(h2.ID())
+	s.NotEmpty(conns)
+	for _, conn := range conns {
+		s.Equal(ConnectionDirect, ConnectionTypeOf(conn))
+	}
+
+	s.Equal(ConnectionUnknown, ConnectionTypeOf(nil))
+	s.Equal("relayed", ConnectionRelayed.String())
+}
+
+func (s *ConnectionTypeTestSuite) TestStreamReplaceKeepsNoise() {
+	streamMap := stream_map.NewNoiseStreamMap()
+	oldStream, newStream := utils.NewTestConnection()
+
+	noiseStream := &noise.NoiseStreamInitiator{}
+	streamMap.NewWithNoise("key", oldStream, noiseStream)
+	streamMap.Replace("key", newStream)
+
+	soloStream, found := streamMap.Get("key")
+	s.True(found)
+	s.Equal(newStream, soloStream.Stream)
+	s.Equal(noiseStream, soloStream.NoiseStream)
+
+	// The old stream handler finishing must not drop the migrated stream
+	streamMap.DeleteStream("key", oldStream)
+	_, found = streamMap.Get("key")
+	s.True(found)
+
+	streamMap.DeleteStream("key", newStream)
+	_, found = streamMap.Get("key")
+	s.False(found)
+}
+
+func (s *ConnectionTypeTestSuite) TestUpgradeStream() {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancelFunc()
+
+	hr, err := NewTestHost("0", libp2p.EnableRelayService(), libp2p.ForceReachabilityPublic())
+	s.Require().NoError(err)
+	h1, err := NewTestHost("0")
+	s.Require().NoError(err)
+	h2, err := NewTestHost("0")
+	s.Require().NoError(err)
+
+	// h2 is only reachable through the relay
+	relayInfo := peer.AddrInfo{ID: hr.ID(), Addrs: hr.Addrs()}
+	s.Require().NoError(h2.Connect(ctx, relayInfo))
+	_, err = client.Reserve(ctx, h2, relayInfo)
+	s.Require().NoError(err)
+	circuit, err := multiaddr.NewMultiaddr("/p2p/" + hr.ID().String() + "/p2p-circuit")
+	s.Require().NoError(err)
+	s.Require().NoError(h1.Connect(ctx, relayInfo))
+	s.Require().NoError(h1.Connect(ctx, peer.AddrInfo{ID: h2.ID(), Addrs: []multiaddr.Multiaddr{circuit}}))
+
+	dummyBroadcast := broadcast.NewDummyBroadcast()
+	dummyBroadcast.AddFakePeer("10.1.0.1", h1.ID())
+	dummyBroadcast.AddFakePeer("10.1.0.2", h2.ID())
+
+	iface1, iface2 := NewTestPacketBuffer(), NewTestPacketBuffer()
+	vpn1 := newTestService(h1, iface1, false)
+	vpn2 := newTestService(h2, iface2, false)
+
+	l := logger.New(log.LevelDebug)
+	s.Require().NoError(vpn1.Run(ctx, l, h1, dummyBroadcast))
+	s.Require().NoError(vpn2.Run(ctx, l, h2, dummyBroadcast))
+
+	packet := newIPv4Packet("10.1.0.1", "10.1.0.2", 17, make([]byte, 100), false)
+	s.Require().NoError(vpn1.handlePacket(packet))
+	s.Equal(ConnectionRelayed, vpn1.ConnectionTypes()[h2.ID()])
+
+	// A direct connection shows up, the stream moves over it
+	h1.Peerstore().AddAddrs(h2.ID(), h2.Addrs(), time.Hour)
+	_, err = h1.Network().DialPeer(network.WithForceDirectDial(ctx, "test"), h2.ID())
+	s.Require().NoError(err)
+	s.Eventually(func() bool { return vpn1.ConnectionTypes()[h2.ID()] == ConnectionDirect }, 10*time.Second, 10*time.Millisecond)
+
+	// The noise session carries on over the new stream
+	for i := 0; i < 10; i++ {
+		s.Require().NoError(vpn1.handlePacket(packet))
+	}
+	s.Eventually(func() bool { return iface2.MyPacketsLen() == 11*len(packet) }, 10*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,102 @@
+package vpn
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// FLOOD_RATE is the packets per second flooded to all the peers, the
+	// broadcasts and multicasts beyond it are dropped
+	FLOOD_RATE = 200
+	// FLOOD_BURST is the packets flooded at once above FLOOD_RATE
+	FLOOD_BURST = 400
+)
+
+// isFlooded tells if dst is a multicast, the limited broadcast or our subnet
+// broadcast address, sent to all the peers
+func (v *VPNService) isFlooded(dst net.IP) bool {
+	if dst.IsMulticast() || dst.Equal(net.IPv4bcast) {
+		return true
+	}
+	return v.subnetBroadcast != nil && dst.Equal(v.subnetBroadcast)
+}
+
+// subnetBroadcast returns the broadcast address of the IPv4 cidr, nil for
+// IPv6 and host addresses
+func subnetBroadcast(cidr string) net.IP {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil
+	}
+	ip := ipnet.IP.To4()
+	ones, bits := ipnet.Mask.Size()
+	if ip == nil || bits-ones < 2 {
+		return nil
+	}
+
+	broadcast := make(net.IP, net.IPv4len)
+	for i := range ip {
+		broadcast[i] = ip[i] | ^ipnet.Mask[i]
+	}
+	return broadcast
+}
+
+// flood replicates the packet to every peer of the network, up to
+// FLOOD_RATE. Each peer is sent to aside with its own timeout, so an offline
+// peer holds neither the others nor the interface, and a peer still busy
+// with a previous packet misses this one. Like unicast the peers that
+// refused our traffic are skipped, as are packets larger than the path MTU,
+// broadcasts and multicasts are never answered with ICMP errors
+func (v *VPNService) flood(packet Packet) error {
+	table := v.broadcast.Table()
+	if table == nil {
+		return fmt.Errorf("flooding needs a PRP table to find the peers")
+	}
+
+	if !v.floodLimiter.Allow() {
+		v.logger.Debugf("Flood rate exceeded, dropping packet")
+		return nil
+	}
+
+	flooded := map[string]bool{v.host.ID().String(): true}
+	for _, machine := range table.Routes() {
+		if flooded[machine.PeerID] {
+			continue
+		}
+		flooded[machine.PeerID] = true
+
+		dstID, err := peer.Decode(machine.PeerID)
+		if err != nil || v.isRefused(dstID) {
+			continue
+		}
+		if !v.Config.TAP && len(packet) > v.vpnInterface.pathMTU(dstID) {
+			continue
+		}
+		if _, busy := v.flooding.LoadOrStore(dstID, true); busy {
+			continue
+		}
+		go v.floodTo(dstID, packet)
+	}
+	return nil
+}
+
+// floodTo sends a flooded packet to dstID within v.timeout
+func (v *VPNService) floodTo(dstID peer.ID, packet Packet) {
+	defer v.flooding.Delete(dstID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), v.timeout)
+	defer cancel()
+
+	err := v.vpnInterface.handlePacket(ctx, dstID, packet)
+	if refused(err) {
+		v.refusedPeers.Store(dstID, time.Now())
+	}
+	if err != nil {
+		v.logger.Debugf("Failed to flood packet to %s: %s", dstID, err)
+	}
+}
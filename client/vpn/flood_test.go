@@ -0,0 +1,79 @@
+package vpn
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-log/v2"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+
+	"github.com/gfleury/solo/client/broadcast"
+	"github.com/gfleury/solo/client/broadcast/prp"
+	"github.com/gfleury/solo/client/logger"
+	"github.com/gfleury/solo/common/models"
+)
+
+func TestSubnetBroadcast(t *testing.T) {
+	require.Equal(t, net.ParseIP("10.1.0.255").To4(), subnetBroadcast("10.1.0.1/24"))
+	require.Equal(t, net.ParseIP("10.1.255.255").To4(), subnetBroadcast("10.1.0.1/16"))
+	require.Nil(t, subnetBroadcast("10.1.0.1/32"))
+	require.Nil(t, subnetBroadcast("fd00::1/64"))
+	require.Nil(t, subnetBroadcast("10.1.0.1"))
+
+	v := &VPNService{subnetBroadcast: subnetBroadcast("10.1.0.1/24")}
+	for _, ip := range []string{"224.0.0.251", "239.255.255.250", "255.255.255.255", "10.1.0.255", "ff02::fb"} {
+		require.True(t, v.isFlooded(net.ParseIP(ip)), ip)
+	}
+	for _, ip := range []string{"10.1.0.2", "10.1.1.255", "fd00::1"} {
+		require.False(t, v.isFlooded(net.ParseIP(ip)), ip)
+	}
+}
+
+func TestMulticastFlooding(t *testing.T) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancelFunc()
+
+	h1, err := NewTestHost("0")
+	require.NoError(t, err)
+	h2, err := NewTestHost("0")
+	require.NoError(t, err)
+	require.NoError(t, TestConnectHosts(ctx, h1, h2))
+
+	table1, table2 := prp.NewPRPTable(), prp.NewPRPTable()
+	table1.InsertMyselfEntry(&models.NetworkNode{PeerID: h1.ID().String(), IP: "10.0.0.1"})
+	table2.InsertMyselfEntry(&models.NetworkNode{PeerID: h2.ID().String(), IP: "10.0.0.2"})
+	announce(t, table1, h2, "10.0.0.2")
+
+	// An offline peer doesn't hold the others
+	h3, err := NewTestHost("0")
+	require.NoError(t, err)
+	announce(t, table1, h3, "10.0.0.3")
+	require.NoError(t, h3.Close())
+
+	iface1, iface2 := NewTestPacketBuffer(), NewTestPacketBuffer()
+	vpn1 := newTestService(h1, iface1, false)
+	vpn2 := newTestService(h2, iface2, false)
+
+	l := logger.New(log.LevelDebug)
+	require.NoError(t, vpn1.Run(ctx, l, h1, &tableBroadcast{broadcast.NewDummyBroadcast(), table1}))
+	require.NoError(t, vpn2.Run(ctx, l, h2, &tableBroadcast{broadcast.NewDummyBroadcast(), table2}))
+
+	// mDNS is replicated to the peers instead of looked up
+	mdns := tuntest.Ping(netip.MustParseAddr("224.0.0.251"), netip.MustParseAddr("10.0.0.1"))
+	_, err = (&TestPacketBufferFeed{t: iface1}).Write(mdns)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return iface2.MyPacketsLen() >= len(mdns) }, 10*time.Second, 10*time.Millisecond)
+
+	iface2.Lock()
+	require.Equal(t, mdns, iface2.myPackets)
+	iface2.Unlock()
+
+	// Peers refusing our traffic are skipped
+	vpn1.refusedPeers.Store(h2.ID(), time.Now())
+	require.NoError(t, vpn1.flood(mdns))
+	require.Never(t, func() bool { return iface2.MyPacketsLen() > len(mdns) }, 500*time.Millisecond, 10*time.Millisecond)
+}
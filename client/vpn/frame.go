@@ -0,0 +1,96 @@
+package vpn
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/mudler/water/waterutil"
+
+	"github.com/gfleury/solo/client/broadcast/metapacket"
+)
+
+const (
+	// ETHERNET_HEADER_SIZE is the untagged Ethernet header, destination, source and ethertype
+	ETHERNET_HEADER_SIZE = 14
+	// ETHERNET_MAX_HEADER_SIZE allows a VLAN tag on the frames read from the TAP interface
+	ETHERNET_MAX_HEADER_SIZE = ETHERNET_HEADER_SIZE + 4
+	// MAC_ANNOUNCE_DELAY batches the MACs learned together in one announce
+	MAC_ANNOUNCE_DELAY = time.Second
+)
+
+// Frame is an Ethernet frame of a TAP interface
+type Frame []byte
+
+func (f Frame) DstMAC() net.HardwareAddr {
+	return waterutil.MACDestination(f)
+}
+
+func (f Frame) SrcMAC() net.HardwareAddr {
+	return waterutil.MACSource(f)
+}
+
+// IsFlooded tells if the frame goes to all the peers, broadcast and multicast
+// destinations have the group bit set
+func (f Frame) IsFlooded() bool {
+	return f.DstMAC()[0]&0x01 != 0
+}
+
+// handleFrame switches a frame read from the TAP interface to the peer its
+// destination MAC is behind, like a learning bridge, broadcast, multicast and
+// unknown destinations are flooded to all the peers
+func (v *VPNService) handleFrame(ctx context.Context, frame Frame) error {
+	if len(frame) < ETHERNET_HEADER_SIZE {
+		return fmt.Errorf("frame size is less than the ethernet header")
+	}
+
+	table := v.broadcast.Table()
+	if table == nil {
+		return fmt.Errorf("TAP interfaces need a PRP table to learn the MACs")
+	}
+
+	if table.LearnLocalMAC(frame.SrcMAC().String()) {
+		v.announceMACs()
+	}
+
+	if !frame.IsFlooded() {
+		if peerID, found := table.LookupMAC(frame.DstMAC().String()); found {
+			// Behind our own interface, already delivered
+			if peerID == v.host.ID().String() {
+				return nil
+			}
+			dstID, err := peer.Decode(peerID)
+			if err != nil {
+				return fmt.Errorf("could not decode peer: %w", err)
+			}
+			return v.vpnInterface.handlePacket(ctx, dstID, Packet(frame))
+		}
+	}
+
+	return v.flood(Packet(frame))
+}
+
+// announceMACs announces our local MACs after MAC_ANNOUNCE_DELAY, the MACs
+// learned meanwhile go in the same announce
+func (v *VPNService) announceMACs() {
+	if !v.macAnnounce.CompareAndSwap(false, true) {
+		return
+	}
+
+	go func() {
+		time.Sleep(MAC_ANNOUNCE_DELAY)
+		v.macAnnounce.Store(false)
+
+		payload := v.broadcast.Table().PRPMACAnnounce()
+		if payload == nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), v.timeout)
+		defer cancel()
+		if err := v.broadcast.SendPacket(ctx, metapacket.NewFromPayload(payload)); err != nil {
+			v.logger.Debugf("Failed to announce our MACs: %s", err)
+		}
+	}()
+}
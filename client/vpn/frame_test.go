@@ -0,0 +1,124 @@
+package vpn
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/mudler/water"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gfleury/solo/client/broadcast"
+	"github.com/gfleury/solo/client/broadcast/prp"
+	"github.com/gfleury/solo/client/logger"
+	"github.com/gfleury/solo/client/vpn/stream_map"
+	"github.com/gfleury/solo/common/models"
+)
+
+// tableBroadcast is a DummyBroadcast looking up the routes and MACs in a PRP table
+type tableBroadcast struct {
+	*broadcast.DummyBroadcast
+	table *prp.PRPTableType
+}
+
+func (b *tableBroadcast) Lookup(dstIP string) (*models.NetworkNode, bool, bool) {
+	return b.table.Lookup(dstIP)
+}
+
+func (b *tableBroadcast) Table() *prp.PRPTableType {
+	return b.table
+}
+
+func newTestService(h host.Host, iface io.ReadWriteCloser, tap bool) *VPNService {
+	config := InterfaceConfig{InterfaceMTU: 1420, TAP: tap}
+	streamMap := stream_map.NewNoiseStreamMap()
+	return &VPNService{
+		Config: config,
+		vpnInterface: &VPNInterface{
+			networkInterface: &water.Interface{ReadWriteCloser: iface},
+			config:           &config,
+			buffer:           bytes.NewBuffer(make([]byte, 0)),
+			streamMap:        streamMap,
+			chain:            &PacketNoisy{streamMap: streamMap},
+			host:             NewWrapperHost(h),
+		},
+		timeout: 2 * time.Second,
+	}
+}
+
+// announce inserts the signed PRPReply of h into table
+func announce(t *testing.T, table *prp.PRPTableType, h host.Host, ip string) {
+	p := &prp.PRPacket{PRPType: prp.PRPReply, IP: ip, Machine: models.NetworkNode{PeerID: h.ID().String(), IP: ip}}
+	require.NoError(t, p.Sign(h.Peerstore().PrivKey(h.ID())))
+	_, err := p.Process(logger.New(log.LevelDebug), table)
+	require.NoError(t, err)
+}
+
+func newFrame(dst, src string) Packet {
+	frame := make(Packet, 64)
+	dstMAC, _ := net.ParseMAC(dst)
+	srcMAC, _ := net.ParseMAC(src)
+	copy(frame, dstMAC)
+	copy(frame[6:], srcMAC)
+	// IPv4 ethertype
+	frame[12] = 0x08
+	return frame
+}
+
+func TestTAPFrameSwitching(t *testing.T) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancelFunc()
+
+	h1, err := NewTestHost("0")
+	require.NoError(t, err)
+	h2, err := NewTestHost("0")
+	require.NoError(t, err)
+	require.NoError(t, TestConnectHosts(ctx, h1, h2))
+
+	table1, table2 := prp.NewPRPTable(), prp.NewPRPTable()
+	table1.InsertMyselfEntry(&models.NetworkNode{PeerID: h1.ID().String(), IP: "10.0.0.1"})
+	table2.InsertMyselfEntry(&models.NetworkNode{PeerID: h2.ID().String(), IP: "10.0.0.2"})
+	announce(t, table1, h2, "10.0.0.2")
+	announce(t, table2, h1, "10.0.0.1")
+
+	iface1, iface2 := NewTestPacketBuffer(), NewTestPacketBuffer()
+	vpn1 := newTestService(h1, iface1, true)
+	vpn2 := newTestService(h2, iface2, true)
+
+	l := logger.New(log.LevelDebug)
+	require.NoError(t, vpn1.Run(ctx, l, h1, &tableBroadcast{broadcast.NewDummyBroadcast(), table1}))
+	require.NoError(t, vpn2.Run(ctx, l, h2, &tableBroadcast{broadcast.NewDummyBroadcast(), table2}))
+
+	macA, macB := "02:00:00:00:00:0a", "02:00:00:00:00:0b"
+
+	// Broadcasts are flooded, the remote peer learns where the source is
+	broadcastFrame := newFrame("ff:ff:ff:ff:ff:ff", macA)
+	_, err = (&TestPacketBufferFeed{t: iface1}).Write(broadcastFrame)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return iface2.MyPacketsLen() >= len(broadcastFrame) }, 10*time.Second, 10*time.Millisecond)
+
+	peerID, found := table1.LookupMAC(macA)
+	require.True(t, found)
+	require.Equal(t, h1.ID().String(), peerID)
+	peerID, found = table2.LookupMAC(macA)
+	require.True(t, found)
+	require.Equal(t, h1.ID().String(), peerID)
+
+	// The reply is switched to the learned peer
+	replyFrame := newFrame(macA, macB)
+	_, err = (&TestPacketBufferFeed{t: iface2}).Write(replyFrame)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return iface1.MyPacketsLen() >= len(replyFrame) }, 10*time.Second, 10*time.Millisecond)
+
+	iface1.Lock()
+	require.Equal(t, []byte(replyFrame), iface1.myPackets)
+	iface1.Unlock()
+	peerID, found = table1.LookupMAC(macB)
+	require.True(t, found)
+	require.Equal(t, h2.ID().String(), peerID)
+}
@@ -0,0 +1,163 @@
+package vpn
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// ICMP and ICMPv6 errors written back into the interface
+const (
+	ICMP_DEST_UNREACHABLE = 3
+	ICMP_HOST_UNREACHABLE = 1
+	// ICMP_FRAG_NEEDED is the destination unreachable code of too big packets with DF set
+	ICMP_FRAG_NEEDED      = 4
+	ICMP_ADMIN_PROHIBITED = 13
+
+	ICMPV6_DEST_UNREACHABLE    = 1
+	ICMPV6_ADMIN_PROHIBITED    = 1
+	ICMPV6_ADDRESS_UNREACHABLE = 3
+	ICMPV6_PACKET_TOO_BIG      = 2
+
+	// ICMP_MAX_SIZE bounds the errors, 576 bytes for IPv4 and the minimum MTU for IPv6
+	ICMP_MAX_SIZE   = 576
+	ICMPV6_MAX_SIZE = 1280
+
+	ICMP_RATE  = 100
+	ICMP_BURST = 100
+)
+
+const (
+	ipProtocolICMP   = 1
+	ipProtocolTCP    = 6
+	ipProtocolICMPv6 = 58
+)
+
+// icmpErrorAllowed tells if an error can be sent about the packet, never
+// about ICMP errors, multicasts, broadcasts or non-first fragments
+func icmpErrorAllowed(packet Packet) bool {
+	switch packet.IpVersion() {
+	case 4:
+		if len(packet) < 20 || len(packet) < int(packet[0]&0x0f)*4 {
+			return false
+		}
+		dst := net.IP(packet[16:20])
+		if dst.IsMulticast() || dst.Equal(net.IPv4bcast) {
+			return false
+		}
+		if binary.BigEndian.Uint16(packet[6:])&0x1fff != 0 {
+			return false
+		}
+		if packet[9] == ipProtocolICMP {
+			ihl := int(packet[0]&0x0f) * 4
+			// Only echo requests and replies are informational
+			return len(packet) > ihl && (packet[ihl] == 0 || packet[ihl] == 8)
+		}
+		return true
+	case 6:
+		if len(packet) < 40 || net.IP(packet[24:40]).IsMulticast() {
+			return false
+		}
+		if packet[6] == ipProtocolICMPv6 {
+			// ICMPv6 errors are the types below 128
+			return len(packet) > 40 && packet[40] >= 128
+		}
+		return true
+	}
+	return false
+}
+
+// icmpError returns the ICMP, or ICMPv6, error about packet sent from src to
+// the packet source, rest is the 4 bytes after the checksum, quoting as
+// much of the packet as fits
+func icmpError(src net.IP, packet Packet, icmpType, code uint8, rest uint32) Packet {
+	if packet.IpVersion() == 6 {
+		return icmpv6Error(src, packet, icmpType, code, rest)
+	}
+
+	quote := packet
+	if len(quote) > ICMP_MAX_SIZE-28 {
+		quote = quote[:ICMP_MAX_SIZE-28]
+	}
+
+	reply := make(Packet, 28+len(quote))
+	reply[0] = 0x45
+	binary.BigEndian.PutUint16(reply[2:], uint16(len(reply)))
+	reply[8] = 64
+	reply[9] = ipProtocolICMP
+	copy(reply[12:16], src.To4())
+	copy(reply[16:20], packet[12:16])
+	binary.BigEndian.PutUint16(reply[10:], checksum(reply[:20], 0))
+
+	icmp := reply[20:]
+	icmp[0] = icmpType
+	icmp[1] = code
+	binary.BigEndian.PutUint32(icmp[4:], rest)
+	copy(icmp[8:], quote)
+	binary.BigEndian.PutUint16(icmp[2:], checksum(icmp, 0))
+	return reply
+}
+
+func icmpv6Error(src net.IP, packet Packet, icmpType, code uint8, rest uint32) Packet {
+	quote := packet
+	if len(quote) > ICMPV6_MAX_SIZE-48 {
+		quote = quote[:ICMPV6_MAX_SIZE-48]
+	}
+
+	reply := make(Packet, 48+len(quote))
+	reply[0] = 0x60
+	binary.BigEndian.PutUint16(reply[4:], uint16(8+len(quote)))
+	reply[6] = ipProtocolICMPv6
+	reply[7] = 64
+	copy(reply[8:24], src.To16())
+	copy(reply[24:40], packet[8:24])
+
+	icmp := reply[40:]
+	icmp[0] = icmpType
+	icmp[1] = code
+	binary.BigEndian.PutUint32(icmp[4:], rest)
+	copy(icmp[8:], quote)
+
+	// The checksum covers the pseudo header, addresses, length and next header
+	pseudo := make([]byte, 40)
+	copy(pseudo, reply[8:40])
+	binary.BigEndian.PutUint32(pseudo[32:], uint32(len(icmp)))
+	pseudo[39] = ipProtocolICMPv6
+	binary.BigEndian.PutUint16(icmp[2:], checksum(icmp, sum(pseudo, 0)))
+	return reply
+}
+
+// sum adds b as 16 bits big endian words to initial, the checksum is not folded
+func sum(b []byte, initial uint32) uint32 {
+	s := initial
+	for i := 0; i+1 < len(b); i += 2 {
+		s += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		s += uint32(b[len(b)-1]) << 8
+	}
+	return s
+}
+
+// checksum is the internet checksum of b, over the initial sum
+func checksum(b []byte, initial uint32) uint16 {
+	s := sum(b, initial)
+	for s>>16 != 0 {
+		s = (s & 0xffff) + (s >> 16)
+	}
+	return ^uint16(s)
+}
+
+// writeICMPError writes an ICMP error about packet back into the interface,
+// up to ICMP_RATE per second. The error comes from the packet destination,
+// the kernel drops the packets coming in with one of its own addresses
+func (v *VPNService) writeICMPError(packet Packet, icmpType, code uint8, rest uint32) error {
+	if !icmpErrorAllowed(packet) || !v.icmpLimiter.Allow() {
+		return nil
+	}
+	src, err := packet.DstIp()
+	if err != nil {
+		return err
+	}
+	_, err = v.vpnInterface.writeToNetworkInterface(icmpError(src, packet, icmpType, code, rest))
+	return err
+}
@@ -37,7 +37,7 @@ func newMemoryBuffer() *memoryBuffer {
 func (i *VPNInterface) createInterface(createInterface bool) error {
 	var err error
 	config := water.Config{
-		DeviceType: water.TUN,
+		DeviceType: i.deviceType(),
 	}
 	config.Name = i.config.InterfaceName
 
@@ -56,12 +56,20 @@ func (i *VPNInterface) prepareInterface() error {
 		return err
 	}
 
-	addr, err := netlink.ParseAddr(i.config.InterfaceAddress)
+	err = netlink.LinkSetMTU(link, i.config.InterfaceMTU)
 	if err != nil {
 		return err
 	}
 
-	err = netlink.LinkSetMTU(link, i.config.InterfaceMTU)
+	// A bridged TAP interface is only a bridge port, the bridge has the address
+	if i.config.Bridge != "" {
+		if err := netlink.LinkSetMaster(link, i.config.Bridge); err != nil {
+			return err
+		}
+		return netlink.LinkSetUp(link)
+	}
+
+	addr, err := netlink.ParseAddr(i.config.InterfaceAddress)
 	if err != nil {
 		return err
 	}
@@ -19,6 +19,7 @@ package vpn
 
 import (
 	"bytes"
+	"fmt"
 	"net/netip"
 	"time"
 
@@ -54,7 +55,7 @@ func newMemoryBuffer() *memoryBuffer {
 func (i *VPNInterface) createInterface(createInterface bool) error {
 	var err error
 	config := water.Config{
-		DeviceType: water.TUN,
+		DeviceType: i.deviceType(),
 	}
 	config.Name = i.config.InterfaceName
 
@@ -67,6 +68,10 @@ func (i *VPNInterface) createInterface(createInterface bool) error {
 }
 
 func (i *VPNInterface) prepareInterface() error {
+	if i.config.Bridge != "" {
+		return fmt.Errorf("bridging the TAP interface is not supported on windows")
+	}
+
 	// find interface created by water
 	guid, err := windows.GUIDFromString("{00000000-FFFF-FFFF-FFE9-76E58C74063E}")
 	if err != nil {
@@ -0,0 +1,212 @@
+package vpn
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+
+	"github.com/gfleury/solo/client/crypto/noise"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// MTU_OVERHEAD is what a packet grows on the wire: the VPN header, the
+	// noise tag, the gzip framing, the libp2p noise and yamux framing and the
+	// TCP and IPv6 headers
+	MTU_OVERHEAD = HEADER_SIZE + 16 + 23 + 18 + 12 + 60
+	// MIN_INTERFACE_MTU is the smallest MTU selected, the IPv6 minimum
+	MIN_INTERFACE_MTU = 1280
+	// DEFAULT_LINK_MTU is assumed when no link of the host is up
+	DEFAULT_LINK_MTU = 1500
+	// MTU_REPLY_TIMEOUT bounds the wait for the MTU of the receiver, peers
+	// without it never answer
+	MTU_REPLY_TIMEOUT = 5 * time.Second
+)
+
+// AutoMTU returns the interface MTU fitting the host links, the smallest MTU
+// of the up links minus MTU_OVERHEAD, our own interface name is skipped
+func AutoMTU(name string) int {
+	linkMTU := 0
+	interfaces, _ := net.Interfaces()
+	for _, iface := range interfaces {
+		if iface.Name == name || iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || iface.MTU <= 0 {
+			continue
+		}
+		if addrs, err := iface.Addrs(); err != nil || len(addrs) == 0 {
+			continue
+		}
+		if linkMTU == 0 || iface.MTU < linkMTU {
+			linkMTU = iface.MTU
+		}
+	}
+	if linkMTU == 0 {
+		linkMTU = DEFAULT_LINK_MTU
+	}
+
+	if linkMTU-MTU_OVERHEAD < MIN_INTERFACE_MTU {
+		return MIN_INTERFACE_MTU
+	}
+	return linkMTU - MTU_OVERHEAD
+}
+
+// Packets travel on libp2p streams, segmented by the transports, so probing
+// with padded packets tells nothing, the path MTU to a peer is the smallest
+// of both interface MTUs. Each side announces its own right after the noise
+// handshake, the initiator with a VPN_MTU packet on its stream and the
+// receiver with one back on the same stream
+
+// mtuPayload is our interface MTU as announced
+func (v *VPNInterface) mtuPayload() Packet {
+	mtu := make(Packet, 2)
+	binary.BigEndian.PutUint16(mtu, uint16(v.config.InterfaceMTU))
+	return mtu
+}
+
+// writeMTU announces our interface MTU on a new stream to dstID
+func (v *VPNInterface) writeMTU(stream io.Writer, dstID peer.ID) error {
+	_, err := io.Copy(stream, v.OutboundChain(NewVPNPacket(VPN_MTU, v.mtuPayload(), []byte(dstID), []byte(v.host.ID()))))
+	return err
+}
+
+// replyMTU announces our interface MTU back on the stream opened by dstID,
+// sealed by the noise session of its handshake
+func (v *VPNInterface) replyMTU(stream io.Writer, noiseStream noise.NoiseStream, dstID peer.ID) error {
+	sealed, err := noiseStream.Encrypt(v.mtuPayload())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(stream, NewVPNPacket(VPN_MTU, sealed, []byte(dstID), []byte(v.host.ID())))
+	return err
+}
+
+// readReplyMTU records the interface MTU dstID announces back on our stream,
+// within MTU_REPLY_TIMEOUT. Older peers don't announce it, pathMTU then
+// falls back to ours
+func (v *VPNInterface) readReplyMTU(stream io.Reader, noiseStream noise.NoiseStream, dstID peer.ID) {
+	// Our stream is not read after it, the deadline is left as is
+	if s, ok := stream.(interface{ SetReadDeadline(time.Time) error }); ok {
+		s.SetReadDeadline(time.Now().Add(MTU_REPLY_TIMEOUT))
+	}
+
+	vpnPacket, err := readVPNPacket(stream, v.maxPacketSize())
+	if err != nil || vpnPacket.header.Type != VPN_MTU.Uint8() {
+		return
+	}
+	mtu, err := noiseStream.Decrypt(vpnPacket.networkPacket)
+	if err != nil {
+		return
+	}
+	v.readMTU(dstID, mtu)
+}
+
+// readMTU records the interface MTU announced by srcID, MTUs below
+// MIN_INTERFACE_MTU are ignored
+func (v *VPNInterface) readMTU(srcID peer.ID, mtu Packet) {
+	if len(mtu) < 2 || int(binary.BigEndian.Uint16(mtu)) < MIN_INTERFACE_MTU {
+		return
+	}
+	v.peerMTUs.Store(srcID, int(binary.BigEndian.Uint16(mtu)))
+}
+
+// pathMTU returns the largest packet exchanged with peerID
+func (v *VPNInterface) pathMTU(peerID peer.ID) int {
+	if mtu, found := v.peerMTUs.Load(peerID); found && mtu.(int) < v.config.InterfaceMTU {
+		return mtu.(int)
+	}
+	return v.config.InterfaceMTU
+}
+
+// clampMSS lowers, in place, the MSS option of a TCP SYN to fit mtu, for the
+// hosts behind the local routes whose links have a larger MTU
+func clampMSS(packet Packet, mtu int) {
+	var tcp []byte
+	var headers int
+	switch packet.IpVersion() {
+	case 4:
+		if len(packet) < 20 {
+			return
+		}
+		ihl := int(packet[0]&0x0f) * 4
+		if packet[9] != ipProtocolTCP || binary.BigEndian.Uint16(packet[6:])&0x1fff != 0 || len(packet) < ihl+20 {
+			return
+		}
+		tcp, headers = packet[ihl:], 40
+	case 6:
+		// TCP right after the header, SYNs don't carry extension headers
+		if len(packet) < 60 || packet[6] != ipProtocolTCP {
+			return
+		}
+		tcp, headers = packet[40:], 60
+	default:
+		return
+	}
+
+	// SYN flag
+	if tcp[13]&0x02 == 0 {
+		return
+	}
+	dataOffset := int(tcp[12]>>4) * 4
+	if dataOffset < 20 || len(tcp) < dataOffset {
+		return
+	}
+
+	maxMSS := uint16(mtu - headers)
+	options := tcp[20:dataOffset]
+	for i := 0; i < len(options); {
+		switch options[i] {
+		case 0:
+			// End of options
+			return
+		case 1:
+			// No operation
+			i++
+			continue
+		}
+		if i+1 >= len(options) || options[i+1] < 2 || i+int(options[i+1]) > len(options) {
+			return
+		}
+		if options[i] == 2 && options[i+1] == 4 {
+			mss := binary.BigEndian.Uint16(options[i+2:])
+			if mss > maxMSS {
+				binary.BigEndian.PutUint16(options[i+2:], maxMSS)
+				binary.BigEndian.PutUint16(tcp[16:], checksumUpdate(binary.BigEndian.Uint16(tcp[16:]), mss, maxMSS))
+			}
+			return
+		}
+		i += int(options[i+1])
+	}
+}
+
+// checksumUpdate returns the checksum after a 16 bits word changed, RFC 1624
+func checksumUpdate(sum, old, new uint16) uint16 {
+	s := uint32(^sum) + uint32(^old) + uint32(new)
+	s = (s & 0xffff) + (s >> 16)
+	s = (s & 0xffff) + (s >> 16)
+	return ^uint16(s)
+}
+
+// tooBig answers a packet larger than mtu with a "fragmentation needed", or
+// ICMPv6 "packet too big", error into the interface, it returns false if the
+// packet can still be sent, IPv4 without the DF flag
+func (v *VPNService) tooBig(packet Packet, mtu int) bool {
+	if len(packet) <= mtu {
+		return false
+	}
+
+	switch packet.IpVersion() {
+	case 4:
+		// Don't fragment flag
+		if packet[6]&0x40 == 0 {
+			return false
+		}
+		if err := v.writeICMPError(packet, ICMP_DEST_UNREACHABLE, ICMP_FRAG_NEEDED, uint32(mtu)); err != nil {
+			v.logger.Debugf("Failed to write fragmentation needed: %s", err)
+		}
+	case 6:
+		if err := v.writeICMPError(packet, ICMPV6_PACKET_TOO_BIG, 0, uint32(mtu)); err != nil {
+			v.logger.Debugf("Failed to write packet too big: %s", err)
+		}
+	}
+	return true
+}
@@ -0,0 +1,182 @@
+package vpn
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-log/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gfleury/solo/client/broadcast"
+	"github.com/gfleury/solo/client/logger"
+)
+
+// newIPv4Packet returns a packet with a valid header checksum, with the DF flag if df
+func newIPv4Packet(src, dst string, protocol byte, payload []byte, df bool) Packet {
+	packet := make(Packet, 20+len(payload))
+	packet[0] = 0x45
+	binary.BigEndian.PutUint16(packet[2:], uint16(len(packet)))
+	if df {
+		packet[6] = 0x40
+	}
+	packet[8] = 64
+	packet[9] = protocol
+	copy(packet[12:], net.ParseIP(src).To4())
+	copy(packet[16:], net.ParseIP(dst).To4())
+	binary.BigEndian.PutUint16(packet[10:], checksum(packet[:20], 0))
+	copy(packet[20:], payload)
+	return packet
+}
+
+func newIPv6Packet(src, dst string, nextHeader byte, payload []byte) Packet {
+	packet := make(Packet, 40+len(payload))
+	packet[0] = 0x60
+	binary.BigEndian.PutUint16(packet[4:], uint16(len(payload)))
+	packet[6] = nextHeader
+	packet[7] = 64
+	copy(packet[8:], net.ParseIP(src).To16())
+	copy(packet[24:], net.ParseIP(dst).To16())
+	copy(packet[40:], payload)
+	return packet
+}
+
+// pseudoHeaderSum sums the addresses, length and protocol covered by the TCP and ICMPv6 checksums
+func pseudoHeaderSum(packet Packet, payload []byte) uint32 {
+	if packet.IpVersion() == 6 {
+		s := sum(packet[8:40], 0)
+		return s + uint32(len(payload)) + uint32(packet[6])
+	}
+	s := sum(packet[12:20], 0)
+	return s + uint32(len(payload)) + uint32(packet[9])
+}
+
+// newSYN returns a TCP SYN with the MSS option, with a valid checksum
+func newSYN(packet Packet, headerSize int, mss uint16) Packet {
+	tcp := packet[headerSize:]
+	tcp[12] = 6 << 4
+	tcp[13] = 0x02
+	// MSS option
+	tcp[20], tcp[21] = 2, 4
+	binary.BigEndian.PutUint16(tcp[22:], mss)
+	binary.BigEndian.PutUint16(tcp[16:], checksum(tcp, pseudoHeaderSum(packet, tcp)))
+	return packet
+}
+
+func TestClampMSS(t *testing.T) {
+	syn := newSYN(newIPv4Packet("192.168.0.2", "10.1.0.2", ipProtocolTCP, make([]byte, 24), true), 20, 1460)
+	clampMSS(syn, 1400)
+	require.Equal(t, uint16(1360), binary.BigEndian.Uint16(syn[42:]))
+	require.Zero(t, checksum(syn[20:], pseudoHeaderSum(syn, syn[20:])), "valid TCP checksum")
+
+	// Smaller MSS are kept
+	clampMSS(syn, 1420)
+	require.Equal(t, uint16(1360), binary.BigEndian.Uint16(syn[42:]))
+
+	syn6 := newSYN(newIPv6Packet("fd00::2", "fd00::3", ipProtocolTCP, make([]byte, 24)), 40, 1440)
+	clampMSS(syn6, 1400)
+	require.Equal(t, uint16(1340), binary.BigEndian.Uint16(syn6[62:]))
+	require.Zero(t, checksum(syn6[40:], pseudoHeaderSum(syn6, syn6[40:])), "valid TCP checksum")
+
+	// Only SYNs are clamped
+	ack := newSYN(newIPv4Packet("192.168.0.2", "10.1.0.2", ipProtocolTCP, make([]byte, 24), true), 20, 1460)
+	ack[33] = 0x10
+	clampMSS(ack, 1400)
+	require.Equal(t, uint16(1460), binary.BigEndian.Uint16(ack[42:]))
+}
+
+func TestICMPError(t *testing.T) {
+	big := newIPv4Packet("10.1.0.1", "10.1.0.2", 17, make([]byte, 1480), true)
+	require.True(t, icmpErrorAllowed(big))
+
+	reply := icmpError(net.ParseIP("10.1.0.1"), big, ICMP_DEST_UNREACHABLE, ICMP_FRAG_NEEDED, 1300)
+	require.Len(t, reply, ICMP_MAX_SIZE)
+	require.Zero(t, checksum(reply[:20], 0), "valid IP checksum")
+	require.Zero(t, checksum(reply[20:], 0), "valid ICMP checksum")
+	require.Equal(t, []byte{ICMP_DEST_UNREACHABLE, ICMP_FRAG_NEEDED}, []byte(reply[20:22]))
+	require.Equal(t, uint16(1300), binary.BigEndian.Uint16(reply[26:]))
+	dst, _ := reply.DstIp()
+	require.Equal(t, "10.1.0.1", dst.String())
+	require.Equal(t, []byte(big[:28]), []byte(reply[28:56]), "quotes the packet")
+	require.False(t, icmpErrorAllowed(reply), "no errors about errors")
+
+	big6 := newIPv6Packet("fd00::2", "fd00::3", 17, make([]byte, 1460))
+	reply = icmpError(net.ParseIP("fd00::3"), big6, ICMPV6_PACKET_TOO_BIG, 0, 1300)
+	require.Len(t, reply, ICMPV6_MAX_SIZE)
+	require.Zero(t, checksum(reply[40:], pseudoHeaderSum(reply, reply[40:])), "valid ICMPv6 checksum")
+	require.Equal(t, uint32(1300), binary.BigEndian.Uint32(reply[44:]))
+	require.False(t, icmpErrorAllowed(reply), "no errors about errors")
+
+	require.False(t, icmpErrorAllowed(newIPv4Packet("10.1.0.1", "224.0.0.251", 17, make([]byte, 8), false)))
+	require.False(t, icmpErrorAllowed(newIPv6Packet("fd00::2", "ff02::fb", 17, make([]byte, 8))))
+}
+
+func TestAutoMTU(t *testing.T) {
+	require.GreaterOrEqual(t, AutoMTU(""), MIN_INTERFACE_MTU)
+}
+
+func TestReadMTU(t *testing.T) {
+	v := &VPNInterface{config: &InterfaceConfig{InterfaceMTU: 1420}}
+	mtu := make(Packet, 2)
+
+	binary.BigEndian.PutUint16(mtu, 1300)
+	v.readMTU("peer", mtu)
+	require.Equal(t, 1300, v.pathMTU("peer"))
+
+	// Too small to carry anything, ignored
+	binary.BigEndian.PutUint16(mtu, 40)
+	v.readMTU("peer", mtu)
+	require.Equal(t, 1300, v.pathMTU("peer"))
+	v.readMTU("other", make(Packet, 2))
+	require.Equal(t, 1420, v.pathMTU("other"))
+}
+
+func TestPathMTU(t *testing.T) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancelFunc()
+
+	h1, err := NewTestHost("0")
+	require.NoError(t, err)
+	h2, err := NewTestHost("0")
+	require.NoError(t, err)
+	require.NoError(t, TestConnectHosts(ctx, h1, h2))
+
+	dummyBroadcast := broadcast.NewDummyBroadcast()
+	dummyBroadcast.AddFakePeer("10.1.0.1", h1.ID())
+	dummyBroadcast.AddFakePeer("10.1.0.2", h2.ID())
+
+	iface1, iface2 := NewTestPacketBuffer(), NewTestPacketBuffer()
+	vpn1 := newTestService(h1, iface1, false)
+	vpn1.Config.InterfaceAddress = "10.1.0.1/24"
+	vpn2 := newTestService(h2, iface2, false)
+	vpn2.vpnInterface.config.InterfaceMTU = 1300
+
+	l := logger.New(log.LevelDebug)
+	require.NoError(t, vpn1.Run(ctx, l, h1, dummyBroadcast))
+	require.NoError(t, vpn2.Run(ctx, l, h2, dummyBroadcast))
+
+	// The MTU of h2 is announced back on the stream opened by h1
+	first := newIPv4Packet("10.1.0.1", "10.1.0.2", 17, make([]byte, 100), true)
+	_, err = (&TestPacketBufferFeed{t: iface1}).Write(first)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return iface2.MyPacketsLen() >= len(first) }, 10*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool { return vpn1.vpnInterface.pathMTU(h2.ID()) == 1300 }, 10*time.Second, 10*time.Millisecond)
+	require.Equal(t, 1300, vpn2.vpnInterface.pathMTU(h1.ID()))
+
+	// Larger packets to h2 are answered with fragmentation needed
+	received := iface1.MyPacketsLen()
+	big := newIPv4Packet("10.1.0.1", "10.1.0.2", 17, make([]byte, 1380), true)
+	_, err = (&TestPacketBufferFeed{t: iface1}).Write(big)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return iface1.MyPacketsLen() > received }, 10*time.Second, 10*time.Millisecond)
+
+	iface1.Lock()
+	reply := Packet(iface1.myPackets[received:])
+	iface1.Unlock()
+	require.Equal(t, []byte{ICMP_DEST_UNREACHABLE, ICMP_FRAG_NEEDED}, []byte(reply[20:22]))
+	require.Equal(t, uint16(1300), binary.BigEndian.Uint16(reply[26:]))
+	src, _ := reply.SrcIp()
+	require.Equal(t, "10.1.0.2", src.String())
+}
@@ -0,0 +1,72 @@
+package vpn
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+
+	"golang.zx2c4.com/wireguard/tun"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+// Netstack is a userspace TCP/IP stack (gVisor) used as the VPN interface
+// instead of a kernel TUN device, the process itself dials and listens on
+// the overlay network through it, without root
+type Netstack struct {
+	*netstack.Net
+
+	device tun.Device
+	addr   netip.Addr
+}
+
+func newNetstack(config *InterfaceConfig) (*Netstack, error) {
+	prefix, err := netip.ParsePrefix(config.InterfaceAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	device, tnet, err := netstack.CreateNetTUN([]netip.Addr{prefix.Addr()}, []netip.Addr{}, config.InterfaceMTU)
+	if err != nil {
+		return nil, err
+	}
+	return &Netstack{Net: tnet, device: device, addr: prefix.Addr()}, nil
+}
+
+// Read returns the next packet sent by the stack to the overlay
+func (n *Netstack) Read(b []byte) (int, error) {
+	sizes := []int{0}
+	_, err := n.device.Read([][]byte{b}, sizes, 0)
+	return sizes[0], err
+}
+
+// Write delivers a packet from the overlay to the stack
+func (n *Netstack) Write(b []byte) (int, error) {
+	_, err := n.device.Write([][]byte{b}, 0)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (n *Netstack) Close() error {
+	return n.device.Close()
+}
+
+// Listen is net.Listen on the overlay network, for tcp only, an address
+// without IP listens on our overlay IP
+func (n *Netstack) Listen(network, address string) (net.Listener, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("netstack listen on %s is not supported", network)
+	}
+
+	tcpAddr, err := net.ResolveTCPAddr(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if tcpAddr.IP == nil {
+		return n.ListenTCPAddrPort(netip.AddrPortFrom(n.addr, uint16(tcpAddr.Port)))
+	}
+	return n.ListenTCP(tcpAddr)
+}
@@ -0,0 +1,66 @@
+package vpn
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// pipeNetstacks forwards the packets sent by src to dst, like the overlay
+func pipeNetstacks(src, dst *Netstack) {
+	packet := make([]byte, 1420)
+	for {
+		n, err := src.Read(packet)
+		if err != nil {
+			return
+		}
+		dst.Write(packet[:n])
+	}
+}
+
+func TestNetstack(t *testing.T) {
+	ns1, err := newNetstack(&InterfaceConfig{InterfaceAddress: "10.1.0.1/24", InterfaceMTU: 1420})
+	require.NoError(t, err)
+	defer ns1.Close()
+	ns2, err := newNetstack(&InterfaceConfig{InterfaceAddress: "10.1.0.2/24", InterfaceMTU: 1420})
+	require.NoError(t, err)
+	defer ns2.Close()
+
+	go pipeNetstacks(ns1, ns2)
+	go pipeNetstacks(ns2, ns1)
+
+	_, err = ns2.Listen("udp", ":80")
+	require.Error(t, err)
+
+	l, err := ns2.Listen("tcp", ":80")
+	require.NoError(t, err)
+	defer l.Close()
+	require.Equal(t, "10.1.0.2:80", l.Addr().String())
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, err := ns1.DialContext(ctx, "tcp", "10.1.0.2:80")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	reply := make([]byte, 5)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(reply))
+}
@@ -49,6 +49,31 @@ func (p *AlleinStreamMap) Put(streamID string, stream *AlleinStream) {
 	p.streamMap[streamID] = stream
 }
 
+// Replace swaps the transport stream of streamID keeping its noise session,
+// so an established stream can migrate to another connection without a new
+// handshake. It creates the entry if it doesn't exist yet.
+func (p *AlleinStreamMap) Replace(streamID string, stream io.ReadWriter) {
+	p.Lock()
+	defer p.Unlock()
+	if s, found := p.streamMap[streamID]; found {
+		p.streamMap[streamID] = &AlleinStream{Stream: stream, NoiseStream: s.NoiseStream}
+		return
+	}
+	p.streamMap[streamID] = &AlleinStream{Stream: stream}
+}
+
+// DeleteStream removes streamID only if it is still backed by stream, a
+// stream that was replaced in the meantime is kept.
+func (p *AlleinStreamMap) DeleteStream(streamID string, stream io.ReadWriter) {
+	p.Lock()
+	defer p.Unlock()
+	if s, found := p.streamMap[streamID]; found && s.Stream == stream {
+		s.NoiseStream = nil
+		s.Stream = nil
+		delete(p.streamMap, streamID)
+	}
+}
+
 func (p *AlleinStreamMap) Delete(streamID string) {
 	if s, found := p.Get(streamID); found {
 		s.NoiseStream = nil
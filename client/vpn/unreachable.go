@@ -0,0 +1,79 @@
+package vpn
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	libp2p_protocol "github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/multiformats/go-multistream"
+)
+
+// REFUSAL_TTL is how long the packets to a peer that refused our traffic are
+// answered with errors before trying it again
+const REFUSAL_TTL = 30 * time.Second
+
+// ErrPeerRefused is returned when the peer PeerACL denies our traffic
+var ErrPeerRefused = errors.New("peer refused the VPN traffic")
+
+// refused tells if err is a peer denying our traffic, its PeerACL, our
+// connection gater or the peer not serving the VPN protocol
+func refused(err error) bool {
+	return errors.Is(err, ErrPeerRefused) ||
+		errors.Is(err, swarm.ErrGaterDisallowedConnection) ||
+		errors.Is(err, multistream.ErrNotSupported[libp2p_protocol.ID]{})
+}
+
+// refuse answers the handshake of srcID, denied by our PeerACL, and closes the stream
+func (v *VPNService) refuse(stream network.Stream, srcID peer.ID) {
+	v.logger.Debugf("Data stream from %s refused by the peer ACL", srcID)
+	if _, err := io.Copy(stream, NewVPNPacket(VPN_REFUSED, nil, []byte(srcID), []byte(v.host.ID()))); err != nil {
+		stream.Reset()
+		return
+	}
+	stream.Close()
+}
+
+// isRefused tells if dstID refused our traffic less than REFUSAL_TTL ago
+func (v *VPNService) isRefused(dstID peer.ID) bool {
+	since, found := v.refusedPeers.Load(dstID)
+	if !found {
+		return false
+	}
+	if time.Since(since.(time.Time)) > REFUSAL_TTL {
+		v.refusedPeers.Delete(dstID)
+		return false
+	}
+	return true
+}
+
+// unreachable answers a packet to a destination missing from the network
+// with a host, or ICMPv6 address, unreachable error
+func (v *VPNService) unreachable(packet Packet) {
+	var err error
+	if packet.IpVersion() == 6 {
+		err = v.writeICMPError(packet, ICMPV6_DEST_UNREACHABLE, ICMPV6_ADDRESS_UNREACHABLE, 0)
+	} else {
+		err = v.writeICMPError(packet, ICMP_DEST_UNREACHABLE, ICMP_HOST_UNREACHABLE, 0)
+	}
+	if err != nil {
+		v.logger.Debugf("Failed to write destination unreachable: %s", err)
+	}
+}
+
+// prohibited answers a packet to a peer refusing our traffic with an
+// administratively prohibited error
+func (v *VPNService) prohibited(packet Packet) {
+	var err error
+	if packet.IpVersion() == 6 {
+		err = v.writeICMPError(packet, ICMPV6_DEST_UNREACHABLE, ICMPV6_ADMIN_PROHIBITED, 0)
+	} else {
+		err = v.writeICMPError(packet, ICMP_DEST_UNREACHABLE, ICMP_ADMIN_PROHIBITED, 0)
+	}
+	if err != nil {
+		v.logger.Debugf("Failed to write administratively prohibited: %s", err)
+	}
+}
@@ -0,0 +1,97 @@
+package vpn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gfleury/solo/client/broadcast"
+	"github.com/gfleury/solo/client/broadcast/prp"
+	"github.com/gfleury/solo/client/logger"
+	"github.com/gfleury/solo/common/models"
+)
+
+func TestUnresolvedUnreachable(t *testing.T) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancelFunc()
+
+	h, err := NewTestHost("0")
+	require.NoError(t, err)
+
+	table := prp.NewPRPTable()
+	table.InsertMyselfEntry(&models.NetworkNode{PeerID: h.ID().String(), IP: "10.1.0.1"})
+
+	iface := NewTestPacketBuffer()
+	v := newTestService(h, iface, false)
+	v.Config.InterfaceAddress = "10.1.0.1/24"
+	require.NoError(t, v.Run(ctx, logger.New(log.LevelDebug), h, &tableBroadcast{broadcast.NewDummyBroadcast(), table}))
+
+	packet := newIPv4Packet("10.1.0.1", "10.1.0.9", 17, make([]byte, 8), false)
+
+	// Queued while the PRPRequest is pending
+	require.Error(t, v.handlePacket(packet))
+	require.Error(t, v.handlePacket(packet))
+	require.Zero(t, iface.MyPacketsLen())
+
+	// Requested again after the backoff, still unanswered
+	time.Sleep(prp.PRP_REQUEST_MIN_BACKOFF)
+	require.Error(t, v.handlePacket(packet))
+	require.NoError(t, v.handlePacket(packet))
+
+	iface.Lock()
+	reply := Packet(iface.myPackets)
+	iface.Unlock()
+	require.Equal(t, []byte{ICMP_DEST_UNREACHABLE, ICMP_HOST_UNREACHABLE}, []byte(reply[20:22]))
+	require.Zero(t, checksum(reply[20:], 0), "valid ICMP checksum")
+	dst, _ := reply.DstIp()
+	require.Equal(t, "10.1.0.1", dst.String())
+	// From the unreachable destination, never from one of our addresses
+	src, _ := reply.SrcIp()
+	require.Equal(t, "10.1.0.9", src.String())
+}
+
+func TestPeerRefused(t *testing.T) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancelFunc()
+
+	h1, err := NewTestHost("0")
+	require.NoError(t, err)
+	h2, err := NewTestHost("0")
+	require.NoError(t, err)
+	require.NoError(t, TestConnectHosts(ctx, h1, h2))
+
+	dummyBroadcast := broadcast.NewDummyBroadcast()
+	dummyBroadcast.AddFakePeer("10.1.0.1", h1.ID())
+	dummyBroadcast.AddFakePeer("10.1.0.2", h2.ID())
+
+	iface1, iface2 := NewTestPacketBuffer(), NewTestPacketBuffer()
+	vpn1 := newTestService(h1, iface1, false)
+	vpn1.Config.InterfaceAddress = "10.1.0.1/24"
+	vpn2 := newTestService(h2, iface2, false)
+	vpn2.Config.PeerACL = func(peer.ID) bool { return false }
+
+	l := logger.New(log.LevelDebug)
+	require.NoError(t, vpn1.Run(ctx, l, h1, dummyBroadcast))
+	require.NoError(t, vpn2.Run(ctx, l, h2, dummyBroadcast))
+
+	packet := newIPv4Packet("10.1.0.1", "10.1.0.2", 17, make([]byte, 8), false)
+	require.NoError(t, vpn1.handlePacket(packet))
+	require.True(t, vpn1.isRefused(h2.ID()))
+	require.Zero(t, iface2.MyPacketsLen())
+
+	iface1.Lock()
+	reply := Packet(iface1.myPackets)
+	iface1.Unlock()
+	require.Equal(t, []byte{ICMP_DEST_UNREACHABLE, ICMP_ADMIN_PROHIBITED}, []byte(reply[20:22]))
+	src, _ := reply.SrcIp()
+	require.Equal(t, "10.1.0.2", src.String())
+
+	// Answered without a new stream until REFUSAL_TTL
+	received := iface1.MyPacketsLen()
+	require.NoError(t, vpn1.handlePacket(packet))
+	require.Greater(t, iface1.MyPacketsLen(), received)
+}
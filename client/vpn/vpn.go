@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	gonoise "github.com/flynn/noise"
@@ -13,9 +16,11 @@ import (
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multiaddr"
+	"golang.org/x/time/rate"
 
 	"github.com/gfleury/solo/client/broadcast"
 	"github.com/gfleury/solo/client/discovery"
+	"github.com/gfleury/solo/client/events"
 	"github.com/gfleury/solo/client/logger"
 	"github.com/gfleury/solo/client/protocol"
 
@@ -26,6 +31,7 @@ import (
 
 type VPNService struct {
 	logger log.StandardLogger
+	host   host.Host
 
 	// VPN Interface
 	vpnInterface *VPNInterface
@@ -37,6 +43,24 @@ type VPNService struct {
 
 	// Packet ring
 	packetRing PacketRing
+
+	// Events receives the opened streams and failed handshakes
+	Events *events.Bus
+
+	// macAnnounce is set while an announce of our MACs is pending
+	macAnnounce atomic.Bool
+
+	// floodLimiter bounds the broadcasts and multicasts replicated to all
+	// the peers, subnetBroadcast is the broadcast address of our subnet
+	floodLimiter    *rate.Limiter
+	subnetBroadcast net.IP
+	// icmpLimiter bounds the ICMP errors written into the interface
+	icmpLimiter *rate.Limiter
+	// refusedPeers are the peers that refused our traffic, by peer.ID, with
+	// the time they did
+	refusedPeers sync.Map
+	// flooding are the peers a flooded packet is being sent to, by peer.ID
+	flooding sync.Map
 }
 
 type VPNHost interface {
@@ -101,10 +125,16 @@ func NewWrapperHost(h host.Host) VPNHost {
 	return &WrapperHost{host: h}
 }
 
+// SetEventBus sets the bus the streams, handshakes and interface events are published to
+func (v *VPNService) SetEventBus(bus *events.Bus) {
+	v.Events = bus
+}
+
 func (v *VPNService) Run(ctx context.Context, logger log.StandardLogger, host host.Host, broadcast broadcast.Broadcaster) error {
 	var err error
 
 	v.logger = logger
+	v.host = host
 	v.broadcast = broadcast
 
 	// Create and configure Network Interface used on the VPN Service
@@ -114,21 +144,26 @@ func (v *VPNService) Run(ctx context.Context, logger log.StandardLogger, host ho
 			return err
 		}
 	}
+	v.vpnInterface.events = v.Events
+	v.floodLimiter = rate.NewLimiter(FLOOD_RATE, FLOOD_BURST)
+	v.icmpLimiter = rate.NewLimiter(ICMP_RATE, ICMP_BURST)
+	v.subnetBroadcast = subnetBroadcast(v.Config.InterfaceAddress)
+	if table := broadcast.Table(); v.Config.TAP && table != nil {
+		v.vpnInterface.learnMAC = table.LearnMAC
+	}
 
 	// Set the VPN P2P stream handler (for incoming VPNPacket streams)
 	host.SetStreamHandler(protocol.ALLEIN.ID(), v.dataStreamHandler())
 
-	if v.Config.CreateInterface {
+	// Move relayed VPN streams to direct connections once they show up
+	host.Network().Notify(v.upgradeNotifiee())
+
+	if v.Config.CreateInterface && !v.Config.Userspace {
 		if err := v.vpnInterface.prepareInterface(); err != nil {
 			return err
 		}
 	}
-
-	// Announce ourselves on the network
-	go func() {
-		time.Sleep(5 * time.Second)
-		v.broadcast.AnnounceMyself(ctx)
-	}()
+	v.Events.Publish(events.Event{Type: events.INTERFACE_UP, IP: v.Config.InterfaceAddress, Detail: v.Config.InterfaceName})
 
 	// read packets from the network interface
 	go v.readPackets(ctx)
@@ -136,24 +171,58 @@ func (v *VPNService) Run(ctx context.Context, logger log.StandardLogger, host ho
 	return nil
 }
 
+// Netstack returns the userspace stack to dial and listen on the overlay,
+// nil unless the service runs with a Userspace interface
+func (v *VPNService) Netstack() *Netstack {
+	if v.vpnInterface == nil {
+		return nil
+	}
+	return v.vpnInterface.netstack
+}
+
+// Stop tears the VPN service down, the interface down hooks run before the
+// interface is gone
+func (v *VPNService) Stop() error {
+	if v.host == nil {
+		// Never started
+		return nil
+	}
+	v.Events.Publish(events.Event{Type: events.INTERFACE_DOWN, IP: v.Config.InterfaceAddress, Detail: v.Config.InterfaceName})
+	return nil
+}
+
 func (v *VPNService) dataStreamHandler() func(stream network.Stream) {
 	return func(stream network.Stream) {
 		// TODO: Verify Inbound Frames
 		dstID := stream.Conn().RemotePeer()
 		streamKey := v.vpnInterface.getInboundStreamKey(dstID)
 
-		v.logger.Debugf("New data stream inbound from: %s", streamKey)
-		v.vpnInterface.streamMap.New(streamKey, stream)
+		if v.Config.PeerACL != nil && !v.Config.PeerACL(dstID) {
+			v.refuse(stream, dstID)
+			return
+		}
+
+		v.logger.Debugf("New data stream inbound from: %s (%s)", streamKey, ConnectionTypeOf(stream.Conn()))
+		v.Events.Publish(events.Event{Type: events.STREAM_OPENED, PeerID: dstID.String(), Detail: fmt.Sprintf("inbound %s", ConnectionTypeOf(stream.Conn()))})
+		// Keep the noise session in case the peer is migrating the stream to another connection
+		v.vpnInterface.streamMap.Replace(streamKey, stream)
 
 		n, err := io.Copy(v.vpnInterface, stream)
 		if err != nil {
 			v.logger.Errorf("Failed to copy all data (copied only: %d) into network interface: %s", n, err)
 			stream.Reset()
+
+			// Stream ist tot
+			v.logger.Debugf("Finish and remove noiseStream handler: %s", streamKey)
+			v.vpnInterface.streamMap.DeleteStream(streamKey, stream)
+			return
 		}
-		v.logger.Debugf("Finish and remove noiseStream handler: %s", streamKey)
 
-		// Stream ist tot
-		v.vpnInterface.streamMap.Delete(streamKey)
+		// Closed by the peer, it may be moving to another connection so the
+		// noise session is kept for the next stream, closing our side tells
+		// the peer everything was read
+		v.logger.Debugf("Finish noiseStream handler: %s", streamKey)
+		stream.Close()
 	}
 }
 
@@ -167,11 +236,19 @@ func (v *VPNService) handlePacket(packet Packet) error {
 		return fmt.Errorf("packet size is less than 1")
 	}
 
+	if v.Config.TAP {
+		return v.handleFrame(ctx, Frame(packet))
+	}
+
 	dstIp, err := packet.DstIp()
 	if err != nil {
 		return err
 	}
 
+	if v.isFlooded(dstIp) {
+		return v.flood(packet)
+	}
+
 	dst := dstIp.String()
 
 	notFoundErr := NewNotFoundError(dst)
@@ -185,6 +262,12 @@ func (v *VPNService) handlePacket(packet Packet) error {
 			if err != nil {
 				return err
 			}
+			return notFoundErr
+		}
+		// Requested and still unanswered, fail the applications right away
+		if table := v.broadcast.Table(); table != nil && table.Unresolved(dst) {
+			v.unreachable(packet)
+			return nil
 		}
 		return notFoundErr
 	}
@@ -194,7 +277,25 @@ func (v *VPNService) handlePacket(packet Packet) error {
 		return errors.Wrap(err, "could not decode peer")
 	}
 
-	return v.vpnInterface.handlePacket(ctx, dstID, packet)
+	if v.isRefused(dstID) {
+		v.prohibited(packet)
+		return nil
+	}
+
+	mtu := v.vpnInterface.pathMTU(dstID)
+	if v.tooBig(packet, mtu) {
+		return nil
+	}
+	clampMSS(packet, mtu)
+
+	err = v.vpnInterface.handlePacket(ctx, dstID, packet)
+	if refused(err) {
+		v.logger.Debugf("Traffic to %s refused: %s", dstID, err)
+		v.refusedPeers.Store(dstID, time.Now())
+		v.prohibited(packet)
+		return nil
+	}
+	return err
 }
 
 func (v *VPNService) readPackets(ctx context.Context) {
@@ -4,11 +4,14 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"runtime"
+	"sync"
 
 	"github.com/gfleury/solo/client/crypto/noise"
+	"github.com/gfleury/solo/client/events"
 	"github.com/gfleury/solo/client/protocol"
 	"github.com/gfleury/solo/client/vpn/stream_map"
 	"github.com/libp2p/go-libp2p/core/network"
@@ -29,22 +32,43 @@ type InterfaceConfig struct {
 	CreateInterface  bool
 	InterfaceName    string
 	InterfaceAddress string
+	// Userspace runs the interface on a Netstack instead of a TUN device
+	Userspace bool
+	// TAP switches Ethernet frames on a TAP device instead of IP packets,
+	// Bridge is the bridge the TAP device is attached to, if any
+	TAP    bool
+	Bridge string
+	// PeerACL, if set, refuses the streams of the peers it returns false for
+	PeerACL func(peer.ID) bool
 }
 
 type VPNInterface struct {
 	host             VPNHost
 	networkInterface *water.Interface
+	netstack         *Netstack
 	hasInfoHeader    bool
 	config           *InterfaceConfig
 
 	buffer    *bytes.Buffer
 	streamMap *stream_map.AlleinStreamMap
 	chain     IOChainPacket
+	events    *events.Bus
+
+	// learnMAC records the source MACs of the frames received from the peers
+	learnMAC func(mac, peerID string)
+	// peerMTUs are the interface MTUs announced by the peers, by peer.ID
+	peerMTUs sync.Map
+	// streamLocks serialize the writes of each outbound stream key, the
+	// noise nonces must reach the peer in order
+	streamLocks sync.Map
 }
 
 func newInterface(config *InterfaceConfig, host VPNHost) (*VPNInterface, error) {
 	streamMap := stream_map.NewNoiseStreamMap()
 	var err error
+	if config.InterfaceMTU == 0 {
+		config.InterfaceMTU = AutoMTU(config.InterfaceName)
+	}
 	i := &VPNInterface{
 		config: config,
 		buffer: bytes.NewBuffer(make([]byte, 0)),
@@ -55,9 +79,22 @@ func newInterface(config *InterfaceConfig, host VPNHost) (*VPNInterface, error)
 		streamMap: streamMap,
 		host:      host,
 	}
+	if config.TAP && config.Userspace {
+		return nil, fmt.Errorf("TAP interfaces can't run on a userspace network stack")
+	}
+	if config.Userspace {
+		i.netstack, err = newNetstack(config)
+		if err != nil {
+			return nil, err
+		}
+		i.networkInterface = &water.Interface{ReadWriteCloser: i.netstack}
+		return i, nil
+	}
+
 	switch runtime.GOOS {
 	case "darwin":
-		i.hasInfoHeader = true
+		// Only TUN devices prefix the packets with the address family
+		i.hasInfoHeader = !config.TAP
 	default:
 		i.hasInfoHeader = false
 	}
@@ -77,7 +114,7 @@ func (v *VPNInterface) Read(b []byte) (int, error) {
 // This is called when there is traffic on the TUN interface
 // Tip: OUTGOING TRAFFIC (from the client perspective)
 func (v *VPNInterface) ReadPacket() (Packet, int, error) {
-	packet := make(Packet, v.config.InterfaceMTU)
+	packet := make(Packet, v.config.InterfaceMTU+v.frameOverhead()+TUN_INFO_HEADER_SIZE)
 
 	n, err := v.networkInterface.Read([]byte(packet))
 	if err != nil {
@@ -95,42 +132,15 @@ func (v *VPNInterface) writeStream(stream io.ReadWriter, packet *VPNPacket) (int
 
 	if packet.header.Type == VPN_NOISEHANDSHAKE.Uint8() {
 		dstID := packet.header.GetDstID()
-		streamKey := v.getOutboundStreamKey(dstID)
-
-		// NOISE HANDSHAKE
-		// Setup noise handshake stream as initiator
-		noiseStream, err := noise.NewNoiseStreamInitiator(v.host.PrivateKey(), v.host.PeerPublicKey(dstID), []byte(v.config.PreSharedKey))
-		if err != nil {
-			return 0, fmt.Errorf("could not open stream noise to %s: %w", dstID, err)
-		}
-		reply, err := noiseStream.DoHandshake(nil)
-		if err != nil {
-			return 0, fmt.Errorf("failed to DoHandshake: %s", err)
-		}
-		_, err = io.Copy(stream, NewVPNPacket(VPN_NOISEHANDSHAKE, reply, []byte(dstID), []byte(v.host.ID())))
-		if err != nil {
-			return 0, fmt.Errorf("failed to write handshake msg into stream: %s", err)
-		}
-
-		reply = make([]byte, v.config.InterfaceMTU+HEADER_SIZE)
-		replySize, err := stream.Read(reply)
-		if err != nil {
-			return 0, fmt.Errorf("failed to read handshake msg into stream: %s", err)
-		}
-		vpnPacket := &VPNPacket{}
-
-		_, err = vpnPacket.Write(reply[:replySize])
-		if err != nil {
-			return 0, fmt.Errorf("failed to marshall VPNPacket: %s", err)
+		if err := v.initiateHandshake(stream, dstID); err != nil {
+			v.events.Publish(events.Event{Type: events.HANDSHAKE_FAILED, PeerID: dstID.String(), Detail: err.Error()})
+			return 0, err
 		}
-
-		_, err = noiseStream.DoHandshake(vpnPacket.networkPacket)
-		if err != nil {
-			return 0, fmt.Errorf("failed to final handshake phase: %s", err)
+		v.events.Publish(events.Event{Type: events.STREAM_OPENED, PeerID: dstID.String(), Detail: "outbound"})
+		if err := v.writeMTU(stream, dstID); err != nil {
+			return 0, err
 		}
 
-		v.streamMap.NewWithNoise(streamKey, stream, noiseStream)
-
 		packet.header.Type = VPN_DATA.Uint8()
 	}
 	n, err := io.Copy(stream, v.OutboundChain(packet))
@@ -140,8 +150,57 @@ func (v *VPNInterface) writeStream(stream io.ReadWriter, packet *VPNPacket) (int
 	return n - HEADER_SIZE, err
 }
 
+// initiateHandshake does the noise handshake of a new outbound stream to dstID
+func (v *VPNInterface) initiateHandshake(stream io.ReadWriter, dstID peer.ID) error {
+	streamKey := v.getOutboundStreamKey(dstID)
+
+	// NOISE HANDSHAKE
+	// Setup noise handshake stream as initiator
+	noiseStream, err := noise.NewNoiseStreamInitiator(v.host.PrivateKey(), v.host.PeerPublicKey(dstID), []byte(v.config.PreSharedKey))
+	if err != nil {
+		return fmt.Errorf("could not open stream noise to %s: %w", dstID, err)
+	}
+	reply, err := noiseStream.DoHandshake(nil)
+	if err != nil {
+		return fmt.Errorf("failed to DoHandshake: %s", err)
+	}
+	_, err = io.Copy(stream, NewVPNPacket(VPN_NOISEHANDSHAKE, reply, []byte(dstID), []byte(v.host.ID())))
+	if err != nil {
+		return fmt.Errorf("failed to write handshake msg into stream: %s", err)
+	}
+
+	// Read exactly the reply, the MTU of the receiver follows it
+	vpnPacket, err := readVPNPacket(stream, v.maxPacketSize())
+	if err != nil {
+		return fmt.Errorf("failed to read handshake msg into stream: %s", err)
+	}
+	if vpnPacket.header.Type == VPN_REFUSED.Uint8() {
+		return fmt.Errorf("%s: %w", dstID, ErrPeerRefused)
+	}
+
+	_, err = noiseStream.DoHandshake(vpnPacket.networkPacket)
+	if err != nil {
+		return fmt.Errorf("failed to final handshake phase: %s", err)
+	}
+	// Read aside, the stream lock holds the writes to dstID until we return
+	go v.readReplyMTU(stream, noiseStream, dstID)
+
+	v.streamMap.NewWithNoise(streamKey, stream, noiseStream)
+	return nil
+}
+
+// streamLock returns the lock of the writes to streamKey
+func (v *VPNInterface) streamLock(streamKey string) *sync.Mutex {
+	lock, _ := v.streamLocks.LoadOrStore(streamKey, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
 func (v *VPNInterface) handlePacket(ctx context.Context, dstID peer.ID, packet Packet) error {
 	streamKey := v.getOutboundStreamKey(dstID)
+	lock := v.streamLock(streamKey)
+	lock.Lock()
+	defer lock.Unlock()
+
 	// Open a  Data stream if necessary
 	if soloStream, ok := v.streamMap.Get(streamKey); ok {
 		// TODO: Return read bytes here and aggregate somewhere
@@ -153,12 +212,13 @@ func (v *VPNInterface) handlePacket(ctx context.Context, dstID peer.ID, packet P
 		// Stream ist tot
 		// v.logger.Debugf("Finish and remove noiseStream and data stream: %s %s", dstID, err)
 		soloStream.Stream.(network.Stream).Reset()
-		v.streamMap.Delete(streamKey)
+		v.streamMap.DeleteStream(streamKey, soloStream.Stream)
 
 		return err
 	} else {
 		// v.logger.Debugf("Create new data stream for %s", streamKey)
-		stream, err := v.host.NewStream(ctx, dstID, protocol.ALLEIN.ID())
+		// Allow relayed connections, the stream is upgraded once a direct connection is available
+		stream, err := v.host.NewStream(network.WithUseTransient(ctx, "vpn"), dstID, protocol.ALLEIN.ID())
 		if err != nil {
 			return fmt.Errorf("could not open stream to %s: %w", dstID, err)
 		}
@@ -166,6 +226,9 @@ func (v *VPNInterface) handlePacket(ctx context.Context, dstID peer.ID, packet P
 		// Set first type as VPN_NOISEHANDSHAKE to force handshake insive the VPNInterface
 		_, err = v.writeStream(stream, NewVPNPacket(VPN_NOISEHANDSHAKE, packet, []byte(dstID), []byte(v.host.ID())))
 		// v.logger.Debugf("Stream created sucessfuly: %s", streamKey)
+		if errors.Is(err, ErrPeerRefused) {
+			stream.Reset()
+		}
 
 		return err
 	}
@@ -216,13 +279,16 @@ func (v *VPNInterface) Write(b []byte) (int, error) {
 
 			streamKey := v.getInboundStreamKey(dstID)
 			soloStream, found := v.streamMap.Get(streamKey)
-			if found && soloStream.NoiseStream == nil {
+			// A handshake always starts a new noise session, even if the stream is known
+			if found {
 				noiseStream, err := noise.NewNoiseStreamReceiver(v.host.PrivateKey(), v.host.PeerPublicKey(dstID), []byte(v.config.PreSharedKey))
 				if err != nil {
+					v.events.Publish(events.Event{Type: events.HANDSHAKE_FAILED, PeerID: dstID.String(), Detail: err.Error()})
 					return 0, fmt.Errorf("failed to create noise stream on receiver side: %s", err)
 				}
 				reply, err := noiseStream.DoHandshake(p.networkPacket)
 				if err != nil {
+					v.events.Publish(events.Event{Type: events.HANDSHAKE_FAILED, PeerID: dstID.String(), Detail: err.Error()})
 					return 0, fmt.Errorf("failed to noise handshake: %s", err)
 				}
 				if reply != nil {
@@ -230,6 +296,9 @@ func (v *VPNInterface) Write(b []byte) (int, error) {
 					if err != nil {
 						return 0, fmt.Errorf("failed to write msg into incomingStream: %s", err)
 					}
+					if err := v.replyMTU(soloStream.Stream, noiseStream, dstID); err != nil {
+						return 0, fmt.Errorf("failed to write MTU into incomingStream: %s", err)
+					}
 				}
 
 				soloStream.NoiseStream = noiseStream
@@ -240,16 +309,49 @@ func (v *VPNInterface) Write(b []byte) (int, error) {
 			if err != nil {
 				return n, fmt.Errorf("packet has been dropped by InboundChain: %s", err)
 			}
+			if v.config.TAP && v.learnMAC != nil && len(ioProcessedPacket.networkPacket) >= ETHERNET_HEADER_SIZE {
+				v.learnMAC(Frame(ioProcessedPacket.networkPacket).SrcMAC().String(), p.header.GetSrcID().String())
+			} else if !v.config.TAP {
+				clampMSS(ioProcessedPacket.networkPacket, v.pathMTU(p.header.GetSrcID()))
+			}
 			_, err = v.writeToNetworkInterface(ioProcessedPacket.networkPacket)
 			if err != nil {
 				_, err2 := io.Copy(v.buffer, p)
 				return n, fmt.Errorf("network write error: %s, packet buffer %s", err, err2)
 			}
+		case VPN_MTU.Uint8():
+			ioProcessedPacket, err := v.InboundChain(p)
+			if err != nil {
+				return n, fmt.Errorf("packet has been dropped by InboundChain: %s", err)
+			}
+			v.readMTU(p.header.GetSrcID(), ioProcessedPacket.networkPacket)
 		}
 	}
 	return n, nil
 }
 
+// maxPacketSize bounds the packets read from a stream, a packet of the
+// interface with its link header and the VPN, noise and compression overhead
+func (v *VPNInterface) maxPacketSize() int {
+	return v.config.InterfaceMTU + v.frameOverhead() + MTU_OVERHEAD
+}
+
+// frameOverhead is the size of the link header read along the MTU
+func (v *VPNInterface) frameOverhead() int {
+	if v.config.TAP {
+		return ETHERNET_MAX_HEADER_SIZE
+	}
+	return 0
+}
+
+// deviceType is the water device type of the interface
+func (v *VPNInterface) deviceType() water.DeviceType {
+	if v.config.TAP {
+		return water.TAP
+	}
+	return water.TUN
+}
+
 func (v *VPNInterface) getOutboundStreamKey(dstID peer.ID) string {
 	return v.host.ID().String() + dstID.String()
 }
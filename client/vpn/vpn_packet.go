@@ -3,6 +3,7 @@ package vpn
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
 
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -13,6 +14,10 @@ const (
 	PEER_ID_SIZE               = 38
 	VPN_DATA     VPNPacketType = iota
 	VPN_NOISEHANDSHAKE
+	// VPN_MTU announces the sender interface MTU, see mtu.go
+	VPN_MTU
+	// VPN_REFUSED answers the handshake of a peer denied by our PeerACL
+	VPN_REFUSED
 )
 
 type VPNPacketType uint8
@@ -109,6 +114,22 @@ func (p *VPNPacket) Write(b []byte) (int, error) {
 	return n, nil
 }
 
+// readVPNPacket reads exactly one VPNPacket from r, of at most maxSize bytes
+func readVPNPacket(r io.Reader, maxSize int) (*VPNPacket, error) {
+	p := &VPNPacket{}
+	if err := binary.Read(r, binary.BigEndian, &p.header); err != nil {
+		return nil, err
+	}
+	if int64(p.header.Size) > int64(maxSize) {
+		return nil, fmt.Errorf("packet of %d bytes is larger than %d", p.header.Size, maxSize)
+	}
+	p.networkPacket = make(Packet, p.header.Size)
+	if _, err := io.ReadFull(r, p.networkPacket); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
 func (p VPNPacket) Equal(b VPNPacket) bool {
 	return p.header.Size == b.header.Size && bytes.Equal(p.networkPacket, b.networkPacket)
 }
@@ -212,3 +212,17 @@ func (s *VPNPacketTestSuite) TestVPNPacketTest() {
 
 	s.True(vpnPacket.Equal(emptyVPNPacket))
 }
+
+func (s *VPNPacketTestSuite) TestReadVPNPacket() {
+	b := &bytes.Buffer{}
+	_, err := io.Copy(b, NewVPNPacket(VPN_DATA, make(Packet, 100), nil, nil))
+	s.NoError(err)
+
+	p, err := readVPNPacket(bytes.NewReader(b.Bytes()), 100)
+	s.NoError(err)
+	s.Len(p.networkPacket, 100)
+
+	// The size comes from the peer, larger packets are refused before allocating them
+	_, err = readVPNPacket(bytes.NewReader(b.Bytes()), 99)
+	s.Error(err)
+}
@@ -0,0 +1,64 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/gfleury/solo/client/events"
+)
+
+var (
+	eventsFollow bool
+	eventsTypes  []string
+	eventsAPI    string
+)
+
+var eventsCmd = &cobra.Command{
+	Use:   "events",
+	Short: "Show the peer lifecycle events of the running node",
+	Long:  "Show the recent peers found, joined, left and missing, routes learned, streams opened and failed handshakes",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		query := url.Values{}
+		if eventsFollow {
+			query.Set("follow", "true")
+		}
+		for _, t := range eventsTypes {
+			query.Add("type", t)
+		}
+
+		resp, err := http.Get(fmt.Sprintf("http://%s/api/v1/events?%s", eventsAPI, query.Encode()))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode > 399 {
+			return fmt.Errorf("HTTP Error: %s", resp.Status)
+		}
+
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			e := events.Event{}
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				return err
+			}
+			fmt.Printf("%s %-16s %-52s %-15s %s\n", e.Time.Format(time.RFC3339), e.Type, e.PeerID, e.IP, e.Detail)
+		}
+		return scanner.Err()
+	},
+}
+
+func init() {
+	eventsCmd.Flags().BoolVarP(&eventsFollow, "follow", "f", false, "Keep showing the new events")
+	eventsCmd.Flags().StringArrayVar(&eventsTypes, "type", []string{}, "Only show the events of this type")
+	eventsCmd.Flags().StringVar(&eventsAPI, "api", CONTROL_API_ADDRESS, "Control API address of the node")
+	rootCmd.AddCommand(eventsCmd)
+}
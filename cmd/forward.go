@@ -0,0 +1,23 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var forwardCmd = &cobra.Command{
+	Use:   "forward [bind:]port:host:port[/udp]...",
+	Short: "Forward ports to the network members over libp2p, without IP routing",
+	Long:  "Run the node and forward the local ports to the members ports, by hostname, IP or peer ID, the ports must be exposed by the member with --expose. Reverse forwards, host:port:[address:]port[/udp], make the member listen on its loopback port and need --allow-reverse-forward on it. With --userspace no VPN interface is created",
+	Run: func(cmd *cobra.Command, args []string) {
+		config.Forwards = append(config.Forwards, args...)
+		runNode(nil)
+	},
+}
+
+func init() {
+	forwardCmd.Flags().StringArrayVarP(&config.ReverseForwards, "reverse", "R", []string{}, "Reverse forward, host:port:[address:]port[/udp]")
+	rootCmd.AddCommand(forwardCmd)
+}
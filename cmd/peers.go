@@ -0,0 +1,67 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	"github.com/gfleury/solo/client/node"
+)
+
+// PeerConnection is the connection of the VPN stream to a peer, direct or relayed
+type PeerConnection struct {
+	PeerID     string
+	Connection string
+}
+
+var peersAPI string
+
+var peersCmd = &cobra.Command{
+	Use:   "peers",
+	Short: "Show the peers of the running node and how their VPN streams are connected",
+	Long:  "Show the peers the running node has a VPN stream to, and if the stream goes direct or through a relay",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		resp, err := http.Get(fmt.Sprintf("http://%s/api/v1/peers", peersAPI))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode > 399 {
+			return fmt.Errorf("HTTP Error: %s", resp.Status)
+		}
+
+		peers := []PeerConnection{}
+		if err := json.NewDecoder(resp.Body).Decode(&peers); err != nil {
+			return err
+		}
+		for _, p := range peers {
+			fmt.Printf("%-52s %s\n", p.PeerID, p.Connection)
+		}
+		return nil
+	},
+}
+
+// peersHandler serves the connection type of the VPN stream to each peer of e
+func peersHandler(e *node.Node) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		peers := []PeerConnection{}
+		for peerID, connectionType := range e.ConnectionTypes() {
+			peers = append(peers, PeerConnection{PeerID: peerID.String(), Connection: connectionType.String()})
+		}
+		sort.Slice(peers, func(i, j int) bool { return peers[i].PeerID < peers[j].PeerID })
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(peers)
+	}
+}
+
+func init() {
+	peersCmd.Flags().StringVar(&peersAPI, "api", CONTROL_API_ADDRESS, "Control API address of the node")
+	rootCmd.AddCommand(peersCmd)
+}
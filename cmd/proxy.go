@@ -0,0 +1,46 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/spf13/cobra"
+
+	"github.com/gfleury/solo/client/node"
+	"github.com/gfleury/solo/client/proxy"
+)
+
+var (
+	proxyListen string
+)
+
+var proxyCmd = &cobra.Command{
+	Use:   "proxy",
+	Short: "Serve a SOCKS5 and HTTP proxy into the network, without a VPN interface",
+	Long:  "Run the node on a userspace network stack and proxy the SOCKS5, HTTP CONNECT and plain HTTP requests to the network members, by IP or hostname",
+	Run: func(cmd *cobra.Command, args []string) {
+		config.Userspace = true
+		runNode(func(e *node.Node) error {
+			l, err := net.Listen("tcp", proxyListen)
+			if err != nil {
+				return fmt.Errorf("failed to listen proxy: %w", err)
+			}
+			go func() {
+				<-e.Done()
+				l.Close()
+			}()
+
+			s := proxy.NewServer(e.Logger(), e.Netstack().DialContext, e.LookupHostname)
+			go s.Serve(l)
+			return nil
+		})
+	},
+}
+
+func init() {
+	proxyCmd.Flags().StringVar(&proxyListen, "listen", "127.0.0.1:1080", "Proxy listen address")
+	rootCmd.AddCommand(proxyCmd)
+}
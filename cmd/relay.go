@@ -0,0 +1,25 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var relayCmd = &cobra.Command{
+	Use:   "relay",
+	Short: "Run the node as a relay for the network members",
+	Long: `Run the node as a circuit relay for the members of its network, so the
+NATed members can reach each other through it instead of the public
+rendezvous. Only reservations and connections between network members are
+relayed, within the --relay-max-* limits. Same as --relay-service.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config.RelayService = true
+		runMain(cmd, args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(relayCmd)
+}
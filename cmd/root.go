@@ -10,13 +10,26 @@ import (
 	"net/http/pprof"
 	"os"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 
+	"github.com/gfleury/solo/client/broadcast"
 	configpackage "github.com/gfleury/solo/client/config"
 	"github.com/gfleury/solo/client/node"
 )
 
+const (
+	// CONTROL_API_ADDRESS serves pprof, the metrics and the control API, it
+	// is unauthenticated so only on loopback by default
+	CONTROL_API_ADDRESS = "127.0.0.1:7777"
+)
+
 var (
+	// controlMux is the http mux of the control API
+	controlMux = http.NewServeMux()
+	// controlAPIAddress is where controlMux is served, see --control-api
+	controlAPIAddress = CONTROL_API_ADDRESS
+
 	DEFAULT_DISCOVERY_PEERS = []string{"/dnsaddr/solo-rendezvous.fleury.gg/p2p/12D3KooWGXAXwKmP4Pg3QWUnrghQaJiHLJrKScSVpTUn59hGT7Vh"}
 )
 
@@ -29,6 +42,7 @@ var (
 		Libp2pLogLevel:       "",
 		LogLevel:             "",
 		DiscoveryPeers:       []string{},
+		StaticPeers:          []string{},
 		PublicDiscoveryPeers: false,
 		DiscoveryInterval:    0,
 		InterfaceMTU:         0,
@@ -38,6 +52,7 @@ var (
 		RandomIdentity:       false,
 		RandomPort:           false,
 		PublishLocalRoutes:   false,
+		RelayService:         false,
 	}
 )
 
@@ -50,13 +65,21 @@ var rootCmd = &cobra.Command{
 }
 
 func runMain(cmd *cobra.Command, args []string) {
+	runNode(nil)
+}
 
+// runNode runs the node until it is stopped, started is called once it is up
+func runNode(started func(e *node.Node) error) {
 	e, err := node.NewWithConfig(config)
 	if err != nil {
 		fmt.Printf("failed to create new node: %s\n", err)
 		return
 	}
 
+	controlMux.Handle("/api/v1/events", e.Events())
+	controlMux.Handle("/api/v1/peers", peersHandler(e))
+	go http.ListenAndServe(controlAPIAddress, controlMux)
+
 	ctx := context.Background()
 
 	go handleStopSignals(e)
@@ -66,6 +89,16 @@ func runMain(cmd *cobra.Command, args []string) {
 		fmt.Printf("failed to start node: %s\n", err)
 		return
 	}
+
+	if started != nil {
+		if err := started(e); err != nil {
+			fmt.Printf("%s\n", err)
+			e.Stop()
+			return
+		}
+	}
+
+	<-e.Done()
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -75,24 +108,47 @@ func Execute() {
 	rootCmd.PersistentFlags().StringVarP(&config.InterfaceAddress, "address", "a", "192.168.254.0/24", "TUN interface ip address")
 	rootCmd.PersistentFlags().StringVarP(&config.InterfaceName, "interface", "i", "utun0", "TUN interface name")
 	rootCmd.PersistentFlags().BoolVarP(&config.CreateInterface, "create-iface", "c", true, "Create TUN network interface")
+	rootCmd.PersistentFlags().BoolVar(&config.Userspace, "userspace", false, "Run the VPN on a userspace network stack instead of a TUN interface, without root")
+	rootCmd.PersistentFlags().BoolVar(&config.TAP, "tap", false, "Bridge Ethernet frames on a TAP interface instead of routing IP packets on a TUN one")
+	rootCmd.PersistentFlags().StringVar(&config.Bridge, "bridge", "", "Attach the TAP interface to this bridge")
 	rootCmd.PersistentFlags().BoolVarP(&config.PublishLocalRoutes, "publish-local-routes", "r", false, "Publish local routes to other hosts")
 	rootCmd.PersistentFlags().StringVar(&config.Libp2pLogLevel, "libp2p-log-level", "error", "Libp2p log level")
 	rootCmd.PersistentFlags().StringVarP(&config.LogLevel, "log-level", "l", "info", "Log level")
+	rootCmd.PersistentFlags().StringVar(&controlAPIAddress, "control-api", CONTROL_API_ADDRESS, "Address of the control API, metrics and pprof, unauthenticated")
 	rootCmd.PersistentFlags().StringArrayVarP(&config.DiscoveryPeers, "discovery-peers", "d", DEFAULT_DISCOVERY_PEERS, "Discovery peers addresss")
+	rootCmd.PersistentFlags().StringArrayVar(&config.StaticPeers, "peer", []string{}, "Static peer address (/ip4/.../p2p/...), always kept connected")
 	rootCmd.PersistentFlags().IntVarP(&config.DiscoveryInterval, "discovery-interval", "I", 10, "Discovery peers interval")
-	rootCmd.PersistentFlags().IntVarP(&config.InterfaceMTU, "interface-mtu", "m", 1412, "Discovery peers interval")
+	rootCmd.PersistentFlags().IntVarP(&config.InterfaceMTU, "interface-mtu", "m", 0, "TUN interface MTU, 0 selects it from the host links")
 	rootCmd.PersistentFlags().IntVarP(&config.MaxConnections, "max-connections", "M", 256, "Maximum peer connections")
 	rootCmd.PersistentFlags().BoolVarP(&config.HolePunch, "hole-punch", "H", true, "Enable holepunch to bypass NAT")
 	rootCmd.PersistentFlags().BoolVarP(&config.PublicDiscoveryPeers, "public", "p", false, "Enable public discovery peers")
 	rootCmd.PersistentFlags().BoolVarP(&config.StandaloneMode, "standalone", "s", false, "Enable standalone mode")
+	rootCmd.PersistentFlags().StringVar(&config.Broadcaster, "broadcaster", broadcast.BROADCASTER_STREAM, "Broadcaster implementation: stream or gossipsub")
+	rootCmd.PersistentFlags().BoolVar(&config.MDNSDiscovery, "mdns", false, "Enable mDNS discovery of local network peers")
+	rootCmd.PersistentFlags().BoolVar(&config.DisableDHT, "disable-dht", false, "Disable DHT discovery")
+	rootCmd.PersistentFlags().BoolVar(&config.PrivateDHT, "private-dht", false, "Use a DHT private to the network members instead of the public IPFS DHT")
+	rootCmd.PersistentFlags().StringArrayVar(&config.DNSDiscovery, "dns-discovery", []string{}, "Discover peers from the TXT records of a domain")
+	rootCmd.PersistentFlags().StringArrayVar(&config.HTTPDiscovery, "http-discovery", []string{}, "Discover peers from a JSON directory URL")
+	rootCmd.PersistentFlags().BoolVar(&config.CoreAPIDiscovery, "coreapi-discovery", true, "Discover the network peers from core-api")
+	rootCmd.PersistentFlags().BoolVar(&config.Offline, "offline", false, "LAN only mode, use only mDNS discovery (needs standalone mode)")
+	rootCmd.PersistentFlags().StringArrayVar(&config.Hooks, "hook", []string{}, "Run a command on events, [event,...=]command, with the event in SOLO_* variables and as JSON in stdin")
+	rootCmd.PersistentFlags().StringArrayVar(&config.Webhooks, "webhook", []string{}, "Post the events as JSON to an URL, [event,...=]url")
+	rootCmd.PersistentFlags().StringArrayVar(&config.PostUp, "post-up", []string{}, "Run a command once the VPN interface is up")
+	rootCmd.PersistentFlags().StringArrayVar(&config.PostDown, "post-down", []string{}, "Run a command when the VPN interface is torn down")
+	rootCmd.PersistentFlags().StringArrayVar(&config.Expose, "expose", []string{}, "Let the network members forward to a loopback port, port[/udp]")
+	rootCmd.PersistentFlags().BoolVar(&config.AllowReverseForward, "allow-reverse-forward", false, "Let the network members listen on our loopback ports with reverse forwards")
+	rootCmd.PersistentFlags().BoolVar(&config.RelayService, "relay-service", false, "Relay connections for the network members")
+	rootCmd.PersistentFlags().IntVar(&config.RelayMaxReservations, "relay-max-reservations", 0, "Maximum relay reservations (0 uses libp2p default)")
+	rootCmd.PersistentFlags().IntVar(&config.RelayMaxCircuits, "relay-max-circuits", 0, "Maximum relayed connections per peer (0 uses libp2p default)")
+	rootCmd.PersistentFlags().IntVar(&config.RelayMaxDuration, "relay-max-duration", 0, "Maximum duration in seconds of a relayed connection (0 uses libp2p default)")
+	rootCmd.PersistentFlags().Int64Var(&config.RelayMaxData, "relay-max-data", 0, "Maximum bytes relayed on each direction of a connection (0 uses libp2p default)")
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/debug/pprof/", pprof.Index)
-	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
-	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
-	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
-	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
-	go http.ListenAndServe(":7777", mux)
+	controlMux.HandleFunc("/debug/pprof/", pprof.Index)
+	controlMux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	controlMux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	controlMux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	controlMux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	controlMux.Handle("/metrics", promhttp.Handler())
 
 	err := rootCmd.Execute()
 	if err != nil {
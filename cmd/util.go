@@ -27,9 +27,7 @@ func handleStopSignals(node *node.Node) {
 	signal.Notify(s, os.Interrupt, syscall.SIGTERM)
 
 	for range s {
-		node.Host().Network().Close()
-		node.Host().ConnManager().Close()
-		node.Host().Close()
+		node.Stop()
 
 		os.Exit(0)
 	}
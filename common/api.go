@@ -1,6 +1,9 @@
 package common
 
-import "github.com/gfleury/solo/common/models"
+import (
+	"github.com/gfleury/solo/common/models"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
 
 type RegistrationResponse struct {
 	Code string
@@ -22,6 +25,8 @@ type ConnectionConfigurationRequest struct {
 type ConnectionConfigurationResponse struct {
 	ConnectionConfigToken string
 	InterfaceAddress      string
+	// AddressCertificate is the core-api signature of AddressCertificateMessage
+	AddressCertificate []byte
 }
 
 type NextIP struct {
@@ -33,3 +38,7 @@ type NodeUpdateRequest struct {
 	Node           models.NetworkNode
 	SignedHostname string
 }
+
+type NetworkPeersResponse struct {
+	Peers []peer.AddrInfo
+}
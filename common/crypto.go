@@ -2,8 +2,18 @@ package common
 
 import (
 	"crypto/ed25519"
+	"net"
 )
 
 var NodeAuthenticationTokenOptions = &ed25519.Options{
 	Context: "Solo_Node_Authentication",
 }
+
+// AddressCertificateMessage is the message core-api signs to certify that the
+// overlay ip, with or without its network mask, is assigned to peerID
+func AddressCertificateMessage(peerID, ip string) []byte {
+	if addr, _, err := net.ParseCIDR(ip); err == nil {
+		ip = addr.String()
+	}
+	return []byte("Solo_Address_Certificate:" + peerID + ":" + ip)
+}
@@ -156,3 +156,26 @@ func (s *SoloAPIP2PClient) UpdateNode(updateRequest NodeUpdateRequest) (int, err
 	resp, err := s.client.Post(fmt.Sprintf("%s/api/v1/node", s.address), "application/json", bytes.NewReader(b))
 	return resp.StatusCode, err
 }
+
+func (s *SoloAPIP2PClient) GetNetworkPeers() ([]peer.AddrInfo, error) {
+	resp, err := s.client.Get(fmt.Sprintf("%s/api/v1/node/peers", s.address))
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 399 {
+		return nil, fmt.Errorf("HTTP Error: %s", resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &NetworkPeersResponse{}
+
+	err = json.Unmarshal(body, r)
+
+	return r.Peers, err
+}
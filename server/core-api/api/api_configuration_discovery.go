@@ -16,6 +16,9 @@ import (
 
 var challenges = map[string]string{}
 
+// SignAddress certifies the node IP, set to the rendezvous host key signer
+var SignAddress = func(peerID, ip string) ([]byte, error) { return nil, nil }
+
 func GetConnectionConfigurationChallenge(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	var request common.ConnectionConfigurationChallengeRequest
@@ -91,9 +94,16 @@ func GetConnectionConfiguration(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	certificate, err := SignAddress(networkNode.PeerID, networkNode.IP)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	response := common.ConnectionConfigurationResponse{
 		ConnectionConfigToken: networkNode.Network.ConnectionConfigToken,
 		InterfaceAddress:      networkNode.IP,
+		AddressCertificate:    certificate,
 	}
 
 	JsonResponse(&response, http.StatusOK, w)
@@ -14,9 +14,14 @@ import (
 	"github.com/gfleury/solo/server/core-api/db"
 	"github.com/gfleury/solo/server/core-api/jwt"
 	"github.com/gorilla/mux"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
 	"gorm.io/gorm/clause"
 )
 
+// PeerAddrs returns the known addresses of a peer, set to the rendezvous host peerstore
+var PeerAddrs = func(peer.ID) []multiaddr.Multiaddr { return nil }
+
 func AddNetwork(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	var n models.Network
@@ -229,3 +234,39 @@ func DeleteNode(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// GetNetworkPeers returns the addresses of the other activated nodes of the
+// requester network, the requester is the authenticated libp2p remote peer
+func GetNetworkPeers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	db_handler := db.GetDB(r.Context())
+
+	networkNode := models.NetworkNode{}
+	result := db_handler.Where("peer_id = ? AND actived = ?", r.RemoteAddr, true).First(&networkNode)
+	if result.Error != nil || networkNode.NetworkID == nil {
+		http.Error(w, "Node not found or not activated", http.StatusNotFound)
+		return
+	}
+
+	nodes := []models.NetworkNode{}
+	result = db_handler.Where("network_id = ? AND actived = ? AND peer_id <> ?", *networkNode.NetworkID, true, networkNode.PeerID).Find(&nodes)
+	if result.Error != nil {
+		http.Error(w, result.Error.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := common.NetworkPeersResponse{Peers: []peer.AddrInfo{}}
+	for _, node := range nodes {
+		peerID, err := peer.Decode(node.PeerID)
+		if err != nil {
+			continue
+		}
+		addrs := PeerAddrs(peerID)
+		if len(addrs) == 0 {
+			continue
+		}
+		response.Peers = append(response.Peers, peer.AddrInfo{ID: peerID, Addrs: addrs})
+	}
+
+	JsonResponse(&response, http.StatusOK, w)
+}
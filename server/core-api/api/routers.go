@@ -88,6 +88,12 @@ var p2pRoutes = Routes{
 		"/api/v1/node/connnection_configuration",
 		GetConnectionConfiguration,
 	},
+	Route{
+		"GetNetworkPeers",
+		"GET",
+		"/api/v1/node/peers",
+		GetNetworkPeers,
+	},
 }
 
 var routes = Routes{
@@ -31,3 +31,16 @@ func FetchAllNetworksThatRequestHasAccess(db_handler *gorm.DB, r *http.Request)
 	}
 	return networks, nil
 }
+
+// NodeRegistry resolves nodes network membership straight from the DB
+type NodeRegistry struct{}
+
+// NetworkOf returns the network ID of an activated node
+func (NodeRegistry) NetworkOf(peerID string) (uint, bool) {
+	networkNode := models.NetworkNode{}
+	result := db.Where("peer_id = ? AND actived = ?", peerID, true).First(&networkNode)
+	if result.Error != nil || networkNode.NetworkID == nil {
+		return 0, false
+	}
+	return *networkNode.NetworkID, true
+}
@@ -9,14 +9,20 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"os"
 
 	"github.com/gfleury/solo/server/core-api/api"
 	"github.com/gfleury/solo/server/core-api/db"
 	"github.com/gfleury/solo/server/core-api/jwt"
 	"github.com/gfleury/solo/server/core-api/rendezvous"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/cors"
 )
 
+const (
+	DEFAULT_METRICS_ADDRESS = ":9091"
+)
+
 func main() {
 	log.Printf("Server started")
 	ctx, cancelFunc := context.WithCancel(context.Background())
@@ -32,6 +38,28 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Relay only for activated nodes of the same network
+	l.SetNodeRegistry(db.NodeRegistry{})
+
+	// Nodes addresses served to the members of their network
+	api.PeerAddrs = l.PeerAddrs
+	// Nodes IPs are certified with the rendezvous host key
+	api.SignAddress = l.SignAddress
+
+	// Prometheus metrics, an empty METRICS_ADDRESS disables them
+	metricsAddress, found := os.LookupEnv("METRICS_ADDRESS")
+	if !found {
+		metricsAddress = DEFAULT_METRICS_ADDRESS
+	}
+	if metricsAddress != "" {
+		go func() {
+			err := http.ListenAndServe(metricsAddress, promhttp.Handler())
+			if err != nil {
+				log.Printf("Metrics server stopped: %s", err)
+			}
+		}()
+	}
+
 	go func() {
 		err := http.Serve(l.HTTPListener, p2pRouter)
 		if err != nil {
@@ -1,26 +1,158 @@
 package rendezvous
 
 import (
+	"sync"
+	"time"
+
 	"github.com/gfleury/solo/client/logger"
 	"github.com/libp2p/go-libp2p/core/peer"
 	ma "github.com/multiformats/go-multiaddr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// How long a node membership lookup is cached
+	aclCacheTTL = time.Minute
+	// Relay requests allowed per second for each peer, and its burst
+	aclRateLimit = 1
+	aclRateBurst = 10
+	// Limiters are dropped past this amount of peers to bound memory
+	aclMaxLimiters = 4096
+)
+
+var relayACLDecisions = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "solo",
+		Subsystem: "relay_acl",
+		Name:      "decisions_total",
+		Help:      "Relay ACL decisions by action (reserve, connect) and result (allowed, denied, rate_limited)",
+	},
+	[]string{"action", "result"},
 )
 
+// NodeRegistry resolves the network of registered nodes
+type NodeRegistry interface {
+	// NetworkOf returns the network ID of peerID if it's a registered and activated node
+	NetworkOf(peerID string) (uint, bool)
+}
+
+type aclCacheEntry struct {
+	networkID uint
+	member    bool
+	expires   time.Time
+}
+
 // ACLFilter is an Access Control mechanism for relayed connect.
 type ACLFilter struct {
-	logger *logger.Logger
+	sync.Mutex
+
+	logger   *logger.Logger
+	registry NodeRegistry
+	// generation counts the registries set, lookups of a replaced one aren't cached
+	generation uint64
+	cache      map[peer.ID]aclCacheEntry
+	limiters   map[peer.ID]*rate.Limiter
+}
+
+func NewACLFilter(logger *logger.Logger) *ACLFilter {
+	return &ACLFilter{
+		logger:   logger,
+		cache:    map[peer.ID]aclCacheEntry{},
+		limiters: map[peer.ID]*rate.Limiter{},
+	}
+}
+
+// SetRegistry enables the enforcement, without a registry every peer is allowed
+func (a *ACLFilter) SetRegistry(registry NodeRegistry) {
+	a.Lock()
+	defer a.Unlock()
+	a.registry = registry
+	a.generation++
+	a.cache = map[peer.ID]aclCacheEntry{}
 }
 
 // AllowReserve returns true if a reservation from a peer with the given peer ID and multiaddr
 // is allowed.
 func (a *ACLFilter) AllowReserve(p peer.ID, src ma.Multiaddr) bool {
 	a.logger.Debugf("AllowReserve from Peer ID: %s addr: %s", p, src)
-	return true
+
+	if !a.allowRate(p) {
+		return a.decision("reserve", "rate_limited", false)
+	}
+
+	if _, member := a.networkOf(p); !member {
+		a.logger.Infof("Relay reservation denied for Peer ID: %s addr: %s, not an activated node", p, src)
+		return a.decision("reserve", "denied", false)
+	}
+
+	return a.decision("reserve", "allowed", true)
 }
 
 // AllowConnect returns true if a source peer, with a given multiaddr is allowed to connect
 // to a destination peer.
 func (a *ACLFilter) AllowConnect(src peer.ID, srcAddr ma.Multiaddr, dst peer.ID) bool {
 	a.logger.Debugf("AllowConnect from Peer ID %s to ID %s addr: %s", src, dst, srcAddr)
-	return true
+
+	if !a.allowRate(src) {
+		return a.decision("connect", "rate_limited", false)
+	}
+
+	srcNetwork, srcMember := a.networkOf(src)
+	dstNetwork, dstMember := a.networkOf(dst)
+	if !srcMember || !dstMember || srcNetwork != dstNetwork {
+		a.logger.Infof("Relay connection denied from Peer ID %s to ID %s, not on the same network", src, dst)
+		return a.decision("connect", "denied", false)
+	}
+
+	return a.decision("connect", "allowed", true)
+}
+
+func (a *ACLFilter) decision(action, result string, allowed bool) bool {
+	relayACLDecisions.WithLabelValues(action, result).Inc()
+	return allowed
+}
+
+func (a *ACLFilter) allowRate(p peer.ID) bool {
+	a.Lock()
+	defer a.Unlock()
+	limiter, found := a.limiters[p]
+	if !found {
+		if len(a.limiters) >= aclMaxLimiters {
+			a.limiters = map[peer.ID]*rate.Limiter{}
+		}
+		limiter = rate.NewLimiter(aclRateLimit, aclRateBurst)
+		a.limiters[p] = limiter
+	}
+	return limiter.Allow()
+}
+
+// networkOf returns the network of p and if it may use the relay at all
+func (a *ACLFilter) networkOf(p peer.ID) (uint, bool) {
+	a.Lock()
+	registry, generation := a.registry, a.generation
+	if registry == nil {
+		a.Unlock()
+		// No registry configured, everyone is on the same network
+		return 0, true
+	}
+
+	if entry, found := a.cache[p]; found && time.Now().Before(entry.expires) {
+		a.Unlock()
+		return entry.networkID, entry.member
+	}
+	a.Unlock()
+
+	// The registry queries the database, the other decisions don't wait for it
+	networkID, member := registry.NetworkOf(p.String())
+
+	a.Lock()
+	defer a.Unlock()
+	// Not cached if the registry was replaced meanwhile
+	if a.generation == generation {
+		a.cache[p] = aclCacheEntry{networkID: networkID, member: member, expires: time.Now().Add(aclCacheTTL)}
+	}
+
+	return networkID, member
 }
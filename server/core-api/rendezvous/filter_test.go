@@ -0,0 +1,72 @@
+package rendezvous
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gfleury/solo/client/logger"
+	"github.com/ipfs/go-log"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeRegistry map[string]uint
+
+func (f fakeRegistry) NetworkOf(peerID string) (uint, bool) {
+	networkID, found := f[peerID]
+	return networkID, found
+}
+
+func TestACLFilter(t *testing.T) {
+	a := peer.ID("peerA")
+	b := peer.ID("peerB")
+	c := peer.ID("peerC")
+	stranger := peer.ID("stranger")
+
+	acl := NewACLFilter(logger.New(log.LevelDebug))
+
+	// Without registry the relay is open
+	require.True(t, acl.AllowReserve(stranger, nil))
+
+	acl.SetRegistry(fakeRegistry{a.String(): 1, b.String(): 1, c.String(): 2})
+
+	require.True(t, acl.AllowReserve(a, nil))
+	require.False(t, acl.AllowReserve(stranger, nil))
+
+	require.True(t, acl.AllowConnect(a, nil, b))
+	require.False(t, acl.AllowConnect(a, nil, c))
+	require.False(t, acl.AllowConnect(stranger, nil, a))
+
+	// Burst exhausted, peer gets rate limited
+	for i := 0; i < aclRateBurst; i++ {
+		acl.AllowReserve(c, nil)
+	}
+	require.False(t, acl.AllowReserve(c, nil))
+}
+
+// slowRegistry blocks the lookups until release is closed
+type slowRegistry struct {
+	release chan struct{}
+}
+
+func (s slowRegistry) NetworkOf(peerID string) (uint, bool) {
+	<-s.release
+	return 1, true
+}
+
+func TestACLFilterSlowRegistry(t *testing.T) {
+	acl := NewACLFilter(logger.New(log.LevelDebug))
+	registry := slowRegistry{release: make(chan struct{})}
+	acl.SetRegistry(registry)
+
+	reserved := make(chan bool)
+	go func() { reserved <- acl.AllowReserve(peer.ID("peerA"), nil) }()
+
+	// The pending lookup doesn't hold the other decisions
+	require.Eventually(t, func() bool { return acl.allowRate(peer.ID("peerB")) }, time.Second, 10*time.Millisecond)
+
+	close(registry.release)
+	require.True(t, <-reserved)
+	_, cached := acl.cache[peer.ID("peerA")]
+	require.True(t, cached)
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/gfleury/solo/client/discovery"
 	"github.com/gfleury/solo/client/logger"
 	"github.com/gfleury/solo/client/node"
+	"github.com/gfleury/solo/common"
 	"github.com/multiformats/go-multiaddr"
 
 	"github.com/libp2p/go-libp2p"
@@ -33,6 +34,7 @@ type RendezvousHost struct {
 	host         host.Host
 	dht          *dht.IpfsDHT
 	relay        *relay.Relay
+	acl          *ACLFilter
 	logger       *logger.Logger
 	HTTPListener net.Listener
 }
@@ -41,6 +43,7 @@ func NewRendezvousHost(ctx context.Context, logger *logger.Logger, name string,
 	rendezvous := &RendezvousHost{
 		ctx:    ctx,
 		logger: logger,
+		acl:    NewACLFilter(logger),
 	}
 
 	if name != "" {
@@ -99,7 +102,7 @@ func NewRendezvousHost(ctx context.Context, logger *logger.Logger, name string,
 	limit := relay.DefaultLimit()
 	resources := relay.DefaultResources()
 
-	rendezvous.relay, err = relay.New(rendezvous.host, relay.WithLimit(limit), relay.WithResources(resources), relay.WithACL(&ACLFilter{logger}))
+	rendezvous.relay, err = relay.New(rendezvous.host, relay.WithLimit(limit), relay.WithResources(resources), relay.WithACL(rendezvous.acl))
 	if err != nil {
 		return nil, err
 	}
@@ -121,6 +124,21 @@ func (r *RendezvousHost) Start() error {
 	return nil
 }
 
+// SetNodeRegistry restricts the relay to activated nodes of the same network
+func (r *RendezvousHost) SetNodeRegistry(registry NodeRegistry) {
+	r.acl.SetRegistry(registry)
+}
+
+// PeerAddrs returns the addresses the rendezvous host knows for a peer
+func (r *RendezvousHost) PeerAddrs(id peer.ID) []multiaddr.Multiaddr {
+	return r.host.Peerstore().Addrs(id)
+}
+
+// SignAddress issues the address certificate of a node with the host key
+func (r *RendezvousHost) SignAddress(peerID, ip string) ([]byte, error) {
+	return r.host.Peerstore().PrivKey(r.host.ID()).Sign(common.AddressCertificateMessage(peerID, ip))
+}
+
 func (r *RendezvousHost) GetAddrs() (discovery.AddrList, error) {
 	// print the node's PeerInfo in multiaddr format
 	peerInfo := peer.AddrInfo{